@@ -14,16 +14,19 @@ import (
 
 func main() {
 
-	server, err := server.NewServer()
+	server, workers, err := server.NewServer()
 	if err != nil {
 		log.Fatalf("failed to connect to store: %v\n", err)
 	}
 
+	// start background workers
+	workers.Start()
+
 	// create a done channel to signal when the shutdown is complete
 	done := make(chan struct{}, 1)
 
 	// run graceful shutdown in a separate goroutine
-	go gracefulShutdown(server, done)
+	go gracefulShutdown(server, workers, done)
 
 	log.Printf("Server starting on %s", server.Addr)
 	err = server.ListenAndServe()
@@ -36,7 +39,7 @@ func main() {
 	log.Println("Graceful shutdown complete.")
 }
 
-func gracefulShutdown(apiServer *http.Server, done chan struct{}) {
+func gracefulShutdown(apiServer *http.Server, workers *server.Workers, done chan struct{}) {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -55,6 +58,13 @@ func gracefulShutdown(apiServer *http.Server, done chan struct{}) {
 		log.Printf("Server forced to shutdown with error: %v", err)
 	}
 
+	// Give background jobs in progress 10 seconds to finish before they are returned to the queue
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := workers.Stop(ctx); err != nil {
+		log.Printf("Background workers forced to stop with error: %v", err)
+	}
+
 	log.Println("Server exiting")
 
 	// Notify the main goroutine that the shutdown is complete
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"sgs/internal/config"
+	"sgs/internal/database"
+	"sgs/internal/importer"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/store"
+	"sgs/internal/utils"
+
+	"github.com/google/uuid"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+const usage = `sgsctl is the operator command line for sgs
+
+Usage:
+  sgsctl <command> [flags]
+
+Commands:
+  create-admin  create the first admin, or promote an existing user to it
+  buckets       list buckets in the store that are not managed as projects
+  adopt         import an existing bucket as a project owned by a user
+  import        copy a bucket from an external S3-compatible endpoint into a project
+  resume        resume an interrupted or failed import
+`
+
+// app holds the dependencies shared by the commands
+type app struct {
+	userRepo   *repository.UserRepository
+	importRepo *repository.ImportRepository
+	importer   *importer.Importer
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// stop long running commands on interrupt. interrupted imports can be resumed by running the command again
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	a, err := newApp()
+	if err != nil {
+		log.Fatalf("failed to initialize: %v\n", err)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "create-admin":
+		err = a.createAdmin(ctx, args)
+	case "buckets":
+		err = a.listBuckets(ctx, args)
+	case "adopt":
+		err = a.adoptBucket(ctx, args)
+	case "import":
+		err = a.importExternal(ctx, args)
+	case "resume":
+		err = a.resumeImport(ctx, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v\n", command, err)
+	}
+}
+
+func newApp() (*app, error) {
+	cfg, err := config.New()
+	if err != nil {
+		return nil, err
+	}
+	store, err := store.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db, err := database.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	projectRepo := repository.NewProjectRepository(db.DB)
+	fileRepo := repository.NewFileRepository(db.DB)
+	importRepo := repository.NewImportRepository(db.DB)
+
+	return &app{
+		userRepo:   repository.NewUserRepository(db.DB),
+		importRepo: importRepo,
+		importer:   importer.New(projectRepo, fileRepo, importRepo, store, cfg.JwtSecret, cfg.ReservedBuckets()),
+	}, nil
+}
+
+// createAdmin bootstraps the first admin. An existing user is promoted, otherwise a new user is created with a random password that is
+// printed once. Further admins are managed through the admin api
+func (a *app) createAdmin(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := fs.String("username", "", "username of the admin")
+	email := fs.String("email", "", "email address of a new admin, considered verified")
+	fs.Parse(args)
+
+	if *username == "" {
+		fs.Usage()
+		return errors.New("username is required")
+	}
+
+	admins, err := a.userRepo.CountAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if admins > 0 {
+		return errors.New("an admin already exists. Manage admins through the admin api")
+	}
+
+	user, err := a.userRepo.GetUserByUsername(ctx, *username)
+	if err == nil {
+		if err := a.userRepo.SetRole(ctx, nil, user.ID, models.RoleAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("promoted user %s (%s) to admin\n", user.Username, user.ID)
+		return nil
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return err
+	}
+
+	password, err := utils.GenerateToken(18)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	var emailAddress *string
+	if *email != "" {
+		emailAddress = email
+	}
+
+	tx, err := a.userRepo.GetTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user, err = a.userRepo.CreateUser(ctx, tx, *username, hashedPassword, emailAddress, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.userRepo.SetRole(ctx, tx, user.ID, models.RoleAdmin); err != nil {
+		return err
+	}
+	if emailAddress != nil {
+		if err := a.userRepo.MarkEmailVerified(ctx, tx, user.ID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("created admin %s (%s)\npassword: %s\nthe password is not shown again. Change it after logging in\n", user.Username, user.ID, password)
+	return nil
+}
+
+// listBuckets prints the buckets that can be adopted
+func (a *app) listBuckets(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("buckets", flag.ExitOnError)
+	fs.Parse(args)
+
+	buckets, err := a.importer.UnmanagedBuckets(ctx)
+	if err != nil {
+		return err
+	}
+	if len(buckets) == 0 {
+		fmt.Println("no unmanaged buckets found")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BUCKET\tCREATED")
+	for _, bucket := range buckets {
+		fmt.Fprintf(tw, "%s\t%s\n", bucket.Name, bucket.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	return tw.Flush()
+}
+
+// adoptBucket imports a bucket as a project and scans its objects in the foreground. Running the command again for a bucket with an
+// unfinished import resumes it
+func (a *app) adoptBucket(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+	bucket := fs.String("bucket", "", "name of the bucket to adopt")
+	owner := fs.String("owner", "", "username of the project owner")
+	fs.Parse(args)
+
+	if *bucket == "" || *owner == "" {
+		fs.Usage()
+		return errors.New("bucket and owner are required")
+	}
+
+	user, err := a.userRepo.GetUserByUsername(ctx, *owner)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve owner %s: %w", *owner, err)
+	}
+
+	imp, err := a.importer.AdoptBucket(ctx, *bucket, user.ID)
+	if errors.Is(err, importer.ErrBucketManaged) {
+		// resume an unfinished import of the bucket
+		imp, err = a.importRepo.GetUnfinishedImportByBucket(ctx, *bucket)
+		if errors.Is(err, repository.ErrImportNotFound) {
+			return importer.ErrBucketManaged
+		}
+		if err == nil {
+			fmt.Printf("resuming import %s after %d objects\n", imp.ID, imp.ObjectCount)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("importing bucket %s as project %s\n", imp.Bucket, imp.ProjectID)
+	return a.processImport(ctx, imp.ID)
+}
+
+// importExternal copies a bucket from an external endpoint into a project in the foreground
+func (a *app) importExternal(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	project := fs.String("project", "", "id of the target project")
+	endpoint := fs.String("endpoint", "", "host and port of the source endpoint")
+	secure := fs.Bool("secure", false, "connect to the source over https")
+	bucket := fs.String("bucket", "", "name of the source bucket")
+	prefix := fs.String("prefix", "", "only copy objects under this prefix")
+	accessKey := fs.String("access-key", "", "access key of the source")
+	secretKey := fs.String("secret-key", os.Getenv("SOURCE_SECRET_KEY"), "secret key of the source. Defaults to $SOURCE_SECRET_KEY")
+	fs.Parse(args)
+
+	projectID, err := uuid.Parse(*project)
+	if err != nil {
+		fs.Usage()
+		return fmt.Errorf("invalid project id: %w", err)
+	}
+	if *endpoint == "" || *bucket == "" || *accessKey == "" || *secretKey == "" {
+		fs.Usage()
+		return errors.New("endpoint, bucket, access key and secret key are required")
+	}
+
+	source := models.ImportSource{Endpoint: *endpoint, Secure: *secure, Bucket: *bucket, Prefix: *prefix, AccessKey: *accessKey}
+	imp, err := a.importer.ImportExternal(ctx, projectID, source, *secretKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("import %s: copying %s/%s into bucket %s\n", imp.ID, *endpoint, *bucket, imp.Bucket)
+	return a.processImport(ctx, imp.ID)
+}
+
+// resumeImport continues an import from its last imported object
+func (a *app) resumeImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	id := fs.String("id", "", "id of the import")
+	fs.Parse(args)
+
+	importID, err := uuid.Parse(*id)
+	if err != nil {
+		fs.Usage()
+		return fmt.Errorf("invalid import id: %w", err)
+	}
+	return a.processImport(ctx, importID)
+}
+
+// processImport runs an import to completion while periodically printing its progress
+func (a *app) processImport(ctx context.Context, id uuid.UUID) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- a.importer.Process(ctx, id)
+	}()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			a.printProgress(id)
+			return nil
+		case <-ticker.C:
+			a.printProgress(id)
+		}
+	}
+}
+
+func (a *app) printProgress(id uuid.UUID) {
+	imp, err := a.importRepo.GetImportByID(context.Background(), id)
+	if err != nil {
+		log.Printf("failed to retrieve import progress: %v\n", err)
+		return
+	}
+	if imp.Progress != nil {
+		fmt.Printf("%s: %d/%d objects, %s/%s (%.1f%%)\n", imp.Status, imp.ObjectCount, *imp.SourceObjectCount,
+			utils.FormatStorageSize(imp.TotalBytes), utils.FormatStorageSize(*imp.SourceTotalBytes), *imp.Progress)
+		return
+	}
+	fmt.Printf("%s: %d objects (%s)\n", imp.Status, imp.ObjectCount, utils.FormatStorageSize(imp.TotalBytes))
+}
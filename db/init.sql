@@ -62,4 +62,357 @@ CREATE TABLE IF NOT EXISTS api_keys(
 -- 	expires_at TIMESTAMPTZ NOT NULL,
 -- 	revoked_at TIMESTAMPTZ,
 -- 	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
--- );
\ No newline at end of file
+-- );
+
+-- role of users. (user, admin)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+-- create imports. tracks the progress of existing buckets being adopted as projects
+CREATE TABLE IF NOT EXISTS imports(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	-- delete import history when project is deleted
+	project_id UUID REFERENCES projects(id) ON DELETE CASCADE NOT NULL,
+	owner_id UUID REFERENCES users(id) NOT NULL,
+	bucket VARCHAR(255) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending', -- (pending, running, completed, failed)
+	-- name of the last imported object. listing resumes after it
+	cursor VARCHAR(1000) NOT NULL DEFAULT '',
+	object_count BIGINT NOT NULL DEFAULT 0,
+	total_bytes BIGINT NOT NULL DEFAULT 0,
+	error TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	completed_at TIMESTAMPTZ
+);
+
+-- adopted and imported objects can be larger than 2 GiB. a no-op once the column is BIGINT
+ALTER TABLE files ALTER COLUMN size TYPE BIGINT;
+
+-- external imports copy objects from another S3-compatible endpoint into an existing project
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_endpoint VARCHAR(255);
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_secure BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_bucket VARCHAR(255);
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_prefix VARCHAR(1000) NOT NULL DEFAULT '';
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_access_key VARCHAR(255);
+-- encrypted with the server secret and cleared once the import completes
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_secret_key TEXT;
+-- totals counted from the source before copying, used to report progress
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_object_count BIGINT;
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS source_total_bytes BIGINT;
+
+-- create jobs. a durable queue of background work claimed by workers with FOR UPDATE SKIP LOCKED
+CREATE TABLE IF NOT EXISTS jobs(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	type VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL DEFAULT 'queued', -- (queued, running, succeeded, dead)
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 5,
+	-- earliest time the job can be claimed. pushed back on every retry
+	run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	-- refreshed by the worker while the job runs so that abandoned jobs can be detected
+	locked_at TIMESTAMPTZ,
+	locked_by VARCHAR(255),
+	last_error TEXT,
+	-- optional user that requested the job
+	user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS jobs_claim_idx ON jobs(type, run_at) WHERE status = 'queued';
+
+-- create scheduled_tasks. periodic tasks run by the elected scheduler leader along with the outcome of their last run
+CREATE TABLE IF NOT EXISTS scheduled_tasks(
+	name VARCHAR(100) PRIMARY KEY,
+	schedule VARCHAR(100) NOT NULL,
+	-- scheduled time of the last claimed run. a run is only claimed once so that it never executes twice
+	last_run_at TIMESTAMPTZ,
+	last_status VARCHAR(20), -- (running, succeeded, failed)
+	last_error TEXT,
+	last_duration_ms BIGINT,
+	-- scheduler instance that ran the task last
+	last_run_by VARCHAR(255),
+	next_run_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- create sessions. each row holds a hashed refresh token. refreshing replaces the row with a new one in the same family so that the
+-- reuse of a replaced token can be detected and the whole family revoked
+CREATE TABLE IF NOT EXISTS sessions(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	-- delete all sessions when user is deleted
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	-- shared by all refresh tokens rotated from the same login
+	family_id UUID NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	-- time of the login that started the family
+	authenticated_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	-- set once the refresh token has been exchanged for a new one
+	replaced_by UUID,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_family_idx ON sessions(family_id);
+
+-- device details of sessions, refreshed whenever the refresh token is exchanged
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- optional email of users, used to deliver password resets. unique regardless of case
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users(LOWER(email));
+
+-- create password_resets. single-use tokens delivered by email, stored hashed
+CREATE TABLE IF NOT EXISTS password_resets(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- whether the email of a user was verified. existing users are considered verified when the column is added
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
+
+-- create email_verifications. single-use tokens proving ownership of an email, stored hashed
+CREATE TABLE IF NOT EXISTS email_verifications(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- create invites. codes minted by admins to register when registration is invite-only, stored hashed
+CREATE TABLE IF NOT EXISTS invites(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	code_hash VARCHAR(64) UNIQUE NOT NULL,
+	-- optional email the invite is restricted to
+	email VARCHAR(255),
+	max_uses INTEGER NOT NULL DEFAULT 1,
+	uses INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ,
+	-- admin that minted the invite. empty for invites minted from the command line
+	created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- totp two-factor authentication of users. the secret is encrypted and only enabled once a code from it has been verified. the
+-- counter of the last accepted code is kept so that codes cannot be replayed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter BIGINT;
+
+-- create recovery_codes. single-use codes that replace a totp code when the authenticator is lost, stored hashed
+CREATE TABLE IF NOT EXISTS recovery_codes(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	code_hash VARCHAR(64) NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+	UNIQUE(user_id, code_hash)
+);
+
+-- create mfa_challenges. short-lived tokens issued after the password step of a login and exchanged for a session with a second
+-- factor, stored hashed
+CREATE TABLE IF NOT EXISTS mfa_challenges(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	-- failed codes submitted for the challenge. the challenge is unusable once the limit is reached
+	attempts INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- create user_identities. links users to their accounts at external identity providers
+CREATE TABLE IF NOT EXISTS user_identities(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	provider VARCHAR(50) NOT NULL, -- (oidc, ldap)
+	-- stable ID of the user at the provider
+	subject VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+	UNIQUE(provider, subject)
+);
+
+-- create oidc_states. pending single sign-on logins, keyed by the hashed state sent to the provider
+CREATE TABLE IF NOT EXISTS oidc_states(
+	state_hash VARCHAR(64) PRIMARY KEY,
+	code_verifier VARCHAR(255) NOT NULL,
+	nonce VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- create oidc_login_codes. single-use codes handed to the web app after a single sign-on and exchanged for a session, stored hashed
+CREATE TABLE IF NOT EXISTS oidc_login_codes(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	code_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- create signing_keys. asymmetric keys signing the jwts issued for each purpose (access, share). retired keys no longer sign but keep
+-- verifying tokens until they expire. private keys are sealed with the jwt secret
+CREATE TABLE IF NOT EXISTS signing_keys(
+	id VARCHAR(64) PRIMARY KEY,
+	purpose VARCHAR(20) NOT NULL,
+	algorithm VARCHAR(20) NOT NULL, -- (EdDSA, RS256)
+	private_key TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	retired_at TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS signing_keys_purpose_idx ON signing_keys(purpose, created_at);
+
+-- create login_failures. recent failed logins per account and per client ip. further logins are refused until locked_until, which
+-- grows with every failure and becomes a lockout once the limit is reached
+CREATE TABLE IF NOT EXISTS login_failures(
+	scope VARCHAR(20) NOT NULL, -- (account, ip)
+	-- username of accounts, including unknown ones, or the client ip
+	subject VARCHAR(255) NOT NULL,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	locked_until TIMESTAMPTZ,
+
+	PRIMARY KEY(scope, subject)
+);
+
+-- disabled users cannot login and their sessions and api keys stop working
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+-- set by admins to make a user choose a new password through the reset flow before they can login again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- create account_exports. archives of the projects, files and metadata of a user, stored in the export bucket until they expire
+CREATE TABLE IF NOT EXISTS account_exports(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending', -- (pending, running, completed, failed)
+	-- name of the archive in the export bucket, set once completed
+	object_name VARCHAR(1000),
+	size BIGINT NOT NULL DEFAULT 0,
+	error TEXT,
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS account_exports_user_id_idx ON account_exports(user_id);
+
+-- preferences of users. keys missing from the document take their default value
+ALTER TABLE users ADD COLUMN IF NOT EXISTS preferences JSONB NOT NULL DEFAULT '{}';
+
+-- create personal_access_tokens. tokens acting as their user across all their projects, kept apart from the project api keys
+CREATE TABLE IF NOT EXISTS personal_access_tokens(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	-- sha256 digest of the token, which is only shown once
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	-- leading characters of the token to tell tokens apart
+	token_prefix VARCHAR(20) NOT NULL,
+	-- NULL for tokens that never expire
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+	-- unique name per user
+	UNIQUE(user_id, name)
+);
+
+-- api keys are stored as the sha256 digest of their token along with its leading characters, which identify the key when
+-- authenticating. the token column is no longer written, and the tokens of existing keys are migrated to digests and cleared
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS token_hash VARCHAR(64);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS token_prefix VARCHAR(20);
+ALTER TABLE api_keys ALTER COLUMN token DROP NOT NULL;
+UPDATE api_keys
+SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex'), token_prefix = left(token, 12), token = NULL
+WHERE token IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_token_hash_idx ON api_keys(token_hash);
+CREATE INDEX IF NOT EXISTS api_keys_token_prefix_idx ON api_keys(token_prefix);
+
+-- space separated scopes granted to api keys. existing keys keep access to the files of their project but cannot manage other keys
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT 'files:read files:write files:delete share:create';
+
+-- rotation of api keys. a rotated key links to its successor and keeps working until revokes_at, when it is revoked
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rotated_from UUID REFERENCES api_keys(id) ON DELETE SET NULL;
+-- deferred so that a key can point to its successor before the successor is inserted under the same name
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS replaced_by UUID REFERENCES api_keys(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revokes_at TIMESTAMPTZ;
+-- successors take the name of the key they replace
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_name_project_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_name_project_id_idx ON api_keys(name, project_id) WHERE replaced_by IS NULL;
+
+-- last request authenticated with each api key
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_ip VARCHAR(45);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_user_agent TEXT;
+
+-- create api_key_usage. requests and body bytes per api key and day, in UTC
+CREATE TABLE IF NOT EXISTS api_key_usage(
+	api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE NOT NULL,
+	day DATE NOT NULL,
+	requests BIGINT NOT NULL DEFAULT 0,
+	bytes_in BIGINT NOT NULL DEFAULT 0,
+	bytes_out BIGINT NOT NULL DEFAULT 0,
+
+	PRIMARY KEY(api_key_id, day)
+);
+
+-- api key restrictions. addresses and times the key can be used from
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS restrictions JSONB NOT NULL DEFAULT '{}';
+
+-- create api_key_denials. requests made with an api key that were denied, and why
+CREATE TABLE IF NOT EXISTS api_key_denials(
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE NOT NULL,
+	reason VARCHAR(20) NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	user_agent TEXT NOT NULL,
+	method VARCHAR(10) NOT NULL,
+	path TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS api_key_denials_api_key_id_idx ON api_key_denials(api_key_id, created_at);
+
+-- api key signing mode. signing secrets are sealed with the jwt secret
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret TEXT;
+
+-- create api_key_nonces. nonces of signed requests, kept until their signature falls out of the skew window
+CREATE TABLE IF NOT EXISTS api_key_nonces(
+	api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE NOT NULL,
+	nonce VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+
+	PRIMARY KEY(api_key_id, nonce)
+);
+CREATE INDEX IF NOT EXISTS api_key_nonces_expires_at_idx ON api_key_nonces(expires_at);
+
+-- create storage_usage. files and bytes stored per project and day, in UTC, snapshotted by the scheduler
+CREATE TABLE IF NOT EXISTS storage_usage(
+	project_id UUID REFERENCES projects(id) ON DELETE CASCADE NOT NULL,
+	day DATE NOT NULL,
+	files BIGINT NOT NULL DEFAULT 0,
+	bytes BIGINT NOT NULL DEFAULT 0,
+
+	PRIMARY KEY(project_id, day)
+);
@@ -0,0 +1,132 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"sgs/internal/repository"
+	"sgs/internal/store"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DeleteJobType identifies account deletion jobs in the job queue
+	DeleteJobType = "account.delete"
+	// ExportJobType identifies account export jobs in the job queue
+	ExportJobType = "account.export"
+)
+
+// DeleteJob is the payload of an account deletion job
+type DeleteJob struct {
+	UserID uuid.UUID `json:"userId"`
+}
+
+// ExportJob is the payload of an account export job
+type ExportJob struct {
+	ExportID uuid.UUID `json:"exportId"`
+}
+
+// Manager runs the long-running operations on whole user accounts
+type Manager struct {
+	userRepo    *repository.UserRepository
+	projectRepo *repository.ProjectRepository
+	fileRepo    *repository.FileRepository
+	apiKeyRepo  *repository.APIKeyRepository
+	sessionRepo *repository.SessionRepository
+	tokenRepo   *repository.PersonalAccessTokenRepository
+	exportRepo  *repository.AccountExportRepository
+	store       *store.Store
+	// bucket storing the export archives, which can be downloaded for the retention
+	exportBucket    string
+	exportRetention time.Duration
+}
+
+// New creates a new account manager
+func New(userRepo *repository.UserRepository, projectRepo *repository.ProjectRepository, fileRepo *repository.FileRepository,
+	apiKeyRepo *repository.APIKeyRepository, sessionRepo *repository.SessionRepository, tokenRepo *repository.PersonalAccessTokenRepository,
+	exportRepo *repository.AccountExportRepository, store *store.Store, exportBucket string, exportRetention time.Duration) *Manager {
+	return &Manager{
+		userRepo:        userRepo,
+		projectRepo:     projectRepo,
+		fileRepo:        fileRepo,
+		apiKeyRepo:      apiKeyRepo,
+		sessionRepo:     sessionRepo,
+		tokenRepo:       tokenRepo,
+		exportRepo:      exportRepo,
+		store:           store,
+		exportBucket:    exportBucket,
+		exportRetention: exportRetention,
+	}
+}
+
+// HandleDelete processes an account deletion job from the job queue. Failed attempts are retried by the queue and skip what was
+// already removed
+func (m *Manager) HandleDelete(ctx context.Context, job DeleteJob) error {
+	return m.Delete(ctx, job.UserID)
+}
+
+// Delete removes a user along with their projects, the buckets of the projects and the objects in them, and their export archives. The
+// objects are removed first so that a failure leaves the user and their projects in place to be retried. Users that no longer exist are
+// considered deleted
+func (m *Manager) Delete(ctx context.Context, userID uuid.UUID) error {
+	if _, err := m.userRepo.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	projects, err := m.projectRepo.GetProjectsByOwnerID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		if err := m.removeBucket(ctx, project.Bucket); err != nil {
+			return fmt.Errorf("failed to remove bucket %s of project %s: %w", project.Bucket, project.ID, err)
+		}
+	}
+	exports, err := m.exportRepo.GetAccountExportsByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err := m.removeExportArchive(ctx, export); err != nil {
+			return fmt.Errorf("failed to remove archive of export %s: %w", export.ID, err)
+		}
+	}
+
+	tx, err := m.userRepo.GetTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.userRepo.DeleteUser(ctx, tx, userID); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("deleted user %s along with %d projects\n", userID, len(projects))
+	return nil
+}
+
+// removeBucket empties and removes a bucket. Buckets that do not exist are skipped
+func (m *Manager) removeBucket(ctx context.Context, bucket string) error {
+	if err := m.store.EmptyBucket(ctx, bucket); err != nil {
+		return err
+	}
+	exists, err := m.store.BucketExists(ctx, bucket)
+	if err != nil || !exists {
+		return err
+	}
+	return m.store.RemoveBucket(ctx, bucket)
+}
@@ -0,0 +1,287 @@
+package accounts
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrExportBucketInUse = errors.New("export bucket is managed as a project")
+)
+
+// content type of the export archives
+const archiveContentType = "application/zip"
+
+// archivedProject is a project along with the metadata of its files as written to an export archive
+type archivedProject struct {
+	*models.Project
+	Files []*models.File `json:"files"`
+}
+
+// HandleExport processes an account export job from the job queue. Failed attempts are retried by the queue and build the archive again
+func (m *Manager) HandleExport(ctx context.Context, job ExportJob) error {
+	return m.Export(ctx, job.ExportID)
+}
+
+// Export builds the archive of an export and stores it in the export bucket. The export is marked as failed when an error occurs, except
+// when the context is cancelled, in which case it is returned to pending to be built again later
+func (m *Manager) Export(ctx context.Context, id uuid.UUID) error {
+	export, err := m.exportRepo.GetAccountExportByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrAccountExportNotFound) {
+			// the user was deleted in the meantime
+			return nil
+		}
+		return err
+	}
+	if export.Status == models.ExportCompleted {
+		return nil
+	}
+	if err := m.exportRepo.UpdateAccountExportStatus(ctx, export.ID, models.ExportRunning, nil); err != nil {
+		return err
+	}
+
+	objectName, size, err := m.buildArchive(ctx, export)
+	if err != nil {
+		status := models.ExportFailed
+		var errMsg *string
+		if ctx.Err() != nil {
+			status = models.ExportPending
+		} else {
+			msg := err.Error()
+			errMsg = &msg
+		}
+		if err := m.exportRepo.UpdateAccountExportStatus(context.Background(), export.ID, status, errMsg); err != nil {
+			log.Printf("failed to mark export %s as %s: %v\n", export.ID, status, err)
+		}
+		return err
+	}
+
+	log.Printf("export %s of user %s completed\n", export.ID, export.UserID)
+	return m.exportRepo.CompleteAccountExport(ctx, export.ID, objectName, size, time.Now().Add(m.exportRetention))
+}
+
+// OpenExport opens the archive of a completed export for download
+func (m *Manager) OpenExport(ctx context.Context, export *models.AccountExport) (io.ReadCloser, models.Object, error) {
+	if export.ObjectName == nil {
+		return nil, models.Object{}, repository.ErrAccountExportNotFound
+	}
+	return m.store.OpenObject(ctx, m.exportBucket, *export.ObjectName)
+}
+
+// PurgeExpiredExports removes the expired exports along with their archives and returns the number of removed exports
+func (m *Manager) PurgeExpiredExports(ctx context.Context) (int, error) {
+	exports, err := m.exportRepo.GetExpiredAccountExports(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for i, export := range exports {
+		if err := m.removeExportArchive(ctx, export); err != nil {
+			return i, err
+		}
+		if err := m.exportRepo.DeleteAccountExport(ctx, export.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(exports), nil
+}
+
+// buildArchive writes the archive of an export to a temporary file, so that its size is known, and uploads it to the export bucket.
+// The name and size of the stored archive are returned
+func (m *Manager) buildArchive(ctx context.Context, export *models.AccountExport) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "sgs-export-*.zip")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	archive := zip.NewWriter(tmp)
+	if err := m.writeArchive(ctx, archive, export.UserID); err != nil {
+		return "", 0, err
+	}
+	if err := archive.Close(); err != nil {
+		return "", 0, err
+	}
+	size, err := tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := m.ensureExportBucket(ctx); err != nil {
+		return "", 0, err
+	}
+	objectName := path.Join(export.UserID.String(), export.ID.String()+".zip")
+	object := models.Object{Name: objectName, Size: size, ContentType: archiveContentType}
+	if _, err := m.store.PutObject(ctx, m.exportBucket, object, tmp); err != nil {
+		return "", 0, err
+	}
+	return objectName, size, nil
+}
+
+// writeArchive writes the profile, projects, files, api keys, personal access tokens and sessions of a user to an archive. The metadata is written as JSON
+// documents and the contents of the files under the files directory, by bucket and object name. Files uploaded by the user to projects
+// of other users are included along with the files of their own projects
+func (m *Manager) writeArchive(ctx context.Context, archive *zip.Writer, userID uuid.UUID) error {
+	user, err := m.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "account.json", user); err != nil {
+		return err
+	}
+
+	projects, err := m.projectRepo.GetProjectsByOwnerID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	archived := make([]archivedProject, 0, len(projects))
+	buckets := map[uuid.UUID]string{}
+	files := []*models.File{}
+	for _, project := range projects {
+		projectID := project.ID
+		projectFiles, err := m.fileRepo.GetFiles(ctx, &projectID)
+		if err != nil {
+			return err
+		}
+		archived = append(archived, archivedProject{Project: project, Files: projectFiles})
+		buckets[project.ID] = project.Bucket
+		files = append(files, projectFiles...)
+	}
+	if err := writeJSON(archive, "projects.json", archived); err != nil {
+		return err
+	}
+
+	// files uploaded to the projects of other users
+	uploaded, err := m.fileRepo.GetFilesByOwnerID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	shared := []*models.File{}
+	for _, file := range uploaded {
+		if _, ok := buckets[file.ProjectID]; ok {
+			continue
+		}
+		project, err := m.projectRepo.GetProjectByID(ctx, file.ProjectID)
+		if err != nil {
+			return err
+		}
+		buckets[file.ProjectID] = project.Bucket
+		shared = append(shared, file)
+	}
+	if err := writeJSON(archive, "shared-files.json", shared); err != nil {
+		return err
+	}
+	files = append(files, shared...)
+
+	keys, err := m.apiKeyRepo.GetAPIKeysByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "api-keys.json", keys); err != nil {
+		return err
+	}
+
+	// only the metadata of personal access tokens is marshaled
+	tokens, err := m.tokenRepo.GetPersonalAccessTokensByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "personal-access-tokens.json", tokens); err != nil {
+		return err
+	}
+
+	sessions, err := m.sessionRepo.GetActiveSessionsByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "sessions.json", sessions); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		bucket := buckets[file.ProjectID]
+		if err := m.writeObject(ctx, archive, bucket, file); err != nil {
+			return fmt.Errorf("failed to archive file %s: %w", file.ID, err)
+		}
+	}
+	return nil
+}
+
+// writeObject copies the content of a file into the archive. Files whose object no longer exists are skipped
+func (m *Manager) writeObject(ctx context.Context, archive *zip.Writer, bucket string, file *models.File) error {
+	reader, _, err := m.store.OpenObject(ctx, bucket, file.ObjectName)
+	if err != nil {
+		if store.IsNotFound(err) {
+			log.Printf("skipping missing object %s/%s of file %s in export\n", bucket, file.ObjectName, file.ID)
+			return nil
+		}
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     path.Join("files", bucket, file.ObjectName),
+		Method:   zip.Deflate,
+		Modified: file.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+// ensureExportBucket creates the export bucket when it does not exist. The bucket is refused when a project uses it, so that archives
+// are never visible to the owner of the project
+func (m *Manager) ensureExportBucket(ctx context.Context) error {
+	if _, err := m.projectRepo.GetProjectByBucket(ctx, m.exportBucket); err == nil {
+		return fmt.Errorf("%w: %s", ErrExportBucketInUse, m.exportBucket)
+	} else if !errors.Is(err, repository.ErrProjectNotFound) {
+		return err
+	}
+
+	exists, err := m.store.BucketExists(ctx, m.exportBucket)
+	if err != nil || exists {
+		return err
+	}
+	return m.store.CreateBucket(ctx, m.exportBucket, false)
+}
+
+// removeExportArchive removes the archive of an export from the export bucket. Exports without an archive are skipped
+func (m *Manager) removeExportArchive(ctx context.Context, export *models.AccountExport) error {
+	if export.ObjectName == nil {
+		return nil
+	}
+	err := m.store.RemoveObject(ctx, m.exportBucket, *export.ObjectName)
+	if store.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// writeJSON writes a value as an indented JSON document to the archive
+func writeJSON(archive *zip.Writer, name string, value any) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
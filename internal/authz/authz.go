@@ -0,0 +1,99 @@
+package authz
+
+import (
+	"errors"
+	"sgs/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrForbidden is returned when a principal cannot perform an action on a resource
+var ErrForbidden = errors.New("you don't have access to this resource")
+
+// Action is an operation on a resource
+type Action string
+
+// actions on projects, and on the files and api keys in them
+const (
+	ActionCreateProject Action = "project:create"
+	ActionReadProject   Action = "project:read"
+	ActionDeleteProject Action = "project:delete"
+	// uploading and listing files act on their project
+	ActionUploadFile Action = "file:upload"
+	ActionListFiles  Action = "file:list"
+	ActionReadFile   Action = "file:read"
+	ActionDeleteFile Action = "file:delete"
+	ActionShareFile  Action = "file:share"
+	// creating and listing the api keys of a project act on the project, other operations on the key
+	ActionManageAPIKeys Action = "api-key:manage"
+)
+
+// apiKeyScopes lists the actions open to api keys and the scope each needs. Api keys cannot perform other actions
+var apiKeyScopes = map[Action]string{
+	ActionReadProject:   models.ScopeFilesRead,
+	ActionUploadFile:    models.ScopeFilesWrite,
+	ActionListFiles:     models.ScopeFilesRead,
+	ActionReadFile:      models.ScopeFilesRead,
+	ActionDeleteFile:    models.ScopeFilesDelete,
+	ActionShareFile:     models.ScopeShareCreate,
+	ActionManageAPIKeys: models.ScopeKeysManage,
+}
+
+// APIKeyScope returns the scope an api key needs for an action, and false when api keys cannot perform it
+func APIKeyScope(action Action) (string, bool) {
+	scope, ok := apiKeyScopes[action]
+	return scope, ok
+}
+
+// Principal is the caller a request acts on behalf of
+type Principal struct {
+	UserID uuid.UUID
+	// api key authenticating the request, which only reaches the resources of its project with its scopes. Nil for users and
+	// personal access tokens, which act as their user
+	APIKey *models.APIKey
+}
+
+// Resource is what an action applies to
+type Resource struct {
+	// user owning the resource. Files belong to the owner of their project
+	OwnerID uuid.UUID
+	// project of the resource, or the project itself
+	ProjectID uuid.UUID
+}
+
+// ProjectResource returns the resource of a project
+func ProjectResource(project *models.Project) Resource {
+	return Resource{OwnerID: project.OwnerID, ProjectID: project.ID}
+}
+
+// FileResource returns the resource of a file. The file must carry the owner of its project
+func FileResource(file *models.File) Resource {
+	return Resource{OwnerID: file.ProjectOwnerID, ProjectID: file.ProjectID}
+}
+
+// APIKeyResource returns the resource of an api key, owned by the user that created it
+func APIKeyResource(key *models.APIKey) Resource {
+	return Resource{OwnerID: key.UserID, ProjectID: key.ProjectID}
+}
+
+// Authorize decides whether a principal can perform an action on a resource. Users only reach the resources they own, and api keys
+// additionally only the resources of their project with the scope of the action. [ErrForbidden] is returned otherwise
+func Authorize(principal Principal, action Action, resource Resource) error {
+	if principal.UserID == uuid.Nil || resource.OwnerID == uuid.Nil {
+		return ErrForbidden
+	}
+	if key := principal.APIKey; key != nil {
+		scope, ok := APIKeyScope(action)
+		if !ok || !key.HasScope(scope) || key.UserID != principal.UserID {
+			return ErrForbidden
+		}
+		// keys cannot create projects, so every action open to them is on a resource of a project
+		if resource.ProjectID != key.ProjectID {
+			return ErrForbidden
+		}
+	}
+	if resource.OwnerID != principal.UserID {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+
+	"sgs/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// actions lists every action, so that new actions are covered by the tests
+var actions = []Action{
+	ActionCreateProject, ActionReadProject, ActionDeleteProject, ActionUploadFile, ActionListFiles, ActionReadFile, ActionDeleteFile,
+	ActionShareFile, ActionManageAPIKeys,
+}
+
+func TestAuthorize(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	aliceProject := &models.Project{ID: uuid.New(), OwnerID: alice}
+	aliceOtherProject := &models.Project{ID: uuid.New(), OwnerID: alice}
+	bobProject := &models.Project{ID: uuid.New(), OwnerID: bob}
+	aliceFile := &models.File{ID: uuid.New(), ProjectID: aliceProject.ID, ProjectOwnerID: alice}
+	bobFile := &models.File{ID: uuid.New(), ProjectID: bobProject.ID, ProjectOwnerID: bob}
+	// files uploaded by another user still belong to the owner of their project
+	aliceFileUploadedByBob := &models.File{ID: uuid.New(), ProjectID: aliceProject.ID, UploadedBy: bob, ProjectOwnerID: alice}
+
+	allScopes := models.APIKeyScopes
+	aliceKey := &models.APIKey{ID: uuid.New(), ProjectID: aliceProject.ID, UserID: alice, Scopes: allScopes}
+	aliceReadKey := &models.APIKey{ID: uuid.New(), ProjectID: aliceProject.ID, UserID: alice, Scopes: []string{models.ScopeFilesRead}}
+	bobKey := &models.APIKey{ID: uuid.New(), ProjectID: bobProject.ID, UserID: bob, Scopes: allScopes}
+
+	user := func(id uuid.UUID) Principal { return Principal{UserID: id} }
+	key := func(key *models.APIKey) Principal { return Principal{UserID: key.UserID, APIKey: key} }
+
+	tests := []struct {
+		name      string
+		principal Principal
+		action    Action
+		resource  Resource
+		allowed   bool
+	}{
+		// owners
+		{"owner creates a project", user(alice), ActionCreateProject, Resource{OwnerID: alice}, true},
+		{"owner reads project", user(alice), ActionReadProject, ProjectResource(aliceProject), true},
+		{"owner deletes project", user(alice), ActionDeleteProject, ProjectResource(aliceProject), true},
+		{"owner uploads", user(alice), ActionUploadFile, ProjectResource(aliceProject), true},
+		{"owner lists files", user(alice), ActionListFiles, ProjectResource(aliceProject), true},
+		{"owner reads file", user(alice), ActionReadFile, FileResource(aliceFile), true},
+		{"owner reads file uploaded by another user", user(alice), ActionReadFile, FileResource(aliceFileUploadedByBob), true},
+		{"owner deletes file", user(alice), ActionDeleteFile, FileResource(aliceFile), true},
+		{"owner shares file", user(alice), ActionShareFile, FileResource(aliceFile), true},
+		{"owner manages project keys", user(alice), ActionManageAPIKeys, ProjectResource(aliceProject), true},
+		{"owner manages key", user(alice), ActionManageAPIKeys, APIKeyResource(aliceKey), true},
+
+		// other users
+		{"user creates a project for another user", user(bob), ActionCreateProject, Resource{OwnerID: alice}, false},
+		{"uploader of a file in another project", user(bob), ActionReadFile, FileResource(aliceFileUploadedByBob), false},
+		{"user manages key of another user", user(bob), ActionManageAPIKeys, APIKeyResource(aliceKey), false},
+
+		// api keys
+		{"key reads its project", key(aliceKey), ActionReadProject, ProjectResource(aliceProject), true},
+		{"key uploads to its project", key(aliceKey), ActionUploadFile, ProjectResource(aliceProject), true},
+		{"key reads file of its project", key(aliceKey), ActionReadFile, FileResource(aliceFile), true},
+		{"key manages keys of its project", key(aliceKey), ActionManageAPIKeys, ProjectResource(aliceProject), true},
+		{"key creates a project", key(aliceKey), ActionCreateProject, Resource{OwnerID: alice}, false},
+		{"key deletes its project", key(aliceKey), ActionDeleteProject, ProjectResource(aliceProject), false},
+		{"key reads another project of its user", key(aliceKey), ActionReadProject, ProjectResource(aliceOtherProject), false},
+		{"key reads project of another user", key(aliceKey), ActionReadProject, ProjectResource(bobProject), false},
+		{"key reads file of another user", key(aliceKey), ActionReadFile, FileResource(bobFile), false},
+		{"read key reads file", key(aliceReadKey), ActionReadFile, FileResource(aliceFile), true},
+		{"read key deletes file", key(aliceReadKey), ActionDeleteFile, FileResource(aliceFile), false},
+		{"read key shares file", key(aliceReadKey), ActionShareFile, FileResource(aliceFile), false},
+		{"read key uploads", key(aliceReadKey), ActionUploadFile, ProjectResource(aliceProject), false},
+		{"read key manages keys", key(aliceReadKey), ActionManageAPIKeys, ProjectResource(aliceProject), false},
+		{"key manages key of another user", key(aliceKey), ActionManageAPIKeys, APIKeyResource(bobKey), false},
+		{"key of another user reads project", key(bobKey), ActionReadProject, ProjectResource(aliceProject), false},
+		{"key acting as another user", Principal{UserID: bob, APIKey: aliceKey}, ActionReadProject, ProjectResource(aliceProject), false},
+
+		// anonymous callers and resources without an owner
+		{"anonymous reads project", Principal{}, ActionReadProject, ProjectResource(aliceProject), false},
+		{"file without its project owner", user(alice), ActionReadFile, FileResource(&models.File{ProjectID: aliceProject.ID}), false},
+	}
+	for _, tt := range tests {
+		err := Authorize(tt.principal, tt.action, tt.resource)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: Authorize() = %v; want allowed", tt.name, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s: Authorize() = %v; want %v", tt.name, err, ErrForbidden)
+		}
+	}
+}
+
+func TestAuthorizeDeniesCrossTenantAccess(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	bobProject := &models.Project{ID: uuid.New(), OwnerID: bob}
+	bobFile := &models.File{ID: uuid.New(), ProjectID: bobProject.ID, ProjectOwnerID: bob}
+	bobKey := &models.APIKey{ID: uuid.New(), ProjectID: bobProject.ID, UserID: bob}
+	resources := map[string]Resource{
+		"project": ProjectResource(bobProject),
+		"file":    FileResource(bobFile),
+		"api key": APIKeyResource(bobKey),
+	}
+	aliceKey := &models.APIKey{ID: uuid.New(), ProjectID: uuid.New(), UserID: alice, Scopes: models.APIKeyScopes}
+	principals := map[string]Principal{
+		"user":    {UserID: alice},
+		"api key": {UserID: alice, APIKey: aliceKey},
+	}
+	for principalName, principal := range principals {
+		for resourceName, resource := range resources {
+			for _, action := range actions {
+				if err := Authorize(principal, action, resource); !errors.Is(err, ErrForbidden) {
+					t.Errorf("%s of another tenant can %s the %s: Authorize() = %v", principalName, action, resourceName, err)
+				}
+			}
+		}
+	}
+}
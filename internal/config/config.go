@@ -1,9 +1,15 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
 	"net/url"
 	"os"
+	"sgs/internal/utils"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -18,8 +24,80 @@ type Config struct {
 	StoreAddr     string
 	StoreUser     string
 	StorePassword string
+	// url of the web app, used in links sent to users
+	AppURL *url.URL
+	// mail configs. the driver is either smtp or log
+	MailDriver   string
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// registration configs. see the Registration* constants for the modes
+	RegistrationMode    string
+	AllowedEmailDomains []string
+	// whether users must verify their email before they can login
+	EmailVerification bool
+	// whether every user must enroll in totp two-factor authentication
+	MFARequired bool
+	// openid connect single sign-on configs. sso is disabled when no issuer is set
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	// claim of the id token holding the groups or roles of a user, and the role given to users with each value
+	OIDCRoleClaim   string
+	OIDCRoleMapping map[string]string
+	// ldap configs. logins are checked against the directory when a url is set
+	LDAPURL                string
+	LDAPStartTLS           bool
+	LDAPInsecureSkipVerify bool
+	LDAPBindDN             string
+	LDAPBindPassword       string
+	LDAPBaseDN             string
+	// filter matching the entry of a user. {username} is replaced with the escaped username
+	LDAPUserFilter        string
+	LDAPIDAttribute       string
+	LDAPUsernameAttribute string
+	LDAPEmailAttribute    string
+	LDAPNameAttribute     string
+	// filters matching the entries of users granted each role
+	LDAPRoleFilters map[string]string
+	// jwt signing configs. keys are rotated once they are older than the rotation interval, and retired share keys keep verifying
+	// share links for the retention
+	JWTAlgorithm      string
+	JWTKeyRotation    time.Duration
+	ShareKeyRetention time.Duration
+	// failed logins allowed per account and per client ip before further logins are locked for the lockout duration. failures older
+	// than the lockout duration are forgotten
+	LoginMaxFailures   int
+	LoginMaxIPFailures int
+	LoginLockout       time.Duration
+	// bucket storing the archives of account exports, which are removed once they are older than the retention
+	ExportBucket    string
+	ExportRetention time.Duration
+
+	// how long a rotated api key keeps working alongside its successor, unless the rotation asks for another grace period
+	APIKeyRotationGrace time.Duration
+	// how far the timestamp of a signed api key request can be from the server clock
+	APIKeySignatureSkew time.Duration
+	// proxies in front of the server. Their X-Forwarded-For headers are trusted to carry the client ip
+	TrustedProxies []netip.Prefix
 }
 
+// registration modes
+const (
+	// anyone can register
+	RegistrationOpen = "open"
+	// nobody can register. users are created by operators
+	RegistrationDisabled = "disabled"
+	// registration requires an invite code minted by an admin
+	RegistrationInvite = "invite"
+	// registration requires an email in one of the allowed domains
+	RegistrationDomains = "domains"
+)
+
 // New returns a config object from the env and a non-nil error if validation errors occurred
 func New() (*Config, error) {
 	// validate required fields
@@ -45,11 +123,138 @@ func New() (*Config, error) {
 		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
 
+	// web app URL. defaults to the base URL when the app is served by the server
+	appURL := baseURL
+	if appURLStr := os.Getenv("APP_URL"); appURLStr != "" {
+		if appURL, err = url.Parse(appURLStr); err != nil {
+			return nil, fmt.Errorf("invalid app URL: %w", err)
+		}
+	}
+
 	// storage configs
 	storeAddr := os.Getenv("STORE_ADDR")
 	storeUser := os.Getenv("STORE_USER")
 	storePassword := os.Getenv("STORE_PASSWORD")
 
+	// mail configs
+	mailDriver := getEnvOrDefault("MAIL_DRIVER", "log")
+	mailFrom := getEnvOrDefault("MAIL_FROM", "sgs <no-reply@localhost>")
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := getEnvOrDefault("SMTP_PORT", "587")
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
+	if mailDriver == "smtp" && smtpHost == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail driver")
+	}
+
+	// registration configs
+	registrationMode := getEnvOrDefault("REGISTRATION_MODE", RegistrationOpen)
+	allowedEmailDomains := splitList(strings.ToLower(os.Getenv("ALLOWED_EMAIL_DOMAINS")))
+	switch registrationMode {
+	case RegistrationOpen, RegistrationDisabled, RegistrationInvite:
+	case RegistrationDomains:
+		if len(allowedEmailDomains) == 0 {
+			return nil, fmt.Errorf("ALLOWED_EMAIL_DOMAINS is required for the domains registration mode")
+		}
+	default:
+		return nil, fmt.Errorf("invalid registration mode %q", registrationMode)
+	}
+	emailVerification, err := strconv.ParseBool(getEnvOrDefault("EMAIL_VERIFICATION", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EMAIL_VERIFICATION: %w", err)
+	}
+
+	// two-factor authentication policy
+	mfaRequired, err := strconv.ParseBool(getEnvOrDefault("MFA_REQUIRED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MFA_REQUIRED: %w", err)
+	}
+
+	// openid connect configs
+	oidcIssuerURL := os.Getenv("OIDC_ISSUER_URL")
+	oidcClientID := os.Getenv("OIDC_CLIENT_ID")
+	if oidcIssuerURL != "" && oidcClientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is required for openid connect")
+	}
+	oidcRedirectURL := getEnvOrDefault("OIDC_REDIRECT_URL", baseURL.JoinPath("/api/auth/oidc/callback").String())
+	oidcScopes := strings.Fields(getEnvOrDefault("OIDC_SCOPES", "openid profile email"))
+	oidcRoleMapping, err := parseRoleMapping(os.Getenv("OIDC_ROLE_MAPPING"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_ROLE_MAPPING: %w", err)
+	}
+
+	// ldap configs
+	ldapURL := os.Getenv("LDAP_URL")
+	if ldapURL != "" && os.Getenv("LDAP_BASE_DN") == "" {
+		return nil, fmt.Errorf("LDAP_BASE_DN is required for ldap")
+	}
+	ldapStartTLS, err := strconv.ParseBool(getEnvOrDefault("LDAP_START_TLS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_START_TLS: %w", err)
+	}
+	ldapInsecureSkipVerify, err := strconv.ParseBool(getEnvOrDefault("LDAP_INSECURE_SKIP_VERIFY", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_INSECURE_SKIP_VERIFY: %w", err)
+	}
+	ldapRoleFilters, err := parseRoleFilters(os.Getenv("LDAP_ROLE_FILTERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_ROLE_FILTERS: %w", err)
+	}
+
+	// jwt signing configs
+	jwtAlgorithm := getEnvOrDefault("JWT_ALGORITHM", "EdDSA")
+	if jwtAlgorithm != "EdDSA" && jwtAlgorithm != "RS256" {
+		return nil, fmt.Errorf("invalid JWT_ALGORITHM %q", jwtAlgorithm)
+	}
+	jwtKeyRotation, err := time.ParseDuration(getEnvOrDefault("JWT_KEY_ROTATION", "720h"))
+	if err != nil || jwtKeyRotation <= 0 {
+		return nil, fmt.Errorf("invalid JWT_KEY_ROTATION: %q", os.Getenv("JWT_KEY_ROTATION"))
+	}
+	shareKeyRetention, err := time.ParseDuration(getEnvOrDefault("SHARE_KEY_RETENTION", "8760h"))
+	if err != nil || shareKeyRetention < 0 {
+		return nil, fmt.Errorf("invalid SHARE_KEY_RETENTION: %q", os.Getenv("SHARE_KEY_RETENTION"))
+	}
+
+	// login lockout configs
+	loginMaxFailures, err := strconv.Atoi(getEnvOrDefault("LOGIN_MAX_FAILURES", "5"))
+	if err != nil || loginMaxFailures < 1 {
+		return nil, fmt.Errorf("invalid LOGIN_MAX_FAILURES: %q", os.Getenv("LOGIN_MAX_FAILURES"))
+	}
+	loginMaxIPFailures, err := strconv.Atoi(getEnvOrDefault("LOGIN_MAX_IP_FAILURES", "20"))
+	if err != nil || loginMaxIPFailures < 1 {
+		return nil, fmt.Errorf("invalid LOGIN_MAX_IP_FAILURES: %q", os.Getenv("LOGIN_MAX_IP_FAILURES"))
+	}
+	loginLockout, err := time.ParseDuration(getEnvOrDefault("LOGIN_LOCKOUT", "15m"))
+	if err != nil || loginLockout <= 0 {
+		return nil, fmt.Errorf("invalid LOGIN_LOCKOUT: %q", os.Getenv("LOGIN_LOCKOUT"))
+	}
+
+	// account export configs
+	exportRetention, err := time.ParseDuration(getEnvOrDefault("EXPORT_RETENTION", "168h"))
+	if err != nil || exportRetention <= 0 {
+		return nil, fmt.Errorf("invalid EXPORT_RETENTION: %q", os.Getenv("EXPORT_RETENTION"))
+	}
+
+	// api key configs
+	apiKeyRotationGrace, err := time.ParseDuration(getEnvOrDefault("API_KEY_ROTATION_GRACE", "24h"))
+	if err != nil || apiKeyRotationGrace < 0 {
+		return nil, fmt.Errorf("invalid API_KEY_ROTATION_GRACE: %q", os.Getenv("API_KEY_ROTATION_GRACE"))
+	}
+	apiKeySignatureSkew, err := time.ParseDuration(getEnvOrDefault("API_KEY_SIGNATURE_SKEW", "5m"))
+	if err != nil || apiKeySignatureSkew <= 0 || apiKeySignatureSkew > time.Hour {
+		return nil, fmt.Errorf("invalid API_KEY_SIGNATURE_SKEW: %q", os.Getenv("API_KEY_SIGNATURE_SKEW"))
+	}
+
+	// proxy configs
+	trustedProxies := []netip.Prefix{}
+	for _, item := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		prefix, err := utils.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %q", item)
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+
 	return &Config{
 		Db:            db,
 		DbPassword:    dbPassword,
@@ -62,9 +267,121 @@ func New() (*Config, error) {
 		StoreAddr:     storeAddr,
 		StoreUser:     storeUser,
 		StorePassword: storePassword,
+		AppURL:        appURL,
+		MailDriver:    mailDriver,
+		MailFrom:      mailFrom,
+		SMTPHost:      smtpHost,
+		SMTPPort:      smtpPort,
+		SMTPUsername:  smtpUsername,
+		SMTPPassword:  smtpPassword,
+
+		RegistrationMode:    registrationMode,
+		AllowedEmailDomains: allowedEmailDomains,
+		EmailVerification:   emailVerification,
+		MFARequired:         mfaRequired,
+
+		OIDCIssuerURL:    oidcIssuerURL,
+		OIDCClientID:     oidcClientID,
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  oidcRedirectURL,
+		OIDCScopes:       oidcScopes,
+		OIDCRoleClaim:    getEnvOrDefault("OIDC_ROLE_CLAIM", "groups"),
+		OIDCRoleMapping:  oidcRoleMapping,
+
+		LDAPURL:                ldapURL,
+		LDAPStartTLS:           ldapStartTLS,
+		LDAPInsecureSkipVerify: ldapInsecureSkipVerify,
+		LDAPBindDN:             os.Getenv("LDAP_BIND_DN"),
+		LDAPBindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:             os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:         getEnvOrDefault("LDAP_USER_FILTER", "(uid={username})"),
+		LDAPIDAttribute:        getEnvOrDefault("LDAP_ID_ATTRIBUTE", "entryUUID"),
+		LDAPUsernameAttribute:  getEnvOrDefault("LDAP_USERNAME_ATTRIBUTE", "uid"),
+		LDAPEmailAttribute:     getEnvOrDefault("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPNameAttribute:      getEnvOrDefault("LDAP_NAME_ATTRIBUTE", "cn"),
+		LDAPRoleFilters:        ldapRoleFilters,
+
+		JWTAlgorithm:      jwtAlgorithm,
+		JWTKeyRotation:    jwtKeyRotation,
+		ShareKeyRetention: shareKeyRetention,
+
+		LoginMaxFailures:   loginMaxFailures,
+		LoginMaxIPFailures: loginMaxIPFailures,
+		LoginLockout:       loginLockout,
+
+		ExportBucket:    getEnvOrDefault("EXPORT_BUCKET", "sgs-exports"),
+		ExportRetention: exportRetention,
+
+		APIKeyRotationGrace: apiKeyRotationGrace,
+		APIKeySignatureSkew: apiKeySignatureSkew,
+		TrustedProxies:      trustedProxies,
 	}, nil
 }
 
+// ErrBucketReserved is returned when a bucket kept by the server for itself is used to back a project
+var ErrBucketReserved = errors.New("bucket is reserved by the server")
+
+// ReservedBuckets returns the buckets the server keeps for itself, which cannot back a project
+func (c *Config) ReservedBuckets() []string {
+	return []string{c.ExportBucket}
+}
+
+// splitList returns the non-empty trimmed items of a comma-separated list
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRoleMapping parses a comma-separated list of value=role pairs. Roles are either user or admin
+func parseRoleMapping(value string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, item := range splitList(value) {
+		claim, role, ok := strings.Cut(item, "=")
+		claim, role = strings.TrimSpace(claim), strings.TrimSpace(role)
+		if !ok || claim == "" {
+			return nil, fmt.Errorf("expected value=role, got %q", item)
+		}
+		if role != "user" && role != "admin" {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+		mapping[claim] = role
+	}
+	return mapping, nil
+}
+
+// parseRoleFilters parses a semicolon-separated list of role=filter pairs. Semicolons are used since filters contain commas
+func parseRoleFilters(value string) (map[string]string, error) {
+	filters := map[string]string{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		role, filter, ok := strings.Cut(item, "=")
+		role, filter = strings.TrimSpace(role), strings.TrimSpace(filter)
+		if !ok || filter == "" {
+			return nil, fmt.Errorf("expected role=filter, got %q", item)
+		}
+		if role != "user" && role != "admin" {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+		filters[role] = filter
+	}
+	return filters, nil
+}
+
+// getEnvOrDefault returns the value of an environment variable or the fallback when it is not set
+func getEnvOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func validateRequiredVars() error {
 	required := []string{"DB_DATABASE", "DB_PASSWORD", "DB_USERNAME", "DB_HOST", "PORT", "BASE_URL", "JWT_SECRET", "STORE_ADDR", "STORE_USER", "STORE_PASSWORD"}
 
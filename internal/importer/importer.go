@@ -0,0 +1,351 @@
+package importer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"sgs/internal/config"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/store"
+	"sgs/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrBucketNotFound = errors.New("bucket not found")
+	ErrBucketManaged  = errors.New("bucket is already managed as a project")
+	ErrSourceNotFound = errors.New("source bucket not found")
+	ErrChecksum       = errors.New("checksum mismatch")
+)
+
+const (
+	// number of objects imported per transaction
+	batchSize = 500
+	// number of objects copied from an external source per transaction
+	copyBatchSize = 50
+	// fallback content type when the store does not report one
+	defaultContentType = "application/octet-stream"
+
+	// JobType identifies import jobs in the job queue
+	JobType = "import"
+)
+
+// Job is the payload of an import job
+type Job struct {
+	ImportID uuid.UUID `json:"importId"`
+}
+
+// Importer adopts existing store buckets as projects by scanning their objects into files
+type Importer struct {
+	projectRepo *repository.ProjectRepository
+	fileRepo    *repository.FileRepository
+	importRepo  *repository.ImportRepository
+	store       *store.Store
+	// passphrase used to encrypt the credentials of external sources
+	secret string
+	// buckets the server keeps for itself, such as the export bucket, which are never adopted
+	reservedBuckets []string
+}
+
+// New creates a new bucket importer
+func New(projectRepo *repository.ProjectRepository, fileRepo *repository.FileRepository, importRepo *repository.ImportRepository, store *store.Store, secret string,
+	reservedBuckets []string) *Importer {
+	return &Importer{
+		projectRepo:     projectRepo,
+		fileRepo:        fileRepo,
+		importRepo:      importRepo,
+		store:           store,
+		secret:          secret,
+		reservedBuckets: reservedBuckets,
+	}
+}
+
+// UnmanagedBuckets lists the buckets in the store that are not managed as projects. Reserved buckets are left out
+func (i *Importer) UnmanagedBuckets(ctx context.Context) ([]models.Bucket, error) {
+	buckets, err := i.store.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed, err := i.projectRepo.GetBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unmanaged := []models.Bucket{}
+	for _, bucket := range buckets {
+		if !slices.Contains(managed, bucket.Name) && !slices.Contains(i.reservedBuckets, bucket.Name) {
+			unmanaged = append(unmanaged, bucket)
+		}
+	}
+	return unmanaged, nil
+}
+
+// AdoptBucket creates a project owned by the given user for an existing bucket along with a pending import of its objects.
+// [ErrBucketNotFound] is returned when the bucket does not exist, [ErrBucketManaged] when it already belongs to a project and
+// [config.ErrBucketReserved] when the server keeps it for itself
+func (i *Importer) AdoptBucket(ctx context.Context, bucket string, ownerID uuid.UUID) (*models.Import, error) {
+	if slices.Contains(i.reservedBuckets, bucket) {
+		return nil, config.ErrBucketReserved
+	}
+	exists, err := i.store.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrBucketNotFound
+	}
+
+	if _, err := i.projectRepo.GetProjectByBucket(ctx, bucket); err == nil {
+		return nil, ErrBucketManaged
+	} else if !errors.Is(err, repository.ErrProjectNotFound) {
+		return nil, err
+	}
+
+	// create the project and its import atomically
+	tx, err := i.importRepo.GetTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	project, err := i.projectRepo.CreateProject(ctx, tx, ownerID, bucket)
+	if err != nil {
+		return nil, err
+	}
+	imp, err := i.importRepo.CreateImport(ctx, tx, project.ID, ownerID, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// ImportExternal creates a pending import that copies the objects under a prefix of an external bucket into a project. The source
+// credentials are verified before the import is created and the secret key is stored encrypted. [ErrSourceNotFound] is returned when
+// the source bucket does not exist
+func (i *Importer) ImportExternal(ctx context.Context, projectID uuid.UUID, source models.ImportSource, secretKey string) (*models.Import, error) {
+	project, err := i.projectRepo.GetProjectByID(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// verify that the source is reachable with the given credentials
+	client, err := store.NewRemote(source.Endpoint, source.AccessKey, secretKey, source.Secure)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := client.BucketExists(ctx, source.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach source: %w", err)
+	}
+	if !exists {
+		return nil, ErrSourceNotFound
+	}
+
+	source.SecretKey, err = utils.EncryptSecret(i.secret, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return i.importRepo.CreateExternalImport(ctx, project.ID, project.OwnerID, project.Bucket, source)
+}
+
+// HandleJob processes an import job from the job queue. Failed attempts are retried by the queue and resume from the import's cursor
+func (i *Importer) HandleJob(ctx context.Context, job Job) error {
+	return i.Process(ctx, job.ImportID)
+}
+
+// Process imports the objects of a bucket in batches starting after the import's cursor. Every batch is saved together with the new
+// cursor so that an interrupted import resumes where it stopped. The import is marked as failed when an error occurs, except when the
+// context is cancelled, in which case it is returned to pending to be resumed later
+func (i *Importer) Process(ctx context.Context, id uuid.UUID) error {
+	imp, err := i.importRepo.GetImportByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if imp.Status == models.ImportCompleted {
+		return nil
+	}
+	if err := i.importRepo.UpdateImportStatus(ctx, imp.ID, models.ImportRunning, nil); err != nil {
+		return err
+	}
+
+	importObjects := i.importObjects
+	if imp.Source != nil {
+		importObjects = i.copyObjects
+	}
+	if err := importObjects(ctx, imp); err != nil {
+		if ctx.Err() != nil {
+			if err := i.importRepo.UpdateImportStatus(context.Background(), imp.ID, models.ImportPending, nil); err != nil {
+				log.Printf("failed to mark import %s as pending: %v\n", imp.ID, err)
+			}
+			return err
+		}
+		errMsg := err.Error()
+		if err := i.importRepo.UpdateImportStatus(context.Background(), imp.ID, models.ImportFailed, &errMsg); err != nil {
+			log.Printf("failed to mark import %s as failed: %v\n", imp.ID, err)
+		}
+		return err
+	}
+
+	log.Printf("import %s of bucket %s completed\n", imp.ID, imp.Bucket)
+	return i.importRepo.UpdateImportStatus(ctx, imp.ID, models.ImportCompleted, nil)
+}
+
+// importObjects scans the bucket into files until no objects remain after the cursor
+func (i *Importer) importObjects(ctx context.Context, imp *models.Import) error {
+	cursor := imp.Cursor
+	for {
+		objects, err := i.store.ListObjects(ctx, imp.Bucket, "", cursor, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		if len(objects) == 0 {
+			return nil
+		}
+
+		if err := i.importBatch(ctx, imp, objects); err != nil {
+			return err
+		}
+		cursor = objects[len(objects)-1].Name
+	}
+}
+
+// importBatch saves a batch of objects as files and advances the import cursor in a single transaction
+func (i *Importer) importBatch(ctx context.Context, imp *models.Import, objects []models.Object) error {
+	tx, err := i.importRepo.GetTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bytes int64
+	for _, object := range objects {
+		contentType := object.ContentType
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+		if err := i.fileRepo.CreateImportedFile(ctx, tx, path.Base(object.Name), object.Name, imp.ProjectID, object.Size, contentType, imp.OwnerID, object.LastModified); err != nil {
+			return fmt.Errorf("failed to save object %s: %w", object.Name, err)
+		}
+		bytes += object.Size
+	}
+
+	if err := i.importRepo.AdvanceImport(ctx, tx, imp.ID, objects[len(objects)-1].Name, int64(len(objects)), bytes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// copyObjects streams the objects of an external source into the project bucket until no objects remain after the cursor. The source
+// is counted first so that progress can be reported
+func (i *Importer) copyObjects(ctx context.Context, imp *models.Import) error {
+	secretKey, err := utils.DecryptSecret(i.secret, imp.Source.SecretKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt source credentials: %w", err)
+	}
+	source, err := store.NewRemote(imp.Source.Endpoint, imp.Source.AccessKey, secretKey, imp.Source.Secure)
+	if err != nil {
+		return err
+	}
+
+	if imp.SourceObjectCount == nil {
+		objects, bytes, err := countObjects(ctx, source, imp.Source.Bucket, imp.Source.Prefix)
+		if err != nil {
+			return fmt.Errorf("failed to count source objects: %w", err)
+		}
+		if err := i.importRepo.SetImportSourceTotals(ctx, imp.ID, objects, bytes); err != nil {
+			return err
+		}
+		log.Printf("import %s: copying %d objects (%s) from %s/%s\n", imp.ID, objects, utils.FormatStorageSize(bytes), imp.Source.Endpoint, imp.Source.Bucket)
+	}
+
+	cursor := imp.Cursor
+	for {
+		objects, err := source.ListObjects(ctx, imp.Source.Bucket, imp.Source.Prefix, cursor, copyBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list source objects: %w", err)
+		}
+		if len(objects) == 0 {
+			return nil
+		}
+
+		copied := make([]models.Object, 0, len(objects))
+		for _, object := range objects {
+			info, err := i.copyObject(ctx, source, imp, object.Name)
+			if err != nil {
+				return fmt.Errorf("failed to copy object %s: %w", object.Name, err)
+			}
+			copied = append(copied, info)
+		}
+
+		if err := i.importBatch(ctx, imp, copied); err != nil {
+			return err
+		}
+		cursor = objects[len(objects)-1].Name
+	}
+}
+
+// copyObject streams a single object from the source into the project bucket, preserving its content type and user metadata. The
+// copy is verified against the size and, for objects uploaded in a single part, the MD5 ETag reported by the source. Objects that fail
+// verification are removed from the project bucket
+func (i *Importer) copyObject(ctx context.Context, source *store.Store, imp *models.Import, objectName string) (models.Object, error) {
+	reader, info, err := source.OpenObject(ctx, imp.Source.Bucket, objectName)
+	if err != nil {
+		return models.Object{}, err
+	}
+	defer reader.Close()
+
+	if info.ContentType == "" {
+		info.ContentType = defaultContentType
+	}
+
+	hash := md5.New()
+	uploaded, err := i.store.PutObject(ctx, imp.Bucket, info, io.TeeReader(reader, hash))
+	if err != nil {
+		return models.Object{}, err
+	}
+
+	// multipart etags are not a digest of the content and are only checked through the size
+	sourceETag := strings.Trim(info.ETag, `"`)
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if uploaded.Size != info.Size || (!strings.Contains(sourceETag, "-") && sourceETag != digest) {
+		if err := i.store.RemoveObject(ctx, imp.Bucket, info.Name); err != nil {
+			log.Printf("failed to remove corrupted copy of %s: %v\n", info.Name, err)
+		}
+		return models.Object{}, fmt.Errorf("%w: expected %s (%d bytes), got %s (%d bytes)", ErrChecksum, sourceETag, info.Size, digest, uploaded.Size)
+	}
+	return info, nil
+}
+
+// countObjects returns the number and total size of the objects under a prefix
+func countObjects(ctx context.Context, source *store.Store, bucket, prefix string) (int64, int64, error) {
+	var count, bytes int64
+	cursor := ""
+	for {
+		objects, err := source.ListObjects(ctx, bucket, prefix, cursor, 1000)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(objects) == 0 {
+			return count, bytes, nil
+		}
+		for _, object := range objects {
+			count++
+			bytes += object.Size
+		}
+		cursor = objects[len(objects)-1].Name
+	}
+}
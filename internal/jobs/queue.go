@@ -0,0 +1,301 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrUnknownJobType = errors.New("unknown job type")
+	ErrPermanent      = errors.New("permanent job failure")
+)
+
+const (
+	// delay between polls for due jobs when the queue is idle
+	pollInterval = time.Second
+	// interval at which running jobs refresh their lock
+	heartbeatInterval = 30 * time.Second
+	// running jobs whose lock was not refreshed for this long are considered abandoned
+	staleTimeout = 4 * heartbeatInterval
+	// retry backoff bounds
+	minBackoff = 10 * time.Second
+	maxBackoff = time.Hour
+
+	defaultMaxAttempts = 5
+)
+
+// Options configures how the jobs of a type are processed
+type Options struct {
+	// number of workers processing the jobs of the type in this server process. The limit is not shared between processes: a
+	// deployment of several replicas processes up to this many jobs of the type at once in each replica. Defaults to 1
+	WorkersPerProcess int
+	// number of attempts before the job is dead-lettered. Defaults to 5
+	MaxAttempts int
+	// maximum duration of a single attempt. Zero means no limit
+	Timeout time.Duration
+}
+
+// Handler processes the decoded payload of a job. Returning an error retries the job with a backoff, unless the error wraps
+// [ErrPermanent] in which case the job is dead-lettered immediately
+type Handler[T any] func(ctx context.Context, payload T) error
+
+type registration struct {
+	opts   Options
+	handle func(ctx context.Context, payload json.RawMessage) error
+	// signals idle workers that a job of this type was enqueued
+	wake chan struct{}
+}
+
+// Queue processes jobs stored in the database. Any number of servers can share the queue since jobs are claimed with row locks
+type Queue struct {
+	jobRepo  *repository.JobRepository
+	workerID string
+	handlers map[string]*registration
+
+	// stops claiming new jobs
+	stopClaiming context.CancelFunc
+	// aborts the jobs in progress
+	abortJobs context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New creates a new job queue
+func New(jobRepo *repository.JobRepository) *Queue {
+	hostname, _ := os.Hostname()
+	return &Queue{
+		jobRepo:  jobRepo,
+		workerID: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+		handlers: make(map[string]*registration),
+	}
+}
+
+// Register adds a typed handler for a job type. Handlers must be registered before the queue is started
+func Register[T any](q *Queue, jobType string, opts Options, handler Handler[T]) {
+	if opts.WorkersPerProcess <= 0 {
+		opts.WorkersPerProcess = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+
+	q.handlers[jobType] = &registration{
+		opts: opts,
+		handle: func(ctx context.Context, raw json.RawMessage) error {
+			var payload T
+			if err := json.Unmarshal(raw, &payload); err != nil {
+				return fmt.Errorf("%w: invalid payload: %v", ErrPermanent, err)
+			}
+			return handler(ctx, payload)
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds a job of a registered type to the queue. The user is optional and identifies who requested the job
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any, userID *uuid.UUID) (*models.Job, error) {
+	reg, ok := q.handlers[jobType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job, err := q.jobRepo.CreateJob(ctx, jobType, raw, reg.opts.MaxAttempts, time.Now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// wake an idle local worker instead of waiting for the next poll
+	select {
+	case reg.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start launches the workers of every registered job type along with the reaper of abandoned jobs
+func (q *Queue) Start() {
+	claimCtx, stopClaiming := context.WithCancel(context.Background())
+	jobsCtx, abortJobs := context.WithCancel(context.Background())
+	q.stopClaiming, q.abortJobs = stopClaiming, abortJobs
+
+	for jobType, reg := range q.handlers {
+		for range reg.opts.WorkersPerProcess {
+			q.wg.Add(1)
+			go func() {
+				defer q.wg.Done()
+				q.work(claimCtx, jobsCtx, jobType, reg)
+			}()
+		}
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.reap(claimCtx)
+	}()
+	log.Printf("job queue started with worker id %s\n", q.workerID)
+}
+
+// Stop stops claiming jobs and waits for the jobs in progress to finish. Jobs still running when the context is done are aborted and
+// returned to the queue
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.stopClaiming == nil {
+		return nil
+	}
+	q.stopClaiming()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.abortJobs()
+		log.Println("job queue stopped")
+		return nil
+	case <-ctx.Done():
+		q.abortJobs()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// work claims and processes jobs of a single type until claiming is stopped
+func (q *Queue) work(claimCtx, jobsCtx context.Context, jobType string, reg *registration) {
+	for {
+		job, err := q.jobRepo.ClaimJob(claimCtx, jobType, q.workerID)
+		if err == nil {
+			q.process(jobsCtx, job, reg)
+			continue
+		}
+		if !errors.Is(err, repository.ErrNoJobAvailable) && claimCtx.Err() == nil {
+			log.Printf("failed to claim %s job: %v\n", jobType, err)
+		}
+
+		select {
+		case <-claimCtx.Done():
+			return
+		case <-reg.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// process runs a claimed job and records its outcome
+func (q *Queue) process(ctx context.Context, job *models.Job, reg *registration) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	if reg.opts.Timeout > 0 {
+		jobCtx, cancel = context.WithTimeout(ctx, reg.opts.Timeout)
+	}
+	defer cancel()
+
+	// keep the lock fresh while the job runs
+	stopHeartbeat := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopHeartbeat:
+				return
+			case <-ticker.C:
+				if err := q.jobRepo.HeartbeatJob(context.Background(), job.ID, q.workerID); err != nil {
+					log.Printf("failed to refresh lock of job %s: %v\n", job.ID, err)
+				}
+			}
+		}
+	}()
+
+	err := safeHandle(jobCtx, reg, job.Payload)
+	close(stopHeartbeat)
+
+	// the outcome is saved even when the queue is shutting down
+	saveCtx := context.Background()
+	switch {
+	case err == nil:
+		err = q.jobRepo.CompleteJob(saveCtx, job.ID, q.workerID)
+	case ctx.Err() != nil:
+		log.Printf("job %s (%s) interrupted by shutdown. Returning it to the queue\n", job.ID, job.Type)
+		err = q.jobRepo.ReleaseJob(saveCtx, job.ID, q.workerID)
+	case errors.Is(err, ErrPermanent) || job.Attempts >= job.MaxAttempts:
+		log.Printf("job %s (%s) dead-lettered after %d attempts: %v\n", job.ID, job.Type, job.Attempts, err)
+		err = q.jobRepo.DeadLetterJob(saveCtx, job.ID, q.workerID, err.Error())
+	default:
+		delay := Backoff(job.Attempts)
+		log.Printf("job %s (%s) failed on attempt %d. Retrying in %s: %v\n", job.ID, job.Type, job.Attempts, delay.Round(time.Second), err)
+		err = q.jobRepo.RetryJob(saveCtx, job.ID, q.workerID, time.Now().Add(delay), err.Error())
+	}
+	if errors.Is(err, repository.ErrJobLockLost) {
+		// the job was requeued as stale and possibly claimed by another worker, whose outcome wins
+		log.Printf("job %s (%s) is no longer held by this worker. Discarding its outcome\n", job.ID, job.Type)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to save outcome of job %s: %v\n", job.ID, err)
+	}
+}
+
+// reap periodically returns jobs abandoned by stopped workers to the queue, or dead-letters them when they used all their attempts
+func (q *Queue) reap(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			requeued, dead, err := q.jobRepo.RequeueStaleJobs(ctx, time.Now().Add(-staleTimeout))
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("failed to requeue stale jobs: %v\n", err)
+				}
+				continue
+			}
+			if requeued > 0 {
+				log.Printf("requeued %d abandoned jobs\n", requeued)
+			}
+			if dead > 0 {
+				log.Printf("dead-lettered %d abandoned jobs that used all their attempts\n", dead)
+			}
+		}
+	}
+}
+
+// safeHandle runs a handler and converts panics into permanent failures
+func safeHandle(ctx context.Context, reg *registration, payload json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: panic: %v", ErrPermanent, r)
+		}
+	}()
+	return reg.handle(ctx, payload)
+}
+
+// Backoff returns the delay before the next attempt of a job that failed on the given attempt. The delay doubles with every attempt up
+// to an hour and is jittered by up to 20% so that failing jobs do not retry in lockstep
+func Backoff(attempt int) time.Duration {
+	delay := maxBackoff
+	if attempt < 20 {
+		delay = min(minBackoff<<max(attempt-1, 0), maxBackoff)
+	}
+	jitter := time.Duration(rand.Int64N(int64(delay) / 5))
+	return delay - jitter
+}
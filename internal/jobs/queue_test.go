@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		got := Backoff(tt.attempt)
+		// jitter shortens the delay by up to 20%
+		if got > tt.want || got < tt.want*4/5 {
+			t.Errorf("Backoff(%d) = %s; want between %s and %s", tt.attempt, got, tt.want*4/5, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// errors
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// maximum duration of connecting to and of every request to the directory when the config has no timeout
+const defaultTimeout = 10 * time.Second
+
+// Config holds the settings of the directory
+type Config struct {
+	// ldap:// or ldaps:// url of the directory
+	URL string
+	// upgrade ldap:// connections with StartTLS
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// service account used to search for users. Searches are anonymous when empty
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// filter matching the entry of a user. {username} is replaced with the escaped username
+	UserFilter string
+	// attributes of user entries. The id attribute should be stable across renames, such as entryUUID
+	IDAttribute       string
+	UsernameAttribute string
+	EmailAttribute    string
+	NameAttribute     string
+	// filters evaluated against the entry of a user, keyed by the role granted when they match
+	RoleFilters map[string]string
+	Timeout     time.Duration
+}
+
+// User represents an authenticated directory user
+type User struct {
+	ID       string
+	DN       string
+	Username string
+	Email    string
+	Name     string
+	// roles whose filter matched the entry of the user
+	Roles []string
+}
+
+// Authenticator verifies credentials against an LDAP directory by searching for the entry of the user and binding as it
+type Authenticator struct {
+	cfg Config
+}
+
+// New creates a new authenticator
+func New(cfg Config) *Authenticator {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Authenticator{cfg: cfg}
+}
+
+// Authenticate checks the password of a user and returns their directory entry. [ErrInvalidCredentials] is returned when the user
+// does not exist, matches several entries or the password is wrong
+func (a *Authenticator) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	// an empty password would be an unauthenticated bind, which many directories accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	attributes := []string{a.cfg.IDAttribute, a.cfg.UsernameAttribute, a.cfg.EmailAttribute, a.cfg.NameAttribute}
+	result, err := conn.Search(ldap.NewSearchRequest(a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.cfg.Timeout.Seconds()), false,
+		filter, attributes, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("failed to search for user: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to bind as user: %w", err)
+	}
+
+	user := &User{
+		ID:       attributeString(entry, a.cfg.IDAttribute),
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(a.cfg.UsernameAttribute),
+		Email:    entry.GetAttributeValue(a.cfg.EmailAttribute),
+		Name:     entry.GetAttributeValue(a.cfg.NameAttribute),
+	}
+	if user.ID == "" {
+		return nil, fmt.Errorf("user entry %s has no %s attribute", entry.DN, a.cfg.IDAttribute)
+	}
+	if user.Username == "" {
+		user.Username = username
+	}
+
+	// evaluate the role filters with the service account, which can usually read group memberships
+	if len(a.cfg.RoleFilters) > 0 {
+		if err := a.bindService(conn); err != nil {
+			return nil, err
+		}
+		for role, roleFilter := range a.cfg.RoleFilters {
+			matched, err := a.matches(conn, entry.DN, roleFilter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate %s role filter: %w", role, err)
+			}
+			if matched {
+				user.Roles = append(user.Roles, role)
+			}
+		}
+	}
+	return user, nil
+}
+
+// dial connects to the directory and upgrades the connection when StartTLS is enabled
+func (a *Authenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	u, err := url.Parse(a.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory url: %w", err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: a.cfg.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to directory: %w", err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+
+	if a.cfg.StartTLS && u.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bindService binds as the service account when one is configured
+func (a *Authenticator) bindService(conn *ldap.Conn) error {
+	if a.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+		return fmt.Errorf("failed to bind service account: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether the entry with the given DN matches a filter
+func (a *Authenticator) matches(conn *ldap.Conn, dn, filter string) (bool, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(a.cfg.Timeout.Seconds()), false,
+		filter, []string{"1.1"}, nil))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(result.Entries) > 0, nil
+}
+
+// attributeString returns the value of an attribute. Binary values, such as the objectGUID of active directory, are hex encoded
+func attributeString(entry *ldap.Entry, attribute string) string {
+	raw := entry.GetRawAttributeValue(attribute)
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return hex.EncodeToString(raw)
+}
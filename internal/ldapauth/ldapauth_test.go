@@ -0,0 +1,289 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// entry is a directory entry of the test server
+type entry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testServer is a minimal in-process LDAP server supporting simple binds, searches with equality, presence and boolean filters, and
+// StartTLS
+type testServer struct {
+	entries   []entry
+	tlsConfig *tls.Config
+	// whether a connection upgraded with StartTLS
+	upgraded atomic.Bool
+}
+
+func startTestServer(t *testing.T, entries []entry) (*testServer, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testServer{entries: entries, tlsConfig: selfSignedTLSConfig(t)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, "ldap://" + listener.Addr().String()
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if e := s.find(dn); e != nil && e.password != "" && e.password == password {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(response(id, ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			base := op.Children[0].Value.(string)
+			scope := op.Children[1].Value.(int64)
+			filter := op.Children[6]
+			for _, e := range s.entries {
+				inScope := e.dn == base
+				if scope != ldap.ScopeBaseObject {
+					inScope = strings.HasSuffix(e.dn, base)
+				}
+				if inScope && e.matches(filter) {
+					conn.Write(searchEntry(id, e))
+				}
+			}
+			conn.Write(response(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			if op.Children[0].Data.String() != "1.3.6.1.4.1.1466.20037" {
+				conn.Write(response(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			conn.Write(response(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			s.upgraded.Store(true)
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *testServer) find(dn string) *entry {
+	for i := range s.entries {
+		if s.entries[i].dn == dn {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+// matches evaluates a search filter packet against the entry
+func (e entry) matches(filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !e.matches(child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if e.matches(child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		attr, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+		return slices.ContainsFunc(e.attrs[attr], func(v string) bool { return strings.EqualFold(v, value) })
+	case ldap.FilterPresent:
+		return filter.Data.String() == "objectClass" || len(e.attrs[filter.Data.String()]) > 0
+	default:
+		return false
+	}
+}
+
+func response(id int64, tag ber.Tag, code int) []byte {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	packet.AppendChild(result)
+	return packet.Bytes()
+}
+
+func searchEntry(id int64, e entry) []byte {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attrs {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	result.AppendChild(attributes)
+	packet.AppendChild(result)
+	return packet.Bytes()
+}
+
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+var testEntries = []entry{
+	{dn: "cn=sgs,ou=services,dc=example,dc=org", password: "service-secret"},
+	{dn: "uid=ama,ou=people,dc=example,dc=org", password: "ama-secret", attrs: map[string][]string{
+		"objectClass": {"inetOrgPerson"},
+		"entryUUID":   {"5f3c2b1e-0000-4000-8000-000000000001"},
+		"uid":         {"ama"},
+		"mail":        {"ama@example.org"},
+		"cn":          {"Ama Mensah"},
+		"memberOf":    {"cn=admins,ou=groups,dc=example,dc=org"},
+	}},
+	{dn: "uid=kofi,ou=people,dc=example,dc=org", password: "kofi-secret", attrs: map[string][]string{
+		"objectClass": {"inetOrgPerson"},
+		"entryUUID":   {"5f3c2b1e-0000-4000-8000-000000000002"},
+		"uid":         {"kofi"},
+		"cn":          {"Kofi Boateng"},
+	}},
+}
+
+func testConfig(url string) Config {
+	return Config{
+		URL:               url,
+		BindDN:            "cn=sgs,ou=services,dc=example,dc=org",
+		BindPassword:      "service-secret",
+		BaseDN:            "ou=people,dc=example,dc=org",
+		UserFilter:        "(&(objectClass=inetOrgPerson)(uid={username}))",
+		IDAttribute:       "entryUUID",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		NameAttribute:     "cn",
+		RoleFilters:       map[string]string{"admin": "(memberOf=cn=admins,ou=groups,dc=example,dc=org)"},
+		Timeout:           5 * time.Second,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	_, url := startTestServer(t, testEntries)
+	auth := New(testConfig(url))
+	ctx := context.Background()
+
+	user, err := auth.Authenticate(ctx, "ama", "ama-secret")
+	if err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+	if user.ID != "5f3c2b1e-0000-4000-8000-000000000001" || user.Email != "ama@example.org" || user.Name != "Ama Mensah" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if !slices.Equal(user.Roles, []string{"admin"}) {
+		t.Errorf("expected admin role from group membership, got %v", user.Roles)
+	}
+
+	user, err = auth.Authenticate(ctx, "kofi", "kofi-secret")
+	if err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+	if len(user.Roles) != 0 {
+		t.Errorf("expected no roles, got %v", user.Roles)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "ama", "wrong"},
+		{"unknown user", "efua", "secret"},
+		{"empty password", "ama", ""},
+		{"filter injection", "*", "ama-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.Authenticate(ctx, tt.username, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("expected invalid credentials, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateStartTLS(t *testing.T) {
+	server, url := startTestServer(t, testEntries)
+	cfg := testConfig(url)
+	cfg.StartTLS = true
+	cfg.InsecureSkipVerify = true
+
+	if _, err := New(cfg).Authenticate(context.Background(), "ama", "ama-secret"); err != nil {
+		t.Fatalf("failed to authenticate over StartTLS: %v", err)
+	}
+	if !server.upgraded.Load() {
+		t.Error("expected the connection to be upgraded with StartTLS")
+	}
+}
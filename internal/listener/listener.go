@@ -0,0 +1,175 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/store"
+
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+const (
+	// maximum number of recently processed events remembered for deduplication
+	dedupCapacity = 10000
+	// reconnect backoff bounds
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+	// fallback content type when the store does not report one
+	defaultContentType = "application/octet-stream"
+)
+
+// Listener watches project buckets for objects written or removed directly in the store and keeps the files table in sync
+type Listener struct {
+	fileRepo    *repository.FileRepository
+	projectRepo *repository.ProjectRepository
+	store       *store.Store
+
+	seen *eventSet
+}
+
+// New creates a new store notification listener
+func New(fileRepo *repository.FileRepository, projectRepo *repository.ProjectRepository, store *store.Store) *Listener {
+	return &Listener{
+		fileRepo:    fileRepo,
+		projectRepo: projectRepo,
+		store:       store,
+		seen:        newEventSet(dedupCapacity),
+	}
+}
+
+// Run listens for object notifications until the context is cancelled. The subscription is re-established with an exponential
+// backoff whenever the store closes the stream or reports an error
+func (l *Listener) Run(ctx context.Context) {
+	events := []models.StoreNotificationEvent{models.ObjectCreated, models.ObjectRemoved}
+	backoff := minBackoff
+
+	for {
+		log.Println("listening for store notifications")
+		for info := range l.store.GetObjectsNotifications(ctx, events) {
+			if info.Err != nil {
+				log.Printf("store notification error: %v\n", info.Err)
+				continue
+			}
+			// a healthy stream resets the backoff
+			backoff = minBackoff
+			for _, event := range info.Records {
+				l.handleEvent(ctx, event)
+			}
+		}
+
+		if ctx.Err() == nil {
+			log.Printf("store notification stream closed. Reconnecting after %s\n", backoff)
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("store notification listener stopped")
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// handleEvent applies a single object event to the files table
+func (l *Listener) handleEvent(ctx context.Context, event notification.Event) {
+	bucket := event.S3.Bucket.Name
+	// object keys are url encoded in notifications
+	objectName, err := url.QueryUnescape(event.S3.Object.Key)
+	if err != nil {
+		log.Printf("invalid object key in store notification %q: %v\n", event.S3.Object.Key, err)
+		return
+	}
+
+	// skip events that have already been processed, e.g. when replayed after a reconnect
+	key := bucket + "/" + objectName + "/" + event.EventName + "/" + event.S3.Object.Sequencer
+	if !l.seen.add(key) {
+		return
+	}
+
+	project, err := l.projectRepo.GetProjectByBucket(ctx, bucket)
+	if err != nil {
+		if !errors.Is(err, repository.ErrProjectNotFound) {
+			log.Printf("failed to retrieve project for bucket %s: %v\n", bucket, err)
+			l.seen.remove(key)
+		}
+		// ignore buckets not managed as projects
+		return
+	}
+
+	switch {
+	case matches(event.EventName, models.ObjectCreated):
+		contentType := event.S3.Object.ContentType
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+		// out-of-band objects are attributed to the project owner
+		if _, err := l.fileRepo.UpsertFileByObjectName(ctx, path.Base(objectName), objectName, project.ID, event.S3.Object.Size, contentType, project.OwnerID); err != nil {
+			log.Printf("failed to record object %s/%s: %v\n", bucket, objectName, err)
+			l.seen.remove(key)
+		}
+	case matches(event.EventName, models.ObjectRemoved):
+		if err := l.fileRepo.DeleteFileByObjectName(ctx, project.ID, objectName); err != nil && !errors.Is(err, repository.ErrFileNotFound) {
+			log.Printf("failed to remove object %s/%s: %v\n", bucket, objectName, err)
+			l.seen.remove(key)
+		}
+	}
+}
+
+// matches reports whether an event name such as s3:ObjectCreated:Put belongs to a wildcard event type
+func matches(eventName string, event models.StoreNotificationEvent) bool {
+	return strings.HasPrefix(eventName, strings.TrimSuffix(string(event), "*"))
+}
+
+// eventSet is a bounded set of event keys. The oldest keys are evicted first once the capacity is reached
+type eventSet struct {
+	mu       sync.Mutex
+	capacity int
+	keys     map[string]struct{}
+	order    []string
+}
+
+func newEventSet(capacity int) *eventSet {
+	return &eventSet{capacity: capacity, keys: make(map[string]struct{}, capacity)}
+}
+
+// add inserts a key and reports whether it was not already present
+func (s *eventSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	if len(s.order) >= s.capacity {
+		delete(s.keys, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.keys[key] = struct{}{}
+	s.order = append(s.order, key)
+	return true
+}
+
+// remove forgets a key so that a failed event can be processed again when redelivered
+func (s *eventSet) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; !ok {
+		return
+	}
+	delete(s.keys, key)
+	// the key must leave the eviction order too, or evicting a stale copy would drop the key once added again
+	if i := slices.Index(s.order, key); i >= 0 {
+		s.order = slices.Delete(s.order, i, i+1)
+	}
+}
@@ -0,0 +1,68 @@
+package listener
+
+import (
+	"testing"
+
+	"sgs/internal/models"
+)
+
+func TestEventSet(t *testing.T) {
+	set := newEventSet(2)
+
+	if !set.add("a") {
+		t.Fatal("expected first insert of a to succeed")
+	}
+	if set.add("a") {
+		t.Fatal("expected duplicate insert of a to be rejected")
+	}
+
+	// exceeding the capacity evicts the oldest key
+	set.add("b")
+	set.add("c")
+	if !set.add("a") {
+		t.Fatal("expected a to be evicted after reaching capacity")
+	}
+
+	// removed keys can be processed again
+	set.remove("c")
+	if !set.add("c") {
+		t.Fatal("expected removed key c to be accepted again")
+	}
+}
+
+func TestEventSetRemoveThenAdd(t *testing.T) {
+	set := newEventSet(3)
+
+	// a key removed and added again is tracked once, so filling the set does not evict it early
+	set.add("a")
+	set.remove("a")
+	set.add("a")
+	set.add("b")
+	set.add("c")
+	if set.add("a") {
+		t.Fatal("expected re-added key a to still be present")
+	}
+	if len(set.order) != len(set.keys) {
+		t.Fatalf("expected %d keys in the eviction order; got %d", len(set.keys), len(set.order))
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		event models.StoreNotificationEvent
+		want  bool
+	}{
+		{"s3:ObjectCreated:Put", models.ObjectCreated, true},
+		{"s3:ObjectCreated:CompleteMultipartUpload", models.ObjectCreated, true},
+		{"s3:ObjectRemoved:Delete", models.ObjectRemoved, true},
+		{"s3:ObjectRemoved:Delete", models.ObjectCreated, false},
+		{"s3:ObjectAccessed:Get", models.ObjectRemoved, false},
+	}
+
+	for _, tt := range tests {
+		if got := matches(tt.name, tt.event); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v; want %v", tt.name, tt.event, got, tt.want)
+		}
+	}
+}
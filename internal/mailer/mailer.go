@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"strings"
+	"text/template"
+
+	"sgs/internal/config"
+)
+
+// errors
+var (
+	ErrUnknownDriver   = errors.New("unknown mail driver")
+	ErrUnknownTemplate = errors.New("unknown mail template")
+)
+
+// names of the available templates
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailVerification = "email_verification"
+)
+
+// every template has a text body in templates/<name>.txt and an optional html body in templates/<name>.html
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Message represents an email ready to be delivered. The html body is optional
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New creates the mailer of the configured driver
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "log":
+		return NewLogMailer(), nil
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDriver, cfg.MailDriver)
+	}
+}
+
+// Render builds a message to a recipient from a named template. The subject is defined in the text template as a "subject" block
+func Render(name, to string, data any) (Message, error) {
+	textPath, htmlPath := "templates/"+name+".txt", "templates/"+name+".html"
+	if _, err := fs.Stat(templateFS, textPath); err != nil {
+		return Message{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	textTmpl, err := template.ParseFS(templateFS, textPath)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	msg := Message{To: to, Subject: strings.TrimSpace(subject.String()), Text: text.String()}
+
+	if _, err := fs.Stat(templateFS, htmlPath); err == nil {
+		htmlTmpl, err := htmltemplate.ParseFS(templateFS, htmlPath)
+		if err != nil {
+			return Message{}, err
+		}
+		var html bytes.Buffer
+		if err := htmlTmpl.Execute(&html, data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = html.String()
+	}
+	return msg, nil
+}
+
+// LogMailer writes emails to the server logs instead of delivering them. It is meant for development
+type LogMailer struct{}
+
+// NewLogMailer creates a new log mailer
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send logs the recipient, subject and text body of the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s\n", msg.To, msg.Subject, msg.Text)
+	return nil
+}
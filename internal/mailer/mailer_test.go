@@ -0,0 +1,130 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// received is an email accepted by the smtp stand-in
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+// startSMTPServer runs a minimal smtp server on a local port that accepts every message without authentication
+func startSMTPServer(t *testing.T) (string, <-chan received) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan received, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- received) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP stand-in")
+
+	var msg received
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(line[len("MAIL FROM:"):], " "), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(line[len("RCPT TO:"):], " "), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			tp.PrintfLine("250 OK")
+			messages <- msg
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, messages := startSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	msg, err := Render(TemplatePasswordReset, "Ama <ama@example.com>", map[string]string{
+		"Username":  "ama",
+		"ResetURL":  "http://localhost:5173/reset-password?token=abc",
+		"ExpiresIn": "1 hour",
+	})
+	if err != nil {
+		t.Fatalf("failed to render message: %v", err)
+	}
+
+	mailer := NewSMTPMailer(host, port, "", "", "sgs <no-reply@example.com>")
+	if err := mailer.Send(context.Background(), msg); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+
+	got := <-messages
+	if got.from != "no-reply@example.com" {
+		t.Fatalf("expected sender no-reply@example.com, got %s", got.from)
+	}
+	if len(got.to) != 1 || got.to[0] != "ama@example.com" {
+		t.Fatalf("expected recipient ama@example.com, got %v", got.to)
+	}
+
+	parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(got.data)))
+	if err != nil {
+		t.Fatalf("failed to parse delivered message: %v", err)
+	}
+	if subject := parsed.Header.Get("Subject"); subject != "Reset your sgs password" {
+		t.Fatalf("unexpected subject %q", subject)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "http://localhost:5173/reset-password?token=abc") {
+		t.Fatalf("expected reset link in body, got %s", body)
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("missing", "ama@example.com", nil); !errors.Is(err, ErrUnknownTemplate) {
+		t.Fatalf("expected unknown template error, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// maximum duration of a delivery when the context has no deadline
+const sendTimeout = 30 * time.Second
+
+// SMTPMailer delivers emails through an SMTP server. The connection is upgraded with STARTTLS when the server supports it
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer creates a new SMTP mailer. Authentication is skipped when no username is given
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send delivers a message to its recipient
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+	body, err := buildMessage(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sendTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, m.port))
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMessage encodes a message as a MIME email with a plain text body and an optional html alternative
+func buildMessage(from, to *mail.Address, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
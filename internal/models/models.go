@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,10 +16,15 @@ type Bucket struct {
 }
 
 type Object struct {
-	Bucket   string `json:"bucket"`
-	Name     string `json:"name"`
-	Size     int64  `json:"size"`
-	Location string `json:"location"`
+	Bucket       string    `json:"bucket"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	Location     string    `json:"location"`
+	ContentType  string    `json:"contentType,omitempty"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	// user-defined metadata without the x-amz-meta- prefix
+	Metadata map[string]string `json:"metadata,omitempty"`
 	// VersionID    string
 }
 
@@ -25,13 +32,149 @@ type Object struct {
 
 // User represents a user in our system
 type User struct {
-	ID       uuid.UUID `json:"id"`
-	Username string    `json:"username"`
+	ID            uuid.UUID `json:"id"`
+	Username      string    `json:"username"`
+	Email         *string   `json:"email"`
+	EmailVerified bool      `json:"emailVerified"`
+	Role          string    `json:"role"`
+	// whether logins require a totp code
+	TOTPEnabled bool `json:"totpEnabled"`
+	// whether the user must reset their password before they can login
+	PasswordResetRequired bool       `json:"passwordResetRequired"`
+	DisabledAt            *time.Time `json:"disabledAt"`
 	// hidden password field during marshaling
-	Password  string    `json:"-"`
-	FullName  *string   `json:"fullName"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	Password string `json:"-"`
+	// encrypted totp secret. set before totp is enabled while the user enrolls
+	TOTPSecret *string   `json:"-"`
+	FullName   *string   `json:"fullName"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// user roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// UserPreferences represents the settings a user chose for their account
+type UserPreferences struct {
+	// lifetime in seconds of share links created without an expiry. Share links require an expiry when unset
+	DefaultShareExpiry *int64 `json:"defaultShareExpiry"`
+	// IANA time zone dates are shown in
+	Timezone      string                  `json:"timezone"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+// NotificationPreferences represents the emails a user wants to receive
+type NotificationPreferences struct {
+	// sign-ins, password changes and other changes to the security of the account
+	SecurityAlerts bool `json:"securityAlerts"`
+	// account exports ready for download
+	ExportReady bool `json:"exportReady"`
+}
+
+// DefaultUserPreferences returns the preferences of users that did not change them
+func DefaultUserPreferences() UserPreferences {
+	return UserPreferences{
+		Timezone:      "UTC",
+		Notifications: NotificationPreferences{SecurityAlerts: true, ExportReady: true},
+	}
+}
+
+// user statuses used to filter users
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+)
+
+// UserFilter narrows down the users listed by admins. Empty fields match every user
+type UserFilter struct {
+	// matched against the username, email and full name
+	Query  string
+	Role   string
+	Status string
+}
+
+// SystemStats represents the totals of the whole server
+type SystemStats struct {
+	TotalUsers    int64 `json:"totalUsers"`
+	AdminUsers    int64 `json:"adminUsers"`
+	DisabledUsers int64 `json:"disabledUsers"`
+	TotalProjects int64 `json:"totalProjects"`
+	TotalFiles    int64 `json:"totalFiles"`
+	TotalSize     int64 `json:"totalSize"`
+	ActiveAPIKeys int64 `json:"activeAPIKeys"`
+}
+
+// Session represents a refresh token issued to a user. Refreshing replaces the session with a new one in the same family
+type Session struct {
+	ID       uuid.UUID `json:"id"`
+	UserID   uuid.UUID `json:"userId"`
+	FamilyID uuid.UUID `json:"familyId"`
+	// hidden token hash during marshaling
+	TokenHash       string     `json:"-"`
+	AuthenticatedAt time.Time  `json:"authenticatedAt"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	ReplacedBy      *uuid.UUID `json:"replacedBy"`
+	RevokedAt       *time.Time `json:"revokedAt"`
+	UserAgent       *string    `json:"userAgent"`
+	IPAddress       *string    `json:"ipAddress"`
+	LastSeenAt      time.Time  `json:"lastSeenAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+// login failure scopes
+const (
+	LoginScopeAccount = "account"
+	LoginScopeIP      = "ip"
+)
+
+// LoginFailure represents the recent failed logins of an account or a client ip
+type LoginFailure struct {
+	Scope string `json:"scope"`
+	// username of accounts or the client ip
+	Subject      string     `json:"subject"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"lastFailedAt"`
+	LockedUntil  *time.Time `json:"lockedUntil"`
+}
+
+// SigningKey represents an asymmetric key signing the JWTs of a purpose. Retired keys no longer sign but verify tokens until they expire
+type SigningKey struct {
+	ID        string `json:"id"`
+	Purpose   string `json:"purpose"`
+	Algorithm string `json:"algorithm"`
+	// hidden sealed private key during marshaling
+	PrivateKey string     `json:"-"`
+	PublicKey  string     `json:"publicKey"`
+	RetiredAt  *time.Time `json:"retiredAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// UserSession represents a login of a user on a device. Its ID is the session family shared by the rotated refresh tokens
+type UserSession struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  *string   `json:"userAgent"`
+	IPAddress  *string   `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// whether the session is the one making the request
+	Current bool `json:"current"`
+}
+
+// Invite represents a code minted by an admin that allows registering when registration is invite-only
+type Invite struct {
+	ID        uuid.UUID  `json:"id"`
+	Email     *string    `json:"email"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedBy *uuid.UUID `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
 }
 
 // project models
@@ -64,23 +207,282 @@ type File struct {
 
 	// denormalized bucket name
 	Bucket *string `json:"bucket,omitempty"`
+	// denormalized owner of the project, only set when retrieving a single file
+	ProjectOwnerID uuid.UUID `json:"-"`
 }
 
+// API key scopes, granting access to the routes of the project of a key
+const (
+	ScopeFilesRead   = "files:read"
+	ScopeFilesWrite  = "files:write"
+	ScopeFilesDelete = "files:delete"
+	ScopeShareCreate = "share:create"
+	ScopeKeysManage  = "keys:manage"
+)
+
+// APIKeyScopes lists the known API key scopes
+var APIKeyScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeFilesDelete, ScopeShareCreate, ScopeKeysManage}
+
+// DefaultAPIKeyScopes are granted to keys created without scopes. Managing other keys must be granted explicitly
+var DefaultAPIKeyScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeFilesDelete, ScopeShareCreate}
+
 // APIKey represents an API key for project access
 type APIKey struct {
-	ID        uuid.UUID  `json:"id"`
-	Token     string     `json:"token"`
-	Name      string     `json:"name"`
-	ProjectID uuid.UUID  `json:"projectId"`
-	UserID    uuid.UUID  `json:"userId"`
-	ExpiresAt time.Time  `json:"expiresAt"`
-	RevokedAt *time.Time `json:"revokedAt"`
-	CreatedAt time.Time  `json:"createdAt"`
+	ID uuid.UUID `json:"id"`
+	// only set in the response creating the key
+	Token string `json:"token,omitempty"`
+	// hidden token hash during marshaling
+	TokenHash   string     `json:"-"`
+	TokenPrefix string     `json:"tokenPrefix"`
+	Name        string     `json:"name"`
+	ProjectID   uuid.UUID  `json:"projectId"`
+	UserID      uuid.UUID  `json:"userId"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	RevokedAt   *time.Time `json:"revokedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Scopes      []string   `json:"scopes"`
+	// rotation chain. A rotated key keeps working alongside its successor until it is revoked at RevokesAt
+	RotatedFrom *uuid.UUID `json:"rotatedFrom"`
+	ReplacedBy  *uuid.UUID `json:"replacedBy"`
+	RevokesAt   *time.Time `json:"revokesAt"`
+	// last request authenticated with the key
+	LastUsedAt        *time.Time `json:"lastUsedAt"`
+	LastUsedIP        *string    `json:"lastUsedIp"`
+	LastUsedUserAgent *string    `json:"lastUsedUserAgent"`
+	// addresses and times the key can be used from
+	Restrictions APIKeyRestrictions `json:"restrictions"`
+	// keys in signing mode authenticate requests with signatures made with their signing secret instead of the token
+	Signed bool `json:"signed"`
+	// only set in the response creating or rotating a key in signing mode
+	SigningSecret string `json:"signingSecret,omitempty"`
+	// hidden sealed signing secret during marshaling
+	SealedSigningSecret string `json:"-"`
+	// daily usage of the key over the last days, most recent first. Only set when reporting a key
+	Usage []*APIKeyUsage `json:"usage,omitempty"`
 
 	// denormalized project bucket
 	ProjectBucket string `json:"projectBucket,omitempty"`
 }
 
+// PersonalAccessToken represents a token that acts as its user across all their projects
+type PersonalAccessToken struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"userId"`
+	Name   string    `json:"name"`
+	// only set in the response creating the token
+	Token string `json:"token,omitempty"`
+	// hidden token hash during marshaling
+	TokenHash   string `json:"-"`
+	TokenPrefix string `json:"tokenPrefix"`
+	// nil for tokens that never expire
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// APIKeyUsage represents the requests made with an API key on a day, in UTC
+type APIKeyUsage struct {
+	APIKeyID uuid.UUID `json:"-"`
+	Day      time.Time `json:"day"`
+	Requests int64     `json:"requests"`
+	// bytes of the request and response bodies
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+}
+
+// APIKeyRestrictions limits where and when an API key can be used. Empty restrictions allow any address at any time
+type APIKeyRestrictions struct {
+	// addresses and CIDRs the key can be used from
+	AllowedCIDRs []string `json:"allowedCidrs,omitempty"`
+	// days of the week the key can be used on, from 0 for Sunday to 6 for Saturday
+	AllowedDays []int `json:"allowedDays,omitempty"`
+	// hours of the day the key can be used in
+	AllowedHours *APIKeyHours `json:"allowedHours,omitempty"`
+	// IANA time zone of the allowed days and hours
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// APIKeyHours is a window of hours of the day, from Start included to End excluded. Windows ending before they start span midnight
+type APIKeyHours struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// reasons requests made with an API key are denied
+const (
+	DenialExpired = "expired"
+	DenialRevoked = "revoked"
+	// the route is not open to api keys
+	DenialRoute = "route"
+	DenialScope = "scope"
+	// the resource belongs to another project
+	DenialProject = "project"
+	DenialIP      = "ip"
+	DenialDay     = "day"
+	DenialHour    = "hour"
+	// the token of a key in signing mode was sent instead of a signature
+	DenialUnsigned = "unsigned"
+	// the body does not match the signed content hash
+	DenialSignature = "signature"
+	// the signature is outside the skew window or its nonce was already used
+	DenialReplay = "replay"
+)
+
+// APIKeyDenial represents a request made with an API key that was denied
+type APIKeyDenial struct {
+	ID        uuid.UUID `json:"id"`
+	APIKeyID  uuid.UUID `json:"apiKeyId"`
+	Reason    string    `json:"reason"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKeyUse represents the last request made with an API key
+type APIKeyUse struct {
+	APIKeyID  uuid.UUID
+	At        time.Time
+	IP        string
+	UserAgent string
+}
+
+// HasScope reports whether the key grants a scope
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// ImportStatus represents the state of a bucket import
+type ImportStatus string
+
+const (
+	ImportPending   ImportStatus = "pending"
+	ImportRunning   ImportStatus = "running"
+	ImportCompleted ImportStatus = "completed"
+	ImportFailed    ImportStatus = "failed"
+)
+
+// ImportSource describes an external S3-compatible bucket that objects are copied from
+type ImportSource struct {
+	Endpoint  string `json:"endpoint"`
+	Secure    bool   `json:"secure"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"accessKey"`
+	// hidden encrypted secret key
+	SecretKey string `json:"-"`
+}
+
+// Import represents the adoption of an existing bucket as a project, or the copy of an external bucket into a project when a source
+// is set. Objects are imported in batches and the cursor records the last imported object so that an interrupted import can be resumed
+type Import struct {
+	ID          uuid.UUID    `json:"id"`
+	ProjectID   uuid.UUID    `json:"projectId"`
+	OwnerID     uuid.UUID    `json:"ownerId"`
+	Bucket      string       `json:"bucket"`
+	Status      ImportStatus `json:"status"`
+	Cursor      string       `json:"cursor"`
+	ObjectCount int64        `json:"objectCount"`
+	TotalBytes  int64        `json:"totalBytes"`
+	Error       *string      `json:"error"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	CompletedAt *time.Time   `json:"completedAt"`
+
+	Source *ImportSource `json:"source,omitempty"`
+	// totals counted from the source before copying
+	SourceObjectCount *int64 `json:"sourceObjectCount,omitempty"`
+	SourceTotalBytes  *int64 `json:"sourceTotalBytes,omitempty"`
+	// percentage of source bytes copied, set when the source totals are known
+	Progress *float64 `json:"progress,omitempty"`
+}
+
+// ExportStatus represents the state of an account export
+type ExportStatus string
+
+const (
+	ExportPending   ExportStatus = "pending"
+	ExportRunning   ExportStatus = "running"
+	ExportCompleted ExportStatus = "completed"
+	ExportFailed    ExportStatus = "failed"
+)
+
+// AccountExport represents an archive of the projects, files and metadata of a user. The archive is built in the background and can be
+// downloaded until it expires
+type AccountExport struct {
+	ID     uuid.UUID    `json:"id"`
+	UserID uuid.UUID    `json:"userId"`
+	Status ExportStatus `json:"status"`
+	// hidden name of the archive in the export bucket
+	ObjectName  *string    `json:"-"`
+	Size        int64      `json:"size"`
+	Error       *string    `json:"error"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+}
+
+// JobStatus represents the state of a background job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	// jobs that exhausted their attempts are dead-lettered until retried manually
+	JobDead JobStatus = "dead"
+)
+
+// Job represents a unit of background work processed by the job queue
+type Job struct {
+	ID          uuid.UUID       `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      JobStatus       `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	LockedAt    *time.Time      `json:"lockedAt"`
+	LockedBy    *string         `json:"lockedBy"`
+	LastError   *string         `json:"lastError"`
+	UserID      *uuid.UUID      `json:"userId"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	CompletedAt *time.Time      `json:"completedAt"`
+}
+
+// JobFilter narrows down a job listing. Empty fields match all jobs
+type JobFilter struct {
+	Type   string
+	Status JobStatus
+	UserID *uuid.UUID
+}
+
+// TaskStatus represents the outcome of the last run of a scheduled task
+type TaskStatus string
+
+const (
+	TaskRunning   TaskStatus = "running"
+	TaskSucceeded TaskStatus = "succeeded"
+	TaskFailed    TaskStatus = "failed"
+)
+
+// ScheduledTask represents a periodic task along with the result of its last run
+type ScheduledTask struct {
+	Name           string      `json:"name"`
+	Schedule       string      `json:"schedule"`
+	LastRunAt      *time.Time  `json:"lastRunAt"`
+	LastStatus     *TaskStatus `json:"lastStatus"`
+	LastError      *string     `json:"lastError"`
+	LastDurationMs *int64      `json:"lastDurationMs"`
+	LastRunBy      *string     `json:"lastRunBy"`
+	NextRunAt      *time.Time  `json:"nextRunAt"`
+	UpdatedAt      time.Time   `json:"updatedAt"`
+}
+
 // DashboardStats represents a summary of the dashboard data
 type DashboardStats struct {
 	OwnerID       uuid.UUID `json:"ownerId"`
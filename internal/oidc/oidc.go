@@ -0,0 +1,379 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errors
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrNonceMismatch  = errors.New("id token nonce does not match the login")
+)
+
+const (
+	// maximum duration of a request to the provider
+	requestTimeout = 10 * time.Second
+	// signing keys are fetched again at most once in this interval when a token is signed with an unknown key
+	jwksRefreshInterval = time.Minute
+)
+
+// algorithms accepted for id token signatures
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Provider signs users in with an OpenID Connect provider using the authorization code flow with PKCE. The provider metadata is
+// discovered from the issuer on first use
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+
+	mu        sync.Mutex
+	metadata  *metadata
+	keys      map[string]crypto.PublicKey
+	keysFetch time.Time
+}
+
+// metadata holds the endpoints of a provider from its discovery document
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New creates a new provider. The client secret is optional for public clients
+func New(issuer, clientID, clientSecret, redirectURL string, scopes []string) *Provider {
+	return &Provider{
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// GenerateVerifier returns a random PKCE code verifier
+func GenerateVerifier() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// codeChallenge returns the S256 PKCE challenge of a verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the url of the provider login page. The state and nonce are checked when the user is redirected back, and the
+// verifier is sent along with the code when exchanging it
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", strings.Join(p.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// tokenResponse represents the response of the token endpoint
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades an authorization code for tokens and returns the verified claims of the id token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (jwt.MapClaims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.clientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange code: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id token", ErrInvalidIDToken)
+	}
+
+	return p.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an id token and returns its claims
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, md, kid)
+	}, jwt.WithValidMethods(signingMethods), jwt.WithIssuer(md.Issuer), jwt.WithAudience(p.clientID), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return claims, nil
+}
+
+// discover fetches and caches the metadata of the provider
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var md metadata
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &md); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+	if strings.TrimRight(md.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("provider issuer %q does not match the configured issuer %q", md.Issuer, p.issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("provider discovery document is missing endpoints")
+	}
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// key returns the verification key with the given ID. The key set is fetched again when the key is unknown so that rotated keys are
+// picked up
+func (p *Provider) key(ctx context.Context, md *metadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetch) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set jwks
+	p.keysFetch = time.Now()
+	if err := p.getJSON(ctx, md.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jwks represents a JSON web key set
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwk represents a public JSON web key. Only RSA and EC keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// Identity represents the user described by the claims of an id token
+type Identity struct {
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdentityFromClaims extracts the standard user claims of an id token. The username falls back to the local part of the email and
+// then to the subject when the provider sends no preferred username
+func IdentityFromClaims(claims jwt.MapClaims) Identity {
+	identity := Identity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Username, _ = claims["preferred_username"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		// some providers send the flag as a string
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Username == "" && identity.Email != "" {
+		identity.Username, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if identity.Username == "" {
+		identity.Username = identity.Subject
+	}
+	return identity
+}
+
+// ClaimStrings returns the string values of a claim. Nested claims are addressed with a dotted path such as realm_access.roles
+func ClaimStrings(claims jwt.MapClaims, path string) []string {
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
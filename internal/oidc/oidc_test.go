@@ -0,0 +1,168 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is an in-process OpenID Connect provider that issues a code for every authorization request
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// PKCE challenge and nonce of the last authorization request
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "test-code" || codeChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if id, secret, _ := r.BasicAuth(); id != "sgs" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": m.idToken(t, m.nonce)})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	m.claims = jwt.MapClaims{
+		"sub":                "user-1",
+		"preferred_username": "ama",
+		"email":              "ama@example.com",
+		"email_verified":     true,
+		"realm_access":       map[string]any{"roles": []any{"sgs-admins", "offline_access"}},
+	}
+	return m
+}
+
+// authorize records the parameters of an authorization request like the provider login page would
+func (m *mockProvider) authorize(t *testing.T, authURL string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 code challenge method in %s", authURL)
+	}
+	m.challenge = u.Query().Get("code_challenge")
+	m.nonce = u.Query().Get("nonce")
+}
+
+func (m *mockProvider) idToken(t *testing.T, nonce string) string {
+	claims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   "sgs",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	provider := New(m.server.URL, "sgs", "secret", "http://localhost/callback", []string{"openid", "email"})
+	ctx := context.Background()
+
+	verifier, err := GenerateVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatalf("failed to build authorization url: %v", err)
+	}
+	m.authorize(t, authURL)
+
+	// a different verifier fails the PKCE check
+	if _, err := provider.Exchange(ctx, "test-code", "other-verifier", "nonce"); err == nil {
+		t.Fatal("expected exchange with a wrong verifier to fail")
+	}
+
+	claims, err := provider.Exchange(ctx, "test-code", verifier, "nonce")
+	if err != nil {
+		t.Fatalf("failed to exchange code: %v", err)
+	}
+	identity := IdentityFromClaims(claims)
+	if identity.Subject != "user-1" || identity.Username != "ama" || identity.Email != "ama@example.com" || !identity.EmailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	roles := ClaimStrings(claims, "realm_access.roles")
+	if len(roles) != 2 || roles[0] != "sgs-admins" {
+		t.Errorf("expected realm roles, got %v", roles)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	m := newMockProvider(t)
+	provider := New(m.server.URL, "sgs", "secret", "http://localhost/callback", nil)
+	ctx := context.Background()
+
+	if _, err := provider.VerifyIDToken(ctx, m.idToken(t, "nonce"), "nonce"); err != nil {
+		t.Fatalf("expected valid id token, got %v", err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, m.idToken(t, "other"), "nonce"); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("expected nonce mismatch, got %v", err)
+	}
+
+	// tokens for other clients are rejected
+	m.claims["aud"] = "other-client"
+	if _, err := provider.VerifyIDToken(ctx, m.idToken(t, "nonce"), "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected invalid id token for another audience, got %v", err)
+	}
+}
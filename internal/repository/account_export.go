@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sgs/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrAccountExportNotFound = errors.New("export not found")
+)
+
+// AccountExportRepository handles database operations for account exports
+type AccountExportRepository struct {
+	db *sql.DB
+}
+
+// NewAccountExportRepository creates a new account export repository
+func NewAccountExportRepository(db *sql.DB) *AccountExportRepository {
+	return &AccountExportRepository{db: db}
+}
+
+const accountExportColumns = `id, user_id, status, object_name, size, error, expires_at, created_at, updated_at, completed_at`
+
+func scanAccountExport(row rowScanner) (*models.AccountExport, error) {
+	var export models.AccountExport
+	if err := row.Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.ObjectName,
+		&export.Size,
+		&export.Error,
+		&export.ExpiresAt,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+		&export.CompletedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// CreateAccountExport adds a new pending export of a user
+func (r *AccountExportRepository) CreateAccountExport(ctx context.Context, userID uuid.UUID) (*models.AccountExport, error) {
+	query := `
+        INSERT INTO account_exports (user_id)
+        VALUES ($1)
+		RETURNING ` + accountExportColumns
+
+	return scanAccountExport(r.db.QueryRowContext(ctx, query, userID))
+}
+
+// GetAccountExportByID retrieves an export by its ID. [ErrAccountExportNotFound] is returned when the export does not exist
+func (r *AccountExportRepository) GetAccountExportByID(ctx context.Context, id uuid.UUID) (*models.AccountExport, error) {
+	query := `SELECT ` + accountExportColumns + ` FROM account_exports WHERE id = $1`
+
+	export, err := scanAccountExport(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccountExportNotFound
+		}
+		return nil, err
+	}
+	return export, nil
+}
+
+// GetAccountExportsByUserID retrieves the exports of a user, most recent first
+func (r *AccountExportRepository) GetAccountExportsByUserID(ctx context.Context, userID uuid.UUID) ([]*models.AccountExport, error) {
+	query := `SELECT ` + accountExportColumns + ` FROM account_exports WHERE user_id = $1 ORDER BY created_at DESC`
+	return r.queryAccountExports(ctx, query, userID)
+}
+
+// GetExpiredAccountExports retrieves the exports that expired before the given time
+func (r *AccountExportRepository) GetExpiredAccountExports(ctx context.Context, before time.Time) ([]*models.AccountExport, error) {
+	query := `SELECT ` + accountExportColumns + ` FROM account_exports WHERE expires_at < $1`
+	return r.queryAccountExports(ctx, query, before)
+}
+
+func (r *AccountExportRepository) queryAccountExports(ctx context.Context, query string, args ...any) ([]*models.AccountExport, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []*models.AccountExport{}
+	for rows.Next() {
+		export, err := scanAccountExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// UpdateAccountExportStatus sets the status of an export along with an optional error message
+func (r *AccountExportRepository) UpdateAccountExportStatus(ctx context.Context, id uuid.UUID, status models.ExportStatus, errMsg *string) error {
+	query := `
+		UPDATE account_exports
+		SET status = $2, error = $3, updated_at = NOW()
+		WHERE id = $1
+		`
+	result, err := r.db.ExecContext(ctx, query, id, status, errMsg)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAccountExportNotFound
+	}
+	return nil
+}
+
+// CompleteAccountExport records the archive of an export and marks it as completed
+func (r *AccountExportRepository) CompleteAccountExport(ctx context.Context, id uuid.UUID, objectName string, size int64, expiresAt time.Time) error {
+	query := `
+		UPDATE account_exports
+		SET status = 'completed', object_name = $2, size = $3, error = NULL, expires_at = $4, updated_at = NOW(), completed_at = NOW()
+		WHERE id = $1
+		`
+	result, err := r.db.ExecContext(ctx, query, id, objectName, size, expiresAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAccountExportNotFound
+	}
+	return nil
+}
+
+// DeleteAccountExport removes an export. Its archive should be removed from the store beforehand
+func (r *AccountExportRepository) DeleteAccountExport(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM account_exports WHERE id = $1`, id)
+	return err
+}
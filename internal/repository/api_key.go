@@ -3,8 +3,10 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"sgs/internal/models"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +15,7 @@ import (
 // errors
 var (
 	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyRotated  = errors.New("api key has already been rotated or revoked")
 )
 
 // APIKeyRepository handles database operations for key
@@ -25,197 +28,279 @@ func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
 	return &APIKeyRepository{db: db}
 }
 
-// CreateAPIKey adds a new APIKey to the database
-func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, token, name string, projectID, UserID uuid.UUID, expiresAt time.Time) (*models.APIKey, error) {
+const apiKeyColumns = `ak.id, ak.token_hash, ak.token_prefix, ak.name, ak.project_id, ak.user_id, ak.expires_at, ak.revoked_at, ak.created_at,
+	ak.scopes, ak.rotated_from, ak.replaced_by, ak.revokes_at, ak.last_used_at, ak.last_used_ip, ak.last_used_user_agent, ak.restrictions,
+	ak.signing_secret, COALESCE(p.bucket, '')`
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
 	var key models.APIKey
-	query := `
-        INSERT INTO api_keys(token, name, project_id, user_id, expires_at)
-        VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, token, name, project_id, user_id, expires_at, revoked_at, created_at
-    `
-	var revokedAt sql.NullTime
-	if err := r.db.QueryRowContext(ctx, query, token, name, projectID, UserID, expiresAt).Scan(
+	var revokedAt, revokesAt, lastUsedAt sql.NullTime
+	var lastUsedIP, lastUsedUserAgent, signingSecret sql.NullString
+	var rotatedFrom, replacedBy uuid.NullUUID
+	var scopes string
+	var restrictions []byte
+	if err := row.Scan(
 		&key.ID,
-		&key.Token,
+		&key.TokenHash,
+		&key.TokenPrefix,
 		&key.Name,
 		&key.ProjectID,
 		&key.UserID,
 		&key.ExpiresAt,
 		&revokedAt,
-		&key.CreatedAt); err != nil {
+		&key.CreatedAt,
+		&scopes,
+		&rotatedFrom,
+		&replacedBy,
+		&revokesAt,
+		&lastUsedAt,
+		&lastUsedIP,
+		&lastUsedUserAgent,
+		&restrictions,
+		&signingSecret,
+		&key.ProjectBucket,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(restrictions, &key.Restrictions); err != nil {
 		return nil, err
 	}
+	if signingSecret.Valid {
+		key.SealedSigningSecret = signingSecret.String
+		key.Signed = true
+	}
+	key.Scopes = strings.Fields(scopes)
+	if rotatedFrom.Valid {
+		key.RotatedFrom = &rotatedFrom.UUID
+	}
+	if replacedBy.Valid {
+		key.ReplacedBy = &replacedBy.UUID
+	}
+	if revokesAt.Valid {
+		key.RevokesAt = &revokesAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if lastUsedIP.Valid {
+		key.LastUsedIP = &lastUsedIP.String
+	}
+	if lastUsedUserAgent.Valid {
+		key.LastUsedUserAgent = &lastUsedUserAgent.String
+	}
 	// check if revoked_at is present
 	if revokedAt.Valid {
 		key.RevokedAt = &revokedAt.Time
 	}
-
 	return &key, nil
 }
 
-// GetAPIKeyByToken retrieves an API key by its ID. [ErrAPIKeyNotFound] is returned when the api key is not found
-func (r *APIKeyRepository) GetAPIKeyByToken(ctx context.Context, token string) (*models.APIKey, error) {
+// CreateAPIKey adds a new APIKey to the database. Only the digest and the prefix of the token are stored. Keys in signing mode are given
+// their sealed signing secret, other keys an empty one
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, tokenHash, tokenPrefix, name string, projectID, UserID uuid.UUID, expiresAt time.Time,
+	scopes []string, restrictions models.APIKeyRestrictions, sealedSigningSecret string) (*models.APIKey, error) {
+	data, err := json.Marshal(restrictions)
+	if err != nil {
+		return nil, err
+	}
 	query := `
-        SELECT ak.id, ak.token, ak.name, ak.project_id, ak.user_id, ak.expires_at, ak.revoked_at, ak.created_at, p.bucket
-        FROM api_keys ak
+        WITH ak AS (
+            INSERT INTO api_keys(token_hash, token_prefix, name, project_id, user_id, expires_at, scopes, restrictions, signing_secret)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))
+            RETURNING *
+        )
+        SELECT ` + apiKeyColumns + `
+        FROM ak
 		LEFT JOIN projects p
 		ON ak.project_id = p.id
-		WHERE ak.token = $1
     `
-	var key models.APIKey
-	var revokedAt sql.NullTime
+	return scanAPIKey(r.db.QueryRowContext(ctx, query, tokenHash, tokenPrefix, name, projectID, UserID, expiresAt, strings.Join(scopes, " "), data,
+		sealedSigningSecret))
+}
 
-	err := r.db.QueryRowContext(ctx, query, token).Scan(
-		&key.ID,
-		&key.Token,
-		&key.Name,
-		&key.ProjectID,
-		&key.UserID,
-		&key.ExpiresAt,
-		&revokedAt,
-		&key.CreatedAt,
-		&key.ProjectBucket,
-	)
+// UpdateAPIKeyRestrictions replaces the restrictions of a key of a user. [ErrAPIKeyNotFound] is returned when the user has no such key
+func (r *APIKeyRepository) UpdateAPIKeyRestrictions(ctx context.Context, id, userID uuid.UUID, restrictions models.APIKeyRestrictions) (*models.APIKey, error) {
+	data, err := json.Marshal(restrictions)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        WITH ak AS (
+            UPDATE api_keys
+            SET restrictions = $3
+            WHERE id = $1 AND user_id = $2
+            RETURNING *
+        )
+        SELECT ` + apiKeyColumns + `
+        FROM ak
+		LEFT JOIN projects p
+		ON ak.project_id = p.id
+    `
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, id, userID, data))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrAPIKeyNotFound
 		}
 		return nil, err
 	}
+	return key, nil
+}
 
-	if revokedAt.Valid {
-		key.RevokedAt = &revokedAt.Time
+// RotateAPIKey replaces a key with a successor of the same name, project, scopes and restrictions. The key keeps working until revokesAt, when it is
+// revoked. The successor of a key in signing mode is given a new sealed signing secret. [ErrAPIKeyRotated] is returned when the key was
+// already rotated or revoked
+func (r *APIKeyRepository) RotateAPIKey(ctx context.Context, id uuid.UUID, tokenHash, tokenPrefix string, expiresAt, revokesAt time.Time,
+	sealedSigningSecret string) (*models.APIKey, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	return &key, nil
+	// the successor is inserted after its predecessor points to it, which frees the name of the key
+	successorID := uuid.New()
+	query := `
+        UPDATE api_keys
+        SET replaced_by = $2, revokes_at = $3
+        WHERE id = $1 AND replaced_by IS NULL AND revoked_at IS NULL
+    `
+	result, err := tx.ExecContext(ctx, query, id, successorID, revokesAt)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrAPIKeyRotated
+	}
+
+	query = `
+        WITH ak AS (
+            INSERT INTO api_keys(id, token_hash, token_prefix, name, project_id, user_id, expires_at, scopes, restrictions, signing_secret, rotated_from)
+            SELECT $2, $3, $4, name, project_id, user_id, $5, scopes, restrictions, NULLIF($6, ''), id
+            FROM api_keys
+            WHERE id = $1
+            RETURNING *
+        )
+        SELECT ` + apiKeyColumns + `
+        FROM ak
+		LEFT JOIN projects p
+		ON ak.project_id = p.id
+    `
+	successor, err := scanAPIKey(tx.QueryRowContext(ctx, query, id, successorID, tokenHash, tokenPrefix, expiresAt, sealedSigningSecret))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return successor, nil
+}
+
+// GetAPIKeyRotationChain retrieves the keys of the rotation chain of a key, from the first key to the latest successor
+func (r *APIKeyRepository) GetAPIKeyRotationChain(ctx context.Context, id uuid.UUID) ([]*models.APIKey, error) {
+	query := `
+        WITH RECURSIVE predecessors AS (
+            SELECT id, rotated_from FROM api_keys WHERE id = $1
+            UNION
+            SELECT k.id, k.rotated_from FROM api_keys k JOIN predecessors pr ON k.id = pr.rotated_from
+        ), chain AS (
+            SELECT id FROM predecessors WHERE rotated_from IS NULL
+            UNION
+            SELECT k.id FROM api_keys k JOIN chain c ON k.rotated_from = c.id
+        )
+        SELECT ` + apiKeyColumns + `
+        FROM api_keys ak
+		LEFT JOIN projects p
+		ON ak.project_id = p.id
+		WHERE ak.id IN (SELECT id FROM chain)
+		ORDER BY ak.created_at
+    `
+	return r.queryAPIKeys(ctx, query, id)
+}
+
+// GetAPIKeysByPrefix retrieves the API keys whose token starts with the given prefix, so that the token can be compared to their
+// digests. Keys of disabled users are not returned
+func (r *APIKeyRepository) GetAPIKeysByPrefix(ctx context.Context, tokenPrefix string) ([]*models.APIKey, error) {
+	query := `
+        SELECT ` + apiKeyColumns + `
+        FROM api_keys ak
+		LEFT JOIN projects p
+		ON ak.project_id = p.id
+		JOIN users u
+		ON ak.user_id = u.id
+		WHERE ak.token_prefix = $1 AND u.disabled_at IS NULL
+    `
+	return r.queryAPIKeys(ctx, query, tokenPrefix)
 }
 
 // GetAPIKeyByID retrieves an API key by its ID. [ErrAPIKeyNotFound] is returned when the api key is not found
 func (r *APIKeyRepository) GetAPIKeyByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
 	query := `
-        SELECT ak.id, ak.token, ak.name, ak.project_id, ak.user_id, ak.expires_at, ak.revoked_at, ak.created_at, p.bucket
+        SELECT ` + apiKeyColumns + `
         FROM api_keys ak
 		LEFT JOIN projects p
 		ON ak.project_id = p.id
 		WHERE ak.id = $1
     `
-	var key models.APIKey
-	var revokedAt sql.NullTime
-
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&key.ID,
-		&key.Token,
-		&key.Name,
-		&key.ProjectID,
-		&key.UserID,
-		&key.ExpiresAt,
-		&revokedAt,
-		&key.CreatedAt,
-		&key.ProjectBucket,
-	)
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, ErrFileNotFound
+			return nil, ErrAPIKeyNotFound
 		}
 		return nil, err
 	}
-
-	if revokedAt.Valid {
-		key.RevokedAt = &revokedAt.Time
-	}
-
-	return &key, nil
+	return key, nil
 }
 
 // GetAPIKeys retrieves all API keys for a project
 func (r *APIKeyRepository) GetAPIKeysByProjectID(ctx context.Context, projectID, userID uuid.UUID) ([]*models.APIKey, error) {
 	query := `
-        SELECT id, token, name, project_id, user_id, expires_at, revoked_at, created_at
-        FROM api_keys
-		WHERE project_id = $1 AND user_id = $2
-		ORDER BY created_at DESC
+        SELECT ` + apiKeyColumns + `
+        FROM api_keys ak
+		LEFT JOIN projects p
+		ON ak.project_id = p.id
+		WHERE ak.project_id = $1 AND ak.user_id = $2
+		ORDER BY ak.created_at DESC
     `
-
-	rows, err := r.db.QueryContext(ctx, query, projectID, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var keys []*models.APIKey
-	for rows.Next() {
-		var key models.APIKey
-		var revokedAt sql.NullTime
-
-		if err := rows.Scan(
-			&key.ID,
-			&key.Token,
-			&key.Name,
-			&key.ProjectID,
-			&key.UserID,
-			&key.ExpiresAt,
-			&revokedAt,
-			&key.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-
-		if revokedAt.Valid {
-			key.RevokedAt = &revokedAt.Time
-		}
-
-		keys = append(keys, &key)
-	}
-
-	return keys, nil
+	return r.queryAPIKeys(ctx, query, projectID, userID)
 }
 
 // GetAPIKeysByUserID retrieves all API keys for a user
 func (r *APIKeyRepository) GetAPIKeysByUserID(ctx context.Context, userID uuid.UUID) ([]*models.APIKey, error) {
 	query := `
-        SELECT ak.id, ak.token, ak.name, ak.project_id, ak.user_id, ak.expires_at, ak.revoked_at, ak.created_at, p.bucket
+        SELECT ` + apiKeyColumns + `
         FROM api_keys ak
 		LEFT JOIN projects p
 		ON ak.project_id = p.id
         WHERE ak.user_id = $1
 		ORDER BY ak.created_at DESC
     `
-	rows, err := r.db.QueryContext(ctx, query, userID)
+	return r.queryAPIKeys(ctx, query, userID)
+}
+
+func (r *APIKeyRepository) queryAPIKeys(ctx context.Context, query string, args ...any) ([]*models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var keys []*models.APIKey
+	keys := []*models.APIKey{}
 	for rows.Next() {
-		var key models.APIKey
-		var revokedAt sql.NullTime
-
-		if err := rows.Scan(
-			&key.ID,
-			&key.Token,
-			&key.Name,
-			&key.ProjectID,
-			&key.UserID,
-			&key.ExpiresAt,
-			&revokedAt,
-			&key.CreatedAt,
-			&key.ProjectBucket,
-		); err != nil {
+		key, err := scanAPIKey(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		if revokedAt.Valid {
-			key.RevokedAt = &revokedAt.Time
-		}
-
-		keys = append(keys, &key)
+		keys = append(keys, key)
 	}
-
-	return keys, nil
+	return keys, rows.Err()
 }
 
-// RevokeAPIKey revokes an API key by setting its revoked_at timestamp
+// RevokeAPIKey revokes an API key of a user by setting its revoked_at timestamp. [ErrAPIKeyNotFound] is returned when the user has no
+// such active key
 func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id, userID uuid.UUID) error {
 	query := `
         UPDATE api_keys
@@ -232,13 +317,13 @@ func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id, userID uuid.UUI
 		return err
 	}
 	if rows == 0 {
-		return sql.ErrNoRows
+		return ErrAPIKeyNotFound
 	}
 
 	return nil
 }
 
-// DeleteAPIKey permanently deletes an API key
+// DeleteAPIKey permanently deletes an API key of a user. [ErrAPIKeyNotFound] is returned when the user has no such key
 func (r *APIKeyRepository) DeleteAPIKey(ctx context.Context, id, userID uuid.UUID) error {
 	query := `
         DELETE FROM api_keys
@@ -254,8 +339,187 @@ func (r *APIKeyRepository) DeleteAPIKey(ctx context.Context, id, userID uuid.UUI
 		return err
 	}
 	if rows == 0 {
-		return sql.ErrNoRows
+		return ErrAPIKeyNotFound
 	}
 
 	return nil
 }
+
+// RecordAPIKeyUsage records the last uses of keys and adds to their daily counters in a single transaction. Last uses older than the
+// recorded one are ignored, so that batches can be written out of order
+func (r *APIKeyRepository) RecordAPIKeyUsage(ctx context.Context, uses []models.APIKeyUse, usage []models.APIKeyUsage) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, use := range uses {
+		query := `
+            UPDATE api_keys
+            SET last_used_at = $2, last_used_ip = NULLIF($3, ''), last_used_user_agent = NULLIF($4, '')
+            WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)
+        `
+		if _, err := tx.ExecContext(ctx, query, use.APIKeyID, use.At, use.IP, use.UserAgent); err != nil {
+			return err
+		}
+	}
+	for _, day := range usage {
+		// keys deleted since their use are skipped
+		query := `
+            INSERT INTO api_key_usage (api_key_id, day, requests, bytes_in, bytes_out)
+            SELECT id, $2, $3, $4, $5 FROM api_keys WHERE id = $1
+            ON CONFLICT (api_key_id, day) DO UPDATE
+            SET requests = api_key_usage.requests + EXCLUDED.requests,
+                bytes_in = api_key_usage.bytes_in + EXCLUDED.bytes_in,
+                bytes_out = api_key_usage.bytes_out + EXCLUDED.bytes_out
+        `
+		if _, err := tx.ExecContext(ctx, query, day.APIKeyID, day.Day, day.Requests, day.BytesIn, day.BytesOut); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetAPIKeyUsage retrieves the daily usage of a key since the given day, most recent first
+func (r *APIKeyRepository) GetAPIKeyUsage(ctx context.Context, id uuid.UUID, since time.Time) ([]*models.APIKeyUsage, error) {
+	query := `
+        SELECT api_key_id, day, requests, bytes_in, bytes_out
+        FROM api_key_usage
+        WHERE api_key_id = $1 AND day >= $2
+		ORDER BY day DESC
+    `
+	return r.queryAPIKeyUsage(ctx, query, id, since)
+}
+
+// GetAPIKeyUsageByUserID retrieves the daily usage of the keys of a user since the given day, most recent first
+func (r *APIKeyRepository) GetAPIKeyUsageByUserID(ctx context.Context, userID uuid.UUID, since time.Time) ([]*models.APIKeyUsage, error) {
+	query := `
+        SELECT u.api_key_id, u.day, u.requests, u.bytes_in, u.bytes_out
+        FROM api_key_usage u
+		JOIN api_keys ak
+		ON u.api_key_id = ak.id
+        WHERE ak.user_id = $1 AND u.day >= $2
+		ORDER BY u.day DESC
+    `
+	return r.queryAPIKeyUsage(ctx, query, userID, since)
+}
+
+func (r *APIKeyRepository) queryAPIKeyUsage(ctx context.Context, query string, args ...any) ([]*models.APIKeyUsage, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []*models.APIKeyUsage{}
+	for rows.Next() {
+		var day models.APIKeyUsage
+		if err := rows.Scan(&day.APIKeyID, &day.Day, &day.Requests, &day.BytesIn, &day.BytesOut); err != nil {
+			return nil, err
+		}
+		usage = append(usage, &day)
+	}
+	return usage, rows.Err()
+}
+
+// RecordAPIKeyDenial records a request made with a key that was denied
+func (r *APIKeyRepository) RecordAPIKeyDenial(ctx context.Context, denial *models.APIKeyDenial) error {
+	query := `
+        INSERT INTO api_key_denials (api_key_id, reason, ip, user_agent, method, path)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+	_, err := r.db.ExecContext(ctx, query, denial.APIKeyID, denial.Reason, denial.IP, denial.UserAgent, denial.Method, denial.Path)
+	return err
+}
+
+// GetAPIKeyDenials retrieves the latest denied requests of a key, most recent first
+func (r *APIKeyRepository) GetAPIKeyDenials(ctx context.Context, id uuid.UUID, limit int) ([]*models.APIKeyDenial, error) {
+	query := `
+        SELECT id, api_key_id, reason, ip, user_agent, method, path, created_at
+        FROM api_key_denials
+        WHERE api_key_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+    `
+	rows, err := r.db.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	denials := []*models.APIKeyDenial{}
+	for rows.Next() {
+		var denial models.APIKeyDenial
+		if err := rows.Scan(&denial.ID, &denial.APIKeyID, &denial.Reason, &denial.IP, &denial.UserAgent, &denial.Method, &denial.Path,
+			&denial.CreatedAt); err != nil {
+			return nil, err
+		}
+		denials = append(denials, &denial)
+	}
+	return denials, rows.Err()
+}
+
+// DeleteAPIKeyDenials permanently deletes the denials recorded before the given time. The number of deleted denials is returned
+func (r *APIKeyRepository) DeleteAPIKeyDenials(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM api_key_denials WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UseAPIKeyNonce records the nonce of a signed request until it expires. False is returned when the key already used the nonce
+func (r *APIKeyRepository) UseAPIKeyNonce(ctx context.Context, id uuid.UUID, nonce string, expiresAt time.Time) (bool, error) {
+	query := `
+        INSERT INTO api_key_nonces (api_key_id, nonce, expires_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (api_key_id, nonce) DO NOTHING
+    `
+	result, err := r.db.ExecContext(ctx, query, id, nonce, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// DeleteExpiredAPIKeyNonces deletes the nonces that expired before the given time. The number of deleted nonces is returned
+func (r *APIKeyRepository) DeleteExpiredAPIKeyNonces(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM api_key_nonces WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeRotatedAPIKeys revokes the rotated keys whose grace period ended before the given time. The number of revoked keys is returned
+func (r *APIKeyRepository) RevokeRotatedAPIKeys(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+        UPDATE api_keys
+        SET revoked_at = revokes_at
+        WHERE revokes_at <= $1 AND revoked_at IS NULL
+    `
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteInactiveAPIKeys permanently deletes API keys that expired or were revoked before the given time. The number of deleted keys is
+// returned
+func (r *APIKeyRepository) DeleteInactiveAPIKeys(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+        DELETE FROM api_keys
+        WHERE expires_at < $1 OR revoked_at < $1
+    `
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"sgs/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -56,4 +57,57 @@ func (r *DashboardRepository) GetDashboardStatsByOwnerID(ctx context.Context, ow
 	return &stats, nil
 }
 
+// GetSystemStats retrieves the totals of every user
+func (r *DashboardRepository) GetSystemStats(ctx context.Context) (*models.SystemStats, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users) AS total_users,
+
+			(SELECT COUNT(*) FROM users WHERE role = $1) AS admin_users,
+
+			(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL) AS disabled_users,
+
+			(SELECT COUNT(*) FROM projects) AS total_projects,
+
+			(SELECT COUNT(*) FROM files) AS total_files,
+
+			(SELECT COALESCE(SUM(size), 0) FROM files) AS total_size,
+
+			(SELECT COUNT(*) FROM api_keys WHERE revoked_at IS NULL AND expires_at > NOW()) AS active_api_keys;
+		`
+	var stats models.SystemStats
+	err := r.db.QueryRowContext(ctx, query, models.RoleAdmin).Scan(
+		&stats.TotalUsers,
+		&stats.AdminUsers,
+		&stats.DisabledUsers,
+		&stats.TotalProjects,
+		&stats.TotalFiles,
+		&stats.TotalSize,
+		&stats.ActiveAPIKeys,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// SnapshotStorageUsage records the files and bytes stored in every project on a day. Snapshots taken again on the same day replace
+// the previous ones. The number of projects snapshotted is returned
+func (r *DashboardRepository) SnapshotStorageUsage(ctx context.Context, day time.Time) (int64, error) {
+	query := `
+		INSERT INTO storage_usage (project_id, day, files, bytes)
+		SELECT p.id, $1, COUNT(f.id), COALESCE(SUM(f.size), 0)
+		FROM projects p
+		LEFT JOIN files f
+		ON f.project_id = p.id
+		GROUP BY p.id
+		ON CONFLICT (project_id, day) DO UPDATE SET files = EXCLUDED.files, bytes = EXCLUDED.bytes
+	`
+	results, err := r.db.ExecContext(ctx, query, day.UTC().Format(time.DateOnly))
+	if err != nil {
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
 // TODO: add montly stats
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrInvalidEmailVerification = errors.New("invalid or expired email verification token")
+)
+
+// EmailVerificationRepository handles database operations for email verification tokens
+type EmailVerificationRepository struct {
+	db *sql.DB
+}
+
+// NewEmailVerificationRepository creates a new email verification repository
+func NewEmailVerificationRepository(db *sql.DB) *EmailVerificationRepository {
+	return &EmailVerificationRepository{db: db}
+}
+
+// CreateEmailVerification stores the hash of a verification token of a user
+func (r *EmailVerificationRepository) CreateEmailVerification(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO email_verifications (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `
+	_, err := r.db.ExecContext(ctx, query, userID, tokenHash, expiresAt)
+	return err
+}
+
+// ConsumeEmailVerification marks an unused and unexpired verification token as used within the transaction and returns its user.
+// Tokens can only be consumed once. [ErrInvalidEmailVerification] is returned otherwise
+func (r *EmailVerificationRepository) ConsumeEmailVerification(ctx context.Context, tx *sql.Tx, tokenHash string) (uuid.UUID, error) {
+	query := `
+		UPDATE email_verifications
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+		`
+	var userID uuid.UUID
+	if err := tx.QueryRowContext(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, ErrInvalidEmailVerification
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// DeleteExpiredEmailVerifications removes verification tokens that expired before the given time. The number of deleted tokens is
+// returned
+func (r *EmailVerificationRepository) DeleteExpiredEmailVerifications(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM email_verifications WHERE expires_at < $1`
+	results, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *EmailVerificationRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"sgs/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -47,6 +48,21 @@ func (r *FileRepository) CreateFile(ctx context.Context, tx *sql.Tx, filename st
 	return &file, nil
 }
 
+// CreateImportedFile records an object that already exists in the store, keeping its original timestamp. Objects that are already tracked
+// only have their size and content type refreshed, so that overwritten objects stay accurate and an interrupted import can be replayed
+// safely. An external transaction should be passed as a reference and the
+// caller is responsible for committing or rolling back the transaction
+func (r *FileRepository) CreateImportedFile(ctx context.Context, tx *sql.Tx, filename string, objectName string, projectID uuid.UUID, size int64, contentType string, uploadedBy uuid.UUID, createdAt time.Time) error {
+	query := `
+        INSERT INTO files (filename, object_name, project_id, size, content_type, uploaded_by, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (project_id, object_name)
+		DO UPDATE SET size = EXCLUDED.size, content_type = EXCLUDED.content_type
+    `
+	_, err := tx.ExecContext(ctx, query, filename, objectName, projectID, size, contentType, uploadedBy, createdAt)
+	return err
+}
+
 // GetFileByID retrieves a file by their ID. [ErrFileNotFound] is returned when the file is not found
 func (r *FileRepository) GetFileByID(ctx context.Context, id uuid.UUID) (*models.File, error) {
 	query := `
@@ -59,7 +75,8 @@ func (r *FileRepository) GetFileByID(ctx context.Context, id uuid.UUID) (*models
 		files.content_type, 
 		files.uploaded_by, 
 		files.created_at, 
-		projects.bucket
+		projects.bucket,
+		projects.owner_id
 		FROM files
 		JOIN projects
 		ON files.project_id = projects.id
@@ -77,6 +94,7 @@ func (r *FileRepository) GetFileByID(ctx context.Context, id uuid.UUID) (*models
 		&file.UploadedBy,
 		&file.CreatedAt,
 		&file.Bucket,
+		&file.ProjectOwnerID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -99,7 +117,8 @@ func (r *FileRepository) GetFileByIDTx(ctx context.Context, tx *sql.Tx, id uuid.
 		files.content_type, 
 		files.uploaded_by, 
 		files.created_at, 
-		projects.bucket
+		projects.bucket,
+		projects.owner_id
 		FROM files
 		JOIN projects
 		ON files.project_id = projects.id
@@ -116,6 +135,7 @@ func (r *FileRepository) GetFileByIDTx(ctx context.Context, tx *sql.Tx, id uuid.
 		&file.UploadedBy,
 		&file.CreatedAt,
 		&file.Bucket,
+		&file.ProjectOwnerID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -216,6 +236,52 @@ func (r *FileRepository) DeleteFileByID(ctx context.Context, tx *sql.Tx, id uuid
 	return nil
 }
 
+// UpsertFileByObjectName records a file for an object that was written directly to the store. The row is matched on the project and
+// object name so that events for objects already tracked by the API only refresh the size and content type
+func (r *FileRepository) UpsertFileByObjectName(ctx context.Context, filename string, objectName string, projectID uuid.UUID, size int64, contentType string, uploadedBy uuid.UUID) (*models.File, error) {
+	var file models.File
+	query := `
+        INSERT INTO files (filename, object_name, project_id, size, content_type, uploaded_by)
+        VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (project_id, object_name)
+		DO UPDATE SET size = EXCLUDED.size, content_type = EXCLUDED.content_type
+		RETURNING id, filename, object_name, project_id, size, content_type, uploaded_by, created_at
+    `
+	if err := r.db.QueryRowContext(ctx, query, filename, objectName, projectID, size, contentType, uploadedBy).Scan(
+		&file.ID,
+		&file.Filename,
+		&file.ObjectName,
+		&file.ProjectID,
+		&file.Size,
+		&file.ContentType,
+		&file.UploadedBy,
+		&file.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DeleteFileByObjectName deletes the file tracking an object in a project. [ErrFileNotFound] is returned when no file matches the object
+func (r *FileRepository) DeleteFileByObjectName(ctx context.Context, projectID uuid.UUID, objectName string) error {
+	query := `
+		DELETE FROM files
+		WHERE project_id = $1 AND object_name = $2
+		`
+	results, err := r.db.ExecContext(ctx, query, projectID, objectName)
+	if err != nil {
+		return err
+	}
+
+	affected, err := results.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrFileNotFound
+	}
+	return nil
+}
+
 // GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
 func (r *FileRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
 	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
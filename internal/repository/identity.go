@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+)
+
+// IdentityRepository handles database operations for the links between users and external identity providers
+type IdentityRepository struct {
+	db *sql.DB
+}
+
+// NewIdentityRepository creates a new identity repository
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// GetIdentityUserID retrieves the user linked to the subject of a provider
+func (r *IdentityRepository) GetIdentityUserID(ctx context.Context, provider, subject string) (uuid.UUID, error) {
+	query := `SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`
+
+	var userID uuid.UUID
+	if err := r.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, ErrIdentityNotFound
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// CreateIdentity links a user to the subject of a provider. When a transaction is passed the link is created within it
+func (r *IdentityRepository) CreateIdentity(ctx context.Context, tx *sql.Tx, userID uuid.UUID, provider, subject string) error {
+	query := `
+        INSERT INTO user_identities (user_id, provider, subject)
+        VALUES ($1, $2, $3)
+    `
+	var err error
+	if tx != nil {
+		_, err = tx.ExecContext(ctx, query, userID, provider, subject)
+	} else {
+		_, err = r.db.ExecContext(ctx, query, userID, provider, subject)
+	}
+	return err
+}
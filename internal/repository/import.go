@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sgs/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrImportNotFound = errors.New("import not found")
+)
+
+// ImportRepository handles database operations for bucket imports
+type ImportRepository struct {
+	db *sql.DB
+}
+
+// NewImportRepository creates a new import repository
+func NewImportRepository(db *sql.DB) *ImportRepository {
+	return &ImportRepository{db: db}
+}
+
+const importColumns = `id, project_id, owner_id, bucket, status, cursor, object_count, total_bytes, error, created_at, updated_at, completed_at,
+	source_endpoint, source_secure, source_bucket, source_prefix, source_access_key, source_secret_key, source_object_count, source_total_bytes`
+
+// rowScanner is implemented by both sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanImport(row rowScanner) (*models.Import, error) {
+	var imp models.Import
+	var importErr sql.NullString
+	var completedAt sql.NullTime
+	var endpoint, sourceBucket, accessKey, secretKey sql.NullString
+	var secure bool
+	var prefix string
+	var sourceObjects, sourceBytes sql.NullInt64
+	if err := row.Scan(
+		&imp.ID,
+		&imp.ProjectID,
+		&imp.OwnerID,
+		&imp.Bucket,
+		&imp.Status,
+		&imp.Cursor,
+		&imp.ObjectCount,
+		&imp.TotalBytes,
+		&importErr,
+		&imp.CreatedAt,
+		&imp.UpdatedAt,
+		&completedAt,
+		&endpoint,
+		&secure,
+		&sourceBucket,
+		&prefix,
+		&accessKey,
+		&secretKey,
+		&sourceObjects,
+		&sourceBytes,
+	); err != nil {
+		return nil, err
+	}
+	if importErr.Valid {
+		imp.Error = &importErr.String
+	}
+	if completedAt.Valid {
+		imp.CompletedAt = &completedAt.Time
+	}
+	// only external imports have a source
+	if endpoint.Valid {
+		imp.Source = &models.ImportSource{
+			Endpoint:  endpoint.String,
+			Secure:    secure,
+			Bucket:    sourceBucket.String,
+			Prefix:    prefix,
+			AccessKey: accessKey.String,
+			SecretKey: secretKey.String,
+		}
+	}
+	if sourceObjects.Valid && sourceBytes.Valid {
+		imp.SourceObjectCount = &sourceObjects.Int64
+		imp.SourceTotalBytes = &sourceBytes.Int64
+
+		progress := 100.0
+		if sourceBytes.Int64 > 0 {
+			progress = min(100, float64(imp.TotalBytes)*100/float64(sourceBytes.Int64))
+		}
+		imp.Progress = &progress
+	}
+	return &imp, nil
+}
+
+// CreateImport adds a new pending import for a bucket. An external transaction should be acquired and passed as a reference so that
+// the import is created atomically with its project. The caller is responsible for committing or rolling back the transaction
+func (r *ImportRepository) CreateImport(ctx context.Context, tx *sql.Tx, projectID, ownerID uuid.UUID, bucket string) (*models.Import, error) {
+	query := `
+        INSERT INTO imports (project_id, owner_id, bucket)
+        VALUES ($1, $2, $3)
+		RETURNING ` + importColumns
+
+	return scanImport(tx.QueryRowContext(ctx, query, projectID, ownerID, bucket))
+}
+
+// CreateExternalImport adds a new pending import that copies objects from an external bucket into a project. The secret key should
+// already be encrypted
+func (r *ImportRepository) CreateExternalImport(ctx context.Context, projectID, ownerID uuid.UUID, bucket string, source models.ImportSource) (*models.Import, error) {
+	query := `
+        INSERT INTO imports (project_id, owner_id, bucket, source_endpoint, source_secure, source_bucket, source_prefix, source_access_key, source_secret_key)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING ` + importColumns
+
+	return scanImport(r.db.QueryRowContext(ctx, query, projectID, ownerID, bucket, source.Endpoint, source.Secure, source.Bucket, source.Prefix, source.AccessKey, source.SecretKey))
+}
+
+// SetImportSourceTotals records the number of objects and bytes found in the source of an external import
+func (r *ImportRepository) SetImportSourceTotals(ctx context.Context, id uuid.UUID, objects, bytes int64) error {
+	query := `
+		UPDATE imports
+		SET source_object_count = $2,
+			source_total_bytes = $3,
+			updated_at = NOW()
+		WHERE id = $1
+		`
+	_, err := r.db.ExecContext(ctx, query, id, objects, bytes)
+	return err
+}
+
+// GetImportByID retrieves an import by its ID. [ErrImportNotFound] is returned when the import does not exist
+func (r *ImportRepository) GetImportByID(ctx context.Context, id uuid.UUID) (*models.Import, error) {
+	query := `SELECT ` + importColumns + ` FROM imports WHERE id = $1`
+
+	imp, err := scanImport(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+	return imp, nil
+}
+
+// GetUnfinishedImportByBucket retrieves the pending, running or failed import of a bucket. [ErrImportNotFound] is returned when the
+// bucket has no unfinished import
+func (r *ImportRepository) GetUnfinishedImportByBucket(ctx context.Context, bucket string) (*models.Import, error) {
+	query := `
+		SELECT ` + importColumns + `
+		FROM imports
+		WHERE bucket = $1 AND status <> 'completed'
+		ORDER BY created_at DESC
+		LIMIT 1
+		`
+
+	imp, err := scanImport(r.db.QueryRowContext(ctx, query, bucket))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+	return imp, nil
+}
+
+// GetImports retrieves all imports, most recent first
+func (r *ImportRepository) GetImports(ctx context.Context) ([]*models.Import, error) {
+	query := `SELECT ` + importColumns + ` FROM imports ORDER BY created_at DESC`
+	return r.queryImports(ctx, query)
+}
+
+func (r *ImportRepository) queryImports(ctx context.Context, query string, args ...any) ([]*models.Import, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []*models.Import{}
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+	return imports, rows.Err()
+}
+
+// UpdateImportStatus sets the status of an import along with an optional error message. The completion time is recorded and the source
+// credentials are discarded when the import completes
+func (r *ImportRepository) UpdateImportStatus(ctx context.Context, id uuid.UUID, status models.ImportStatus, errMsg *string) error {
+	query := `
+		UPDATE imports
+		SET status = $2,
+			error = $3,
+			updated_at = NOW(),
+			completed_at = CASE WHEN $2 = 'completed' THEN NOW() ELSE completed_at END,
+			source_secret_key = CASE WHEN $2 = 'completed' THEN NULL ELSE source_secret_key END
+		WHERE id = $1
+		`
+	results, err := r.db.ExecContext(ctx, query, id, status, errMsg)
+	if err != nil {
+		return err
+	}
+	affected, err := results.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrImportNotFound
+	}
+	return nil
+}
+
+// AdvanceImport moves the cursor of an import forward and adds the objects and bytes of the imported batch to its totals. An external
+// transaction should be passed so that the batch's files and the new cursor are saved together
+func (r *ImportRepository) AdvanceImport(ctx context.Context, tx *sql.Tx, id uuid.UUID, cursor string, objects, bytes int64) error {
+	query := `
+		UPDATE imports
+		SET cursor = $2,
+			object_count = object_count + $3,
+			total_bytes = total_bytes + $4,
+			updated_at = NOW()
+		WHERE id = $1
+		`
+	_, err := tx.ExecContext(ctx, query, id, cursor, objects, bytes)
+	return err
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *ImportRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
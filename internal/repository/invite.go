@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sgs/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInvalidInvite  = errors.New("invalid, expired or exhausted invite code")
+)
+
+// InviteRepository handles database operations for registration invites
+type InviteRepository struct {
+	db *sql.DB
+}
+
+// NewInviteRepository creates a new invite repository
+func NewInviteRepository(db *sql.DB) *InviteRepository {
+	return &InviteRepository{db: db}
+}
+
+const inviteColumns = `id, email, max_uses, uses, expires_at, revoked_at, created_by, created_at`
+
+func scanInvite(row rowScanner) (*models.Invite, error) {
+	var invite models.Invite
+	var email sql.NullString
+	var revokedAt sql.NullTime
+	var createdBy uuid.NullUUID
+	if err := row.Scan(
+		&invite.ID,
+		&email,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.ExpiresAt,
+		&revokedAt,
+		&createdBy,
+		&invite.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if email.Valid {
+		invite.Email = &email.String
+	}
+	if revokedAt.Valid {
+		invite.RevokedAt = &revokedAt.Time
+	}
+	if createdBy.Valid {
+		invite.CreatedBy = &createdBy.UUID
+	}
+	return &invite, nil
+}
+
+// CreateInvite stores the hash of a new invite code. The email and creator are optional
+func (r *InviteRepository) CreateInvite(ctx context.Context, codeHash string, email *string, maxUses int, expiresAt time.Time, createdBy *uuid.UUID) (*models.Invite, error) {
+	query := `
+        INSERT INTO invites (code_hash, email, max_uses, expires_at, created_by)
+        VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + inviteColumns
+
+	return scanInvite(r.db.QueryRowContext(ctx, query, codeHash, email, maxUses, expiresAt, createdBy))
+}
+
+// UseInvite counts a use of an invite code within the transaction. The invite must be unrevoked, unexpired, not exhausted and either
+// unrestricted or restricted to the given email. [ErrInvalidInvite] is returned otherwise
+func (r *InviteRepository) UseInvite(ctx context.Context, tx *sql.Tx, codeHash, email string) error {
+	query := `
+		UPDATE invites
+		SET uses = uses + 1
+		WHERE code_hash = $1
+			AND revoked_at IS NULL
+			AND expires_at > NOW()
+			AND uses < max_uses
+			AND (email IS NULL OR LOWER(email) = LOWER($2))
+		`
+	result, err := tx.ExecContext(ctx, query, codeHash, email)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+// GetInvites retrieves all invites, most recent first
+func (r *InviteRepository) GetInvites(ctx context.Context) ([]*models.Invite, error) {
+	query := `SELECT ` + inviteColumns + ` FROM invites ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*models.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// RevokeInvite prevents further uses of an invite. [ErrInviteNotFound] is returned when no unrevoked invite matches the id
+func (r *InviteRepository) RevokeInvite(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE invites
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
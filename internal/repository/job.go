@@ -0,0 +1,318 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sgs/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrNoJobAvailable = errors.New("no job available")
+	ErrJobLockLost    = errors.New("job is no longer held by this worker")
+)
+
+// JobRepository handles database operations for background jobs
+type JobRepository struct {
+	db *sql.DB
+}
+
+// NewJobRepository creates a new job repository
+func NewJobRepository(db *sql.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+const jobColumns = `id, type, payload, status, attempts, max_attempts, run_at, locked_at, locked_by, last_error, user_id, created_at, updated_at, completed_at`
+
+func scanJob(row rowScanner) (*models.Job, error) {
+	var job models.Job
+	var lockedAt, completedAt sql.NullTime
+	var lockedBy, lastError sql.NullString
+	var userID uuid.NullUUID
+	if err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&lockedAt,
+		&lockedBy,
+		&lastError,
+		&userID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&completedAt,
+	); err != nil {
+		return nil, err
+	}
+	if lockedAt.Valid {
+		job.LockedAt = &lockedAt.Time
+	}
+	if lockedBy.Valid {
+		job.LockedBy = &lockedBy.String
+	}
+	if lastError.Valid {
+		job.LastError = &lastError.String
+	}
+	if userID.Valid {
+		job.UserID = &userID.UUID
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return &job, nil
+}
+
+// CreateJob adds a new queued job that becomes available to workers at runAt
+func (r *JobRepository) CreateJob(ctx context.Context, jobType string, payload []byte, maxAttempts int, runAt time.Time, userID *uuid.UUID) (*models.Job, error) {
+	query := `
+        INSERT INTO jobs (type, payload, max_attempts, run_at, user_id)
+        VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + jobColumns
+
+	return scanJob(r.db.QueryRowContext(ctx, query, jobType, string(payload), maxAttempts, runAt, userID))
+}
+
+// ClaimJob locks the next due job of a type for a worker and counts the attempt. Rows locked by other workers are skipped so that
+// concurrent workers never claim the same job. [ErrNoJobAvailable] is returned when no job is due
+func (r *JobRepository) ClaimJob(ctx context.Context, jobType, workerID string) (*models.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running',
+			attempts = attempts + 1,
+			locked_at = NOW(),
+			locked_by = $2,
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE type = $1 AND status = 'queued' AND run_at <= NOW()
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRowContext(ctx, query, jobType, workerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoJobAvailable
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// HeartbeatJob refreshes the lock of a running job held by a worker
+func (r *JobRepository) HeartbeatJob(ctx context.Context, id uuid.UUID, workerID string) error {
+	query := `
+		UPDATE jobs
+		SET locked_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+		`
+	_, err := r.db.ExecContext(ctx, query, id, workerID)
+	return err
+}
+
+// CompleteJob marks a running job held by a worker as succeeded. [ErrJobLockLost] is returned when the worker no longer holds the job,
+// e.g. when it was requeued as stale and claimed by another worker
+func (r *JobRepository) CompleteJob(ctx context.Context, id uuid.UUID, workerID string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded',
+			locked_at = NULL,
+			last_error = NULL,
+			updated_at = NOW(),
+			completed_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+		`
+	return r.updateHeldJob(ctx, query, id, workerID)
+}
+
+// RetryJob puts a failed job held by a worker back in the queue to be retried at runAt. [ErrJobLockLost] is returned when the worker
+// no longer holds the job
+func (r *JobRepository) RetryJob(ctx context.Context, id uuid.UUID, workerID string, runAt time.Time, errMsg string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'queued',
+			run_at = $3,
+			last_error = $4,
+			locked_at = NULL,
+			locked_by = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+		`
+	return r.updateHeldJob(ctx, query, id, workerID, runAt, errMsg)
+}
+
+// DeadLetterJob marks a job held by a worker that exhausted its attempts as dead. [ErrJobLockLost] is returned when the worker no
+// longer holds the job
+func (r *JobRepository) DeadLetterJob(ctx context.Context, id uuid.UUID, workerID string, errMsg string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'dead',
+			last_error = $3,
+			locked_at = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+		`
+	return r.updateHeldJob(ctx, query, id, workerID, errMsg)
+}
+
+// ReleaseJob returns a running job held by a worker to the queue without counting the attempt, e.g. when its worker shuts down.
+// [ErrJobLockLost] is returned when the worker no longer holds the job
+func (r *JobRepository) ReleaseJob(ctx context.Context, id uuid.UUID, workerID string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'queued',
+			attempts = GREATEST(attempts - 1, 0),
+			locked_at = NULL,
+			locked_by = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'
+		`
+	return r.updateHeldJob(ctx, query, id, workerID)
+}
+
+// updateHeldJob runs an update of a job matched by its id and worker. [ErrJobLockLost] is returned when no row is updated
+func (r *JobRepository) updateHeldJob(ctx context.Context, query string, id uuid.UUID, workerID string, args ...any) error {
+	results, err := r.db.ExecContext(ctx, query, append([]any{id, workerID}, args...)...)
+	if err != nil {
+		return err
+	}
+	rows, err := results.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrJobLockLost
+	}
+	return nil
+}
+
+// RequeueStaleJobs returns running jobs whose lock was not refreshed since staleBefore to the queue. These jobs belonged to workers that
+// stopped without releasing them. Jobs that already used all their attempts are dead-lettered instead, so that a job crashing or hanging
+// its worker is not reclaimed forever. The numbers of requeued and dead-lettered jobs are returned
+func (r *JobRepository) RequeueStaleJobs(ctx context.Context, staleBefore time.Time) (int64, int64, error) {
+	query := `
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			last_error = CASE
+				WHEN attempts >= max_attempts THEN 'worker stopped responding on attempt ' || attempts
+				ELSE last_error
+			END,
+			locked_at = NULL,
+			locked_by = NULL,
+			updated_at = NOW()
+		WHERE status = 'running' AND locked_at < $1
+		RETURNING status
+		`
+	rows, err := r.db.QueryContext(ctx, query, staleBefore)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var requeued, dead int64
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return 0, 0, err
+		}
+		if status == "dead" {
+			dead++
+		} else {
+			requeued++
+		}
+	}
+	return requeued, dead, rows.Err()
+}
+
+// DeleteSucceededJobs removes jobs that succeeded before the given time. The number of deleted jobs is returned
+func (r *JobRepository) DeleteSucceededJobs(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM jobs WHERE status = 'succeeded' AND completed_at < $1`
+	results, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
+// RequeueDeadJob resets the attempts of a dead job and queues it to run immediately. [ErrJobNotFound] is returned when no dead job
+// matches the id
+func (r *JobRepository) RequeueDeadJob(ctx context.Context, id uuid.UUID) (*models.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'queued',
+			attempts = 0,
+			run_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJobByID retrieves a job by its ID. [ErrJobNotFound] is returned when the job does not exist
+func (r *JobRepository) GetJobByID(ctx context.Context, id uuid.UUID) (*models.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	job, err := scanJob(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJobs retrieves the most recent jobs matching the filter
+func (r *JobRepository) GetJobs(ctx context.Context, filter models.JobFilter, limit int) ([]*models.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE TRUE`
+	args := []any{}
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		query += fmt.Sprintf(" AND type = $%d", len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if filter.UserID != nil {
+		args = append(args, *filter.UserID)
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"sgs/internal/models"
+)
+
+// LoginFailureRepository handles database operations for failed logins
+type LoginFailureRepository struct {
+	db *sql.DB
+}
+
+// NewLoginFailureRepository creates a new login failure repository
+func NewLoginFailureRepository(db *sql.DB) *LoginFailureRepository {
+	return &LoginFailureRepository{db: db}
+}
+
+// GetLoginLock returns the time until which logins to an account or from a client ip are refused, or nil when neither is locked
+func (r *LoginFailureRepository) GetLoginLock(ctx context.Context, username, ip string) (*time.Time, error) {
+	query := `
+        SELECT MAX(locked_until)
+        FROM login_failures
+        WHERE ((scope = $1 AND subject = $2) OR (scope = $3 AND subject = $4)) AND locked_until > NOW()
+    `
+	var lockedUntil *time.Time
+	err := r.db.QueryRowContext(ctx, query, models.LoginScopeAccount, username, models.LoginScopeIP, ip).Scan(&lockedUntil)
+	return lockedUntil, err
+}
+
+// RecordLoginFailure counts a failed login and returns the number of recent failures. Failures from before forgetBefore are forgotten
+func (r *LoginFailureRepository) RecordLoginFailure(ctx context.Context, scope, subject string, forgetBefore time.Time) (int, error) {
+	query := `
+        INSERT INTO login_failures (scope, subject, failures)
+        VALUES ($1, $2, 1)
+        ON CONFLICT (scope, subject) DO UPDATE
+        SET failures = CASE WHEN login_failures.last_failed_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+            last_failed_at = NOW()
+        RETURNING failures
+    `
+	var failures int
+	err := r.db.QueryRowContext(ctx, query, scope, subject, forgetBefore).Scan(&failures)
+	return failures, err
+}
+
+// LockLogin refuses logins of an account or from a client ip until the given time
+func (r *LoginFailureRepository) LockLogin(ctx context.Context, scope, subject string, until time.Time) error {
+	query := `UPDATE login_failures SET locked_until = $3 WHERE scope = $1 AND subject = $2`
+	_, err := r.db.ExecContext(ctx, query, scope, subject, until)
+	return err
+}
+
+// ClearLoginFailures forgets the failed logins of an account or a client ip and lifts its lock. It reports whether there were any
+func (r *LoginFailureRepository) ClearLoginFailures(ctx context.Context, scope, subject string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM login_failures WHERE scope = $1 AND subject = $2`, scope, subject)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetLockedLogins returns the accounts and client ips that are currently locked, latest lock first
+func (r *LoginFailureRepository) GetLockedLogins(ctx context.Context) ([]*models.LoginFailure, error) {
+	query := `
+        SELECT scope, subject, failures, last_failed_at, locked_until
+        FROM login_failures
+        WHERE locked_until > NOW()
+        ORDER BY locked_until DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []*models.LoginFailure{}
+	for rows.Next() {
+		failure := &models.LoginFailure{}
+		if err := rows.Scan(&failure.Scope, &failure.Subject, &failure.Failures, &failure.LastFailedAt, &failure.LockedUntil); err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// DeleteStaleLoginFailures removes the unlocked failures last recorded before the given time and returns the number of deleted rows
+func (r *LoginFailureRepository) DeleteStaleLoginFailures(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM login_failures WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < NOW())`
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
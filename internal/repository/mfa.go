@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge. Login again")
+	ErrInvalidRecoveryCode = errors.New("invalid or used recovery code")
+	ErrTOTPCodeUsed        = errors.New("two-factor code has already been used")
+)
+
+// MFARepository handles database operations for two-factor authentication
+type MFARepository struct {
+	db *sql.DB
+}
+
+// NewMFARepository creates a new two-factor authentication repository
+func NewMFARepository(db *sql.DB) *MFARepository {
+	return &MFARepository{db: db}
+}
+
+// SetTOTPSecret stores the encrypted totp secret of a user who is enrolling. Totp stays disabled until [MFARepository.EnableTOTP]
+// is called. Users that already enabled totp are left unchanged and [ErrUserNotFound] is returned
+func (r *MFARepository) SetTOTPSecret(ctx context.Context, userID uuid.UUID, sealedSecret string) error {
+	query := `
+		UPDATE users
+		SET totp_secret = $2, totp_last_counter = NULL, updated_at = NOW()
+		WHERE id = $1 AND totp_enabled = FALSE
+		`
+	result, err := r.db.ExecContext(ctx, query, userID, sealedSecret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// EnableTOTP turns on totp for a user with a stored secret within the transaction
+func (r *MFARepository) EnableTOTP(ctx context.Context, tx *sql.Tx, userID uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET totp_enabled = TRUE, updated_at = NOW()
+		WHERE id = $1 AND totp_secret IS NOT NULL
+		`
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DisableTOTP turns off totp for a user and removes their secret and recovery codes
+func (r *MFARepository) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.GetTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET totp_enabled = FALSE, totp_secret = NULL, totp_last_counter = NULL, updated_at = NOW()
+		WHERE id = $1
+		`
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPCounter records the counter of an accepted totp code. Counters must increase so that a code, or an older one, cannot be used
+// again. [ErrTOTPCodeUsed] is returned otherwise. When a transaction is passed the counter is recorded within it
+func (r *MFARepository) UseTOTPCounter(ctx context.Context, tx *sql.Tx, userID uuid.UUID, counter int64) error {
+	query := `
+		UPDATE users
+		SET totp_last_counter = $2
+		WHERE id = $1 AND (totp_last_counter IS NULL OR totp_last_counter < $2)
+		`
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, userID, counter)
+	} else {
+		result, err = r.db.ExecContext(ctx, query, userID, counter)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes stores the hashes of a new set of recovery codes of a user within the transaction. Previous codes are removed
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	for _, codeHash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, query, userID, codeHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used. Codes can only be used once. [ErrInvalidRecoveryCode] is returned
+// otherwise. When a transaction is passed the code is used within it
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, tx *sql.Tx, userID uuid.UUID, codeHash string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		`
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, userID, codeHash)
+	} else {
+		result, err = r.db.ExecContext(ctx, query, userID, codeHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidRecoveryCode
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user
+func (r *MFARepository) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// CreateMFAChallenge stores the hash of a challenge token issued after the password step of a login
+func (r *MFARepository) CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO mfa_challenges (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `
+	_, err := r.db.ExecContext(ctx, query, userID, tokenHash, expiresAt)
+	return err
+}
+
+// AttemptMFAChallenge counts an attempt at an unused and unexpired challenge and returns its ID and user. Challenges that reached the
+// maximum number of attempts are rejected. [ErrInvalidMFAChallenge] is returned otherwise
+func (r *MFARepository) AttemptMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (uuid.UUID, uuid.UUID, error) {
+	query := `
+		UPDATE mfa_challenges
+		SET attempts = attempts + 1
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2
+		RETURNING id, user_id
+		`
+	var id, userID uuid.UUID
+	if err := r.db.QueryRowContext(ctx, query, tokenHash, maxAttempts).Scan(&id, &userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, uuid.Nil, ErrInvalidMFAChallenge
+		}
+		return uuid.Nil, uuid.Nil, err
+	}
+	return id, userID, nil
+}
+
+// GetMFAChallengeUser returns the user of an unused and unexpired challenge without counting an attempt. [ErrInvalidMFAChallenge] is
+// returned otherwise
+func (r *MFARepository) GetMFAChallengeUser(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	query := `SELECT user_id FROM mfa_challenges WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+
+	var userID uuid.UUID
+	if err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, ErrInvalidMFAChallenge
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// ConsumeMFAChallenge marks a challenge as used within the transaction. [ErrInvalidMFAChallenge] is returned when it was already used
+func (r *MFARepository) ConsumeMFAChallenge(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	query := `UPDATE mfa_challenges SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+// DeleteExpiredMFAChallenges removes challenges that expired before the given time. The number of deleted challenges is returned
+func (r *MFARepository) DeleteExpiredMFAChallenges(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM mfa_challenges WHERE expires_at < $1`
+	results, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *MFARepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrInvalidOIDCState     = errors.New("invalid or expired single sign-on state")
+	ErrInvalidOIDCLoginCode = errors.New("invalid or expired single sign-on login code")
+)
+
+// OIDCRepository handles database operations for pending single sign-on logins
+type OIDCRepository struct {
+	db *sql.DB
+}
+
+// NewOIDCRepository creates a new single sign-on repository
+func NewOIDCRepository(db *sql.DB) *OIDCRepository {
+	return &OIDCRepository{db: db}
+}
+
+// CreateState stores a pending login along with its PKCE verifier and nonce
+func (r *OIDCRepository) CreateState(ctx context.Context, stateHash, codeVerifier, nonce string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO oidc_states (state_hash, code_verifier, nonce, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `
+	_, err := r.db.ExecContext(ctx, query, stateHash, codeVerifier, nonce, expiresAt)
+	return err
+}
+
+// ConsumeState removes an unexpired pending login and returns its PKCE verifier and nonce. States can only be consumed once.
+// [ErrInvalidOIDCState] is returned otherwise
+func (r *OIDCRepository) ConsumeState(ctx context.Context, stateHash string) (string, string, error) {
+	query := `DELETE FROM oidc_states WHERE state_hash = $1 AND expires_at > NOW() RETURNING code_verifier, nonce`
+
+	var codeVerifier, nonce string
+	if err := r.db.QueryRowContext(ctx, query, stateHash).Scan(&codeVerifier, &nonce); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrInvalidOIDCState
+		}
+		return "", "", err
+	}
+	return codeVerifier, nonce, nil
+}
+
+// CreateLoginCode stores the hash of a login code of a user that signed in with the provider
+func (r *OIDCRepository) CreateLoginCode(ctx context.Context, userID uuid.UUID, codeHash string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO oidc_login_codes (user_id, code_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `
+	_, err := r.db.ExecContext(ctx, query, userID, codeHash, expiresAt)
+	return err
+}
+
+// ConsumeLoginCode marks an unused and unexpired login code as used and returns its user. Codes can only be consumed once.
+// [ErrInvalidOIDCLoginCode] is returned otherwise
+func (r *OIDCRepository) ConsumeLoginCode(ctx context.Context, codeHash string) (uuid.UUID, error) {
+	query := `
+		UPDATE oidc_login_codes
+		SET used_at = NOW()
+		WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+		`
+	var userID uuid.UUID
+	if err := r.db.QueryRowContext(ctx, query, codeHash).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, ErrInvalidOIDCLoginCode
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// DeleteExpired removes pending logins and login codes that expired before the given time. The number of deleted rows is returned
+func (r *OIDCRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	states, err := r.db.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	codes, err := r.db.ExecContext(ctx, `DELETE FROM oidc_login_codes WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedStates, _ := states.RowsAffected()
+	deletedCodes, _ := codes.RowsAffected()
+	return deletedStates + deletedCodes, nil
+}
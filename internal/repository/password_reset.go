@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrInvalidPasswordReset = errors.New("invalid or expired password reset token")
+)
+
+// PasswordResetRepository handles database operations for password reset tokens
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+// NewPasswordResetRepository creates a new password reset repository
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+// CreatePasswordReset stores the hash of a reset token of a user
+func (r *PasswordResetRepository) CreatePasswordReset(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO password_resets (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `
+	_, err := r.db.ExecContext(ctx, query, userID, tokenHash, expiresAt)
+	return err
+}
+
+// ConsumePasswordReset marks an unused and unexpired reset token as used within the transaction and returns its user. Tokens can
+// only be consumed once. [ErrInvalidPasswordReset] is returned otherwise
+func (r *PasswordResetRepository) ConsumePasswordReset(ctx context.Context, tx *sql.Tx, tokenHash string) (uuid.UUID, error) {
+	query := `
+		UPDATE password_resets
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+		`
+	var userID uuid.UUID
+	if err := tx.QueryRowContext(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, ErrInvalidPasswordReset
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// DeleteExpiredPasswordResets removes reset tokens that expired before the given time. The number of deleted tokens is returned
+func (r *PasswordResetRepository) DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM password_resets WHERE expires_at < $1`
+	results, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *PasswordResetRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sgs/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrPersonalAccessTokenExists   = errors.New("a personal access token with this name already exists")
+)
+
+// PersonalAccessTokenRepository handles database operations for personal access tokens
+type PersonalAccessTokenRepository struct {
+	db *sql.DB
+}
+
+// NewPersonalAccessTokenRepository creates a new personal access token repository
+func NewPersonalAccessTokenRepository(db *sql.DB) *PersonalAccessTokenRepository {
+	return &PersonalAccessTokenRepository{db: db}
+}
+
+const personalAccessTokenColumns = `t.id, t.user_id, t.name, t.token_hash, t.token_prefix, t.expires_at, t.last_used_at, t.revoked_at, t.created_at`
+
+func scanPersonalAccessToken(row rowScanner) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&token.TokenPrefix,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+		&token.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+// CreatePersonalAccessToken stores the digest of a new token of a user. [ErrPersonalAccessTokenExists] is returned when the user already
+// has a token with the name
+func (r *PersonalAccessTokenRepository) CreatePersonalAccessToken(ctx context.Context, userID uuid.UUID, name, tokenHash, tokenPrefix string, expiresAt *time.Time) (*models.PersonalAccessToken, error) {
+	query := `
+        INSERT INTO personal_access_tokens AS t (user_id, name, token_hash, token_prefix, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + personalAccessTokenColumns
+
+	token, err := scanPersonalAccessToken(r.db.QueryRowContext(ctx, query, userID, name, tokenHash, tokenPrefix, expiresAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrPersonalAccessTokenExists
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// GetPersonalAccessTokenByHash retrieves a token by its digest. Tokens of disabled users are not returned.
+// [ErrPersonalAccessTokenNotFound] is returned when the token does not exist
+func (r *PersonalAccessTokenRepository) GetPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	query := `
+        SELECT ` + personalAccessTokenColumns + `
+        FROM personal_access_tokens t
+		JOIN users u
+		ON t.user_id = u.id
+		WHERE t.token_hash = $1 AND u.disabled_at IS NULL
+    `
+	token, err := scanPersonalAccessToken(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPersonalAccessTokenNotFound
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// GetPersonalAccessTokensByUserID retrieves the tokens of a user, most recent first
+func (r *PersonalAccessTokenRepository) GetPersonalAccessTokensByUserID(ctx context.Context, userID uuid.UUID) ([]*models.PersonalAccessToken, error) {
+	query := `SELECT ` + personalAccessTokenColumns + ` FROM personal_access_tokens t WHERE t.user_id = $1 ORDER BY t.created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokePersonalAccessToken revokes a token of a user. [ErrPersonalAccessTokenNotFound] is returned when the user has no such active token
+func (r *PersonalAccessTokenRepository) RevokePersonalAccessToken(ctx context.Context, id, userID uuid.UUID) error {
+	query := `
+        UPDATE personal_access_tokens
+        SET revoked_at = NOW()
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+    `
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+// TouchPersonalAccessToken records the use of a token
+func (r *PersonalAccessTokenRepository) TouchPersonalAccessToken(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE personal_access_tokens SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// DeleteInactivePersonalAccessTokens permanently deletes tokens that expired or were revoked before the given time. The number of
+// deleted tokens is returned
+func (r *PersonalAccessTokenRepository) DeleteInactivePersonalAccessTokens(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+        DELETE FROM personal_access_tokens
+        WHERE expires_at < $1 OR revoked_at < $1
+    `
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -164,6 +164,30 @@ func (r *ProjectRepository) GetProjectsByOwnerID(ctx context.Context, ownerID uu
 	return projects, nil
 }
 
+// GetBuckets retrieves the names of all buckets managed as projects
+func (r *ProjectRepository) GetBuckets(ctx context.Context) ([]string, error) {
+	query := `
+		SELECT bucket
+		FROM projects
+		ORDER BY bucket
+		`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []string{}
+	for rows.Next() {
+		var bucket string
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
 // DeleteProjectByID deletes a project by their ID. [ErrProjectNotFound] is returned when the associated project does not exist
 func (r *ProjectRepository) DeleteProjectByID(ctx context.Context, id uuid.UUID) error {
 	query := `
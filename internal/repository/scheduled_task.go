@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sgs/internal/models"
+	"time"
+)
+
+// ScheduledTaskRepository handles database operations for the periodic tasks of the scheduler
+type ScheduledTaskRepository struct {
+	db *sql.DB
+}
+
+// NewScheduledTaskRepository creates a new scheduled task repository
+func NewScheduledTaskRepository(db *sql.DB) *ScheduledTaskRepository {
+	return &ScheduledTaskRepository{db: db}
+}
+
+const scheduledTaskColumns = `name, schedule, last_run_at, last_status, last_error, last_duration_ms, last_run_by, next_run_at, updated_at`
+
+func scanScheduledTask(row rowScanner) (*models.ScheduledTask, error) {
+	var task models.ScheduledTask
+	var lastRunAt, nextRunAt sql.NullTime
+	var lastStatus, lastError, lastRunBy sql.NullString
+	var lastDuration sql.NullInt64
+	if err := row.Scan(
+		&task.Name,
+		&task.Schedule,
+		&lastRunAt,
+		&lastStatus,
+		&lastError,
+		&lastDuration,
+		&lastRunBy,
+		&nextRunAt,
+		&task.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if lastRunAt.Valid {
+		task.LastRunAt = &lastRunAt.Time
+	}
+	if lastStatus.Valid {
+		status := models.TaskStatus(lastStatus.String)
+		task.LastStatus = &status
+	}
+	if lastError.Valid {
+		task.LastError = &lastError.String
+	}
+	if lastDuration.Valid {
+		task.LastDurationMs = &lastDuration.Int64
+	}
+	if lastRunBy.Valid {
+		task.LastRunBy = &lastRunBy.String
+	}
+	if nextRunAt.Valid {
+		task.NextRunAt = &nextRunAt.Time
+	}
+	return &task, nil
+}
+
+// UpsertTask records a task and its schedule, keeping the results of previous runs
+func (r *ScheduledTaskRepository) UpsertTask(ctx context.Context, name, schedule string) (*models.ScheduledTask, error) {
+	query := `
+		INSERT INTO scheduled_tasks (name, schedule)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET schedule = EXCLUDED.schedule, updated_at = NOW()
+		RETURNING ` + scheduledTaskColumns
+
+	return scanScheduledTask(r.db.QueryRowContext(ctx, query, name, schedule))
+}
+
+// SetNextRun records when a task is due to run next
+func (r *ScheduledTaskRepository) SetNextRun(ctx context.Context, name string, nextRunAt time.Time) error {
+	query := `
+		UPDATE scheduled_tasks
+		SET next_run_at = $2, updated_at = NOW()
+		WHERE name = $1
+		`
+	_, err := r.db.ExecContext(ctx, query, name, nextRunAt)
+	return err
+}
+
+// ClaimRun marks the run of a task scheduled at runAt as started by a scheduler instance. Runs are claimed in order and only once, so
+// false is returned when the run or a later one was already claimed
+func (r *ScheduledTaskRepository) ClaimRun(ctx context.Context, name string, runAt time.Time, runBy string) (bool, error) {
+	query := `
+		UPDATE scheduled_tasks
+		SET last_run_at = $2,
+			last_status = 'running',
+			last_error = NULL,
+			last_duration_ms = NULL,
+			last_run_by = $3,
+			updated_at = NOW()
+		WHERE name = $1 AND (last_run_at IS NULL OR last_run_at < $2)
+		`
+	result, err := r.db.ExecContext(ctx, query, name, runAt, runBy)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// FinishRun records the outcome of the last claimed run of a task
+func (r *ScheduledTaskRepository) FinishRun(ctx context.Context, name string, status models.TaskStatus, errMsg *string, duration time.Duration) error {
+	query := `
+		UPDATE scheduled_tasks
+		SET last_status = $2,
+			last_error = $3,
+			last_duration_ms = $4,
+			updated_at = NOW()
+		WHERE name = $1
+		`
+	_, err := r.db.ExecContext(ctx, query, name, status, errMsg, duration.Milliseconds())
+	return err
+}
+
+// GetTasks retrieves all scheduled tasks
+func (r *ScheduledTaskRepository) GetTasks(ctx context.Context) ([]*models.ScheduledTask, error) {
+	query := `SELECT ` + scheduledTaskColumns + ` FROM scheduled_tasks ORDER BY name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []*models.ScheduledTask{}
+	for rows.Next() {
+		task, err := scanScheduledTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sgs/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionRepository handles database operations for login sessions and their refresh tokens
+type SessionRepository struct {
+	db *sql.DB
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+const sessionColumns = `id, user_id, family_id, token_hash, authenticated_at, expires_at, replaced_by, revoked_at, user_agent, ip_address,
+	last_seen_at, created_at`
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var replacedBy uuid.NullUUID
+	var revokedAt sql.NullTime
+	var userAgent, ipAddress sql.NullString
+	if err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.FamilyID,
+		&session.TokenHash,
+		&session.AuthenticatedAt,
+		&session.ExpiresAt,
+		&replacedBy,
+		&revokedAt,
+		&userAgent,
+		&ipAddress,
+		&session.LastSeenAt,
+		&session.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if replacedBy.Valid {
+		session.ReplacedBy = &replacedBy.UUID
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	if userAgent.Valid {
+		session.UserAgent = &userAgent.String
+	}
+	if ipAddress.Valid {
+		session.IPAddress = &ipAddress.String
+	}
+	return &session, nil
+}
+
+// CreateSession stores a new refresh token in a session family along with the device it was issued to. A new login starts a new family
+func (r *SessionRepository) CreateSession(ctx context.Context, tx *sql.Tx, userID, familyID uuid.UUID, tokenHash string, authenticatedAt, expiresAt time.Time, userAgent, ipAddress string) (*models.Session, error) {
+	query := `
+        INSERT INTO sessions (user_id, family_id, token_hash, authenticated_at, expires_at, user_agent, ip_address)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+		RETURNING ` + sessionColumns
+
+	args := []any{userID, familyID, tokenHash, authenticatedAt, expiresAt, userAgent, ipAddress}
+	if tx != nil {
+		return scanSession(tx.QueryRowContext(ctx, query, args...))
+	}
+	return scanSession(r.db.QueryRowContext(ctx, query, args...))
+}
+
+// GetSessionByTokenHashForUpdate retrieves the session of a refresh token and locks it until the transaction ends, so that a token is
+// never exchanged twice. [ErrSessionNotFound] is returned when no session matches
+func (r *SessionRepository) GetSessionByTokenHashForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE token_hash = $1 FOR UPDATE`
+
+	session, err := scanSession(tx.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSessionByTokenHash retrieves the session of a refresh token. [ErrSessionNotFound] is returned when no session matches
+func (r *SessionRepository) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE token_hash = $1`
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// ReplaceSession marks a session as exchanged for its successor
+func (r *SessionRepository) ReplaceSession(ctx context.Context, tx *sql.Tx, id, replacedBy uuid.UUID) error {
+	query := `UPDATE sessions SET replaced_by = $2 WHERE id = $1`
+	_, err := tx.ExecContext(ctx, query, id, replacedBy)
+	return err
+}
+
+// GetCurrentSession retrieves the latest refresh token of a session family, i.e. the one not replaced yet. [ErrSessionNotFound] is
+// returned when the family does not exist
+func (r *SessionRepository) GetCurrentSession(ctx context.Context, familyID uuid.UUID) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE family_id = $1 AND replaced_by IS NULL`
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, familyID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetActiveSessionsByUserID retrieves the sessions of a user that are neither revoked nor expired, most recently used first
+func (r *SessionRepository) GetActiveSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]*models.UserSession, error) {
+	query := `
+		SELECT family_id, user_agent, ip_address, authenticated_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND replaced_by IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+		`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.UserSession{}
+	for rows.Next() {
+		var session models.UserSession
+		var userAgent, ipAddress sql.NullString
+		if err := rows.Scan(&session.ID, &userAgent, &ipAddress, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if userAgent.Valid {
+			session.UserAgent = &userAgent.String
+		}
+		if ipAddress.Valid {
+			session.IPAddress = &ipAddress.String
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was used
+func (r *SessionRepository) TouchSession(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE sessions SET last_seen_at = NOW() WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// RevokeUserSession revokes a session family of a user. [ErrSessionNotFound] is returned when the user has no active session in the
+// family
+func (r *SessionRepository) RevokeUserSession(ctx context.Context, familyID, userID uuid.UUID) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+		`
+	result, err := r.db.ExecContext(ctx, query, familyID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every session family of a user except the given one. The number of revoked sessions is returned
+func (r *SessionRepository) RevokeOtherSessions(ctx context.Context, userID, keepFamilyID uuid.UUID) (int64, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL AND replaced_by IS NULL
+		`
+	result, err := r.db.ExecContext(ctx, query, userID, keepFamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeSessionFamily revokes every refresh token rotated from the same login
+func (r *SessionRepository) RevokeSessionFamily(ctx context.Context, familyID uuid.UUID) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+		`
+	_, err := r.db.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions that expired before the given time. The number of deleted sessions is returned
+func (r *SessionRepository) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM sessions WHERE expires_at < $1`
+	results, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *SessionRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sgs/internal/models"
+)
+
+// errors
+var (
+	ErrSigningKeyNotFound = errors.New("signing key not found")
+)
+
+// key of the postgres advisory lock held while replacing signing keys, so that replicas starting together create a single key
+const signingKeyLockKey int64 = 0x5367734b65797300
+
+const signingKeyColumns = `id, purpose, algorithm, private_key, public_key, retired_at, expires_at, created_at`
+
+// SigningKeyRepository handles database operations for jwt signing keys
+type SigningKeyRepository struct {
+	db *sql.DB
+}
+
+// NewSigningKeyRepository creates a new signing key repository
+func NewSigningKeyRepository(db *sql.DB) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db}
+}
+
+// LockSigningKeys blocks until no other transaction is replacing signing keys. The lock is released when the transaction ends
+func (r *SigningKeyRepository) LockSigningKeys(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, signingKeyLockKey)
+	return err
+}
+
+// CreateSigningKey stores a new signing key. If tx is nil the query is executed without a transaction
+func (r *SigningKeyRepository) CreateSigningKey(ctx context.Context, tx *sql.Tx, key *models.SigningKey) error {
+	query := `
+        INSERT INTO signing_keys (id, purpose, algorithm, private_key, public_key)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING created_at
+    `
+
+	var row *sql.Row
+	if tx != nil {
+		row = tx.QueryRowContext(ctx, query, key.ID, key.Purpose, key.Algorithm, key.PrivateKey, key.PublicKey)
+	} else {
+		row = r.db.QueryRowContext(ctx, query, key.ID, key.Purpose, key.Algorithm, key.PrivateKey, key.PublicKey)
+	}
+	return row.Scan(&key.CreatedAt)
+}
+
+// GetActiveSigningKey returns the newest key of a purpose that is not retired. [ErrSigningKeyNotFound] is returned if there is none.
+// If tx is nil the query is executed without a transaction
+func (r *SigningKeyRepository) GetActiveSigningKey(ctx context.Context, tx *sql.Tx, purpose string) (*models.SigningKey, error) {
+	query := `SELECT ` + signingKeyColumns + ` FROM signing_keys WHERE purpose = $1 AND retired_at IS NULL ORDER BY created_at DESC LIMIT 1`
+
+	var row *sql.Row
+	if tx != nil {
+		row = tx.QueryRowContext(ctx, query, purpose)
+	} else {
+		row = r.db.QueryRowContext(ctx, query, purpose)
+	}
+	key, err := scanSigningKey(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSigningKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetSigningKeys returns the unexpired keys of every purpose, newest first
+func (r *SigningKeyRepository) GetSigningKeys(ctx context.Context) ([]*models.SigningKey, error) {
+	query := `
+        SELECT ` + signingKeyColumns + `
+        FROM signing_keys
+        WHERE expires_at IS NULL OR expires_at > NOW()
+        ORDER BY created_at DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.SigningKey{}
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RetireSigningKeys stops the active keys of a purpose from signing. They keep verifying tokens until expiresAt. If tx is nil the
+// query is executed without a transaction
+func (r *SigningKeyRepository) RetireSigningKeys(ctx context.Context, tx *sql.Tx, purpose string, expiresAt time.Time) error {
+	query := `UPDATE signing_keys SET retired_at = NOW(), expires_at = $2 WHERE purpose = $1 AND retired_at IS NULL`
+
+	var err error
+	if tx != nil {
+		_, err = tx.ExecContext(ctx, query, purpose, expiresAt)
+	} else {
+		_, err = r.db.ExecContext(ctx, query, purpose, expiresAt)
+	}
+	return err
+}
+
+// DeleteExpiredSigningKeys removes the keys that expired before the given time and returns the number of deleted keys
+func (r *SigningKeyRepository) DeleteExpiredSigningKeys(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM signing_keys WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *SigningKeyRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
+
+func scanSigningKey(row rowScanner) (*models.SigningKey, error) {
+	key := &models.SigningKey{}
+	err := row.Scan(&key.ID, &key.Purpose, &key.Algorithm, &key.PrivateKey, &key.PublicKey, &key.RetiredAt, &key.ExpiresAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -3,17 +3,31 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sgs/internal/models"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // errors
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUsernameTaken = errors.New("username already in use")
 )
 
+// postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether an error is caused by a unique constraint of the database
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
 // UserRepository handles database operations for users
 type UserRepository struct {
 	db *sql.DB
@@ -24,37 +38,26 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-// CreateUser adds a new user to the database
-func (r *UserRepository) CreateUser(ctx context.Context, username, password string, fullName *string) (*models.User, error) {
-	var user models.User
-	query := `
-        INSERT INTO users (username, password, full_name)
-        VALUES ($1, $2, $3)
-		RETURNING id, username, full_name, created_at, updated_at
-    `
-	if err := r.db.QueryRowContext(ctx, query, username, password, &fullName).Scan(&user.ID, &user.Username, &user.FullName, &user.CreatedAt, &user.UpdatedAt); err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
+const userColumns = `id, username, email, email_verified, role, totp_enabled, password_reset_required, disabled_at, totp_secret, full_name,
+	password, created_at, updated_at`
 
-// GetUserByUsername retrieves a user by their email address
-func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	query := `
-		SELECT id, username, full_name, password, created_at, updated_at 
-		FROM users 
-		WHERE username = $1
-		`
+func scanUser(row rowScanner) (*models.User, error) {
 	var user models.User
-	err := r.db.QueryRow(query, username).Scan(
+	if err := row.Scan(
 		&user.ID,
 		&user.Username,
+		&user.Email,
+		&user.EmailVerified,
+		&user.Role,
+		&user.TOTPEnabled,
+		&user.PasswordResetRequired,
+		&user.DisabledAt,
+		&user.TOTPSecret,
 		&user.FullName,
 		&user.Password,
 		&user.CreatedAt,
 		&user.UpdatedAt,
-	)
-	if err != nil {
+	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
 		}
@@ -63,25 +66,290 @@ func (r *UserRepository) GetUserByUsername(ctx context.Context, username string)
 	return &user, nil
 }
 
+// CreateUser adds a new user to the database. The email is optional. When a transaction is passed the user is created within it
+func (r *UserRepository) CreateUser(ctx context.Context, tx *sql.Tx, username, password string, email, fullName *string) (*models.User, error) {
+	query := `
+        INSERT INTO users (username, password, email, full_name)
+        VALUES ($1, $2, $3, $4)
+		RETURNING ` + userColumns
+
+	if tx != nil {
+		return scanUser(tx.QueryRowContext(ctx, query, username, password, email, fullName))
+	}
+	return scanUser(r.db.QueryRowContext(ctx, query, username, password, email, fullName))
+}
+
+// GetUserByUsername retrieves a user by their username
+func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+
+	return scanUser(r.db.QueryRowContext(ctx, query, username))
+}
+
+// GetUserByEmail retrieves a user by their email address. Emails are matched case-insensitively
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE LOWER(email) = LOWER($1)`
+
+	return scanUser(r.db.QueryRowContext(ctx, query, email))
+}
+
 // GetUserByID retrieves a user by their ID
 func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	return scanUser(r.db.QueryRowContext(ctx, query, id))
+}
+
+// UpdatePassword replaces the password hash of a user and clears a required password reset. When a transaction is passed the update
+// happens within it
+func (r *UserRepository) UpdatePassword(ctx context.Context, tx *sql.Tx, id uuid.UUID, password string) error {
 	query := `
-	SELECT id, username, full_name, password, created_at, updated_at FROM users WHERE id = $1
-	`
-	var user models.User
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID,
-		&user.Username,
-		&user.FullName,
-		&user.Password,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+		UPDATE users
+		SET password = $2, password_reset_required = FALSE, updated_at = NOW()
+		WHERE id = $1
+		`
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, id, password)
+	} else {
+		result, err = r.db.ExecContext(ctx, query, id, password)
+	}
 	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkEmailVerified records that a user proved ownership of their email within the transaction
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET email_verified = TRUE, updated_at = NOW()
+		WHERE id = $1
+		`
+	_, err := tx.ExecContext(ctx, query, id)
+	return err
+}
+
+// SetRole changes the role of a user. When a transaction is passed the update happens within it
+func (r *UserRepository) SetRole(ctx context.Context, tx *sql.Tx, id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = $2, updated_at = NOW()
+		WHERE id = $1
+		`
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, query, id, role)
+	} else {
+		result, err = r.db.ExecContext(ctx, query, id, role)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateProfile replaces the username and full name of a user. [ErrUsernameTaken] is returned when another user has the username
+func (r *UserRepository) UpdateProfile(ctx context.Context, id uuid.UUID, username string, fullName *string) (*models.User, error) {
+	query := `
+		UPDATE users
+		SET username = $2, full_name = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + userColumns
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id, username, fullName))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrUsernameTaken
+		}
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
+}
+
+// GetPreferences returns the preferences of a user. Preferences the user never set take their default value
+func (r *UserRepository) GetPreferences(ctx context.Context, id uuid.UUID) (*models.UserPreferences, error) {
+	var raw []byte
+	if err := r.db.QueryRowContext(ctx, `SELECT preferences FROM users WHERE id = $1`, id).Scan(&raw); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	preferences := models.DefaultUserPreferences()
+	if err := json.Unmarshal(raw, &preferences); err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+// UpdatePreferences replaces the preferences of a user
+func (r *UserRepository) UpdatePreferences(ctx context.Context, id uuid.UUID, preferences models.UserPreferences) error {
+	raw, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET preferences = $2, updated_at = NOW() WHERE id = $1`, id, raw)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetUsers returns the users matching a filter, newest first
+func (r *UserRepository) GetUsers(ctx context.Context, filter models.UserFilter, limit, offset int) ([]*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE TRUE`
+	args := []any{}
+	if filter.Query != "" {
+		// escape the wildcards of LIKE so that the query is matched literally
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Query) + "%"
+		args = append(args, pattern)
+		query += fmt.Sprintf(" AND (username ILIKE $%[1]d OR email ILIKE $%[1]d OR full_name ILIKE $%[1]d)", len(args))
+	}
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		query += fmt.Sprintf(" AND role = $%d", len(args))
+	}
+	switch filter.Status {
+	case models.UserStatusActive:
+		query += " AND disabled_at IS NULL"
+	case models.UserStatusDisabled:
+		query += " AND disabled_at IS NOT NULL"
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// CountAdmins returns the number of users with the admin role
+func (r *UserRepository) CountAdmins(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE role = $1`, models.RoleAdmin).Scan(&count)
+	return count, err
+}
+
+// SetDisabled disables or re-enables a user
+func (r *UserRepository) SetDisabled(ctx context.Context, id uuid.UUID, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, updated_at = NOW()
+		WHERE id = $1
+		`
+	result, err := r.db.ExecContext(ctx, query, id, disabled)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RequirePasswordReset makes a user reset their password before they can login again
+func (r *UserRepository) RequirePasswordReset(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET password_reset_required = TRUE, updated_at = NOW()
+		WHERE id = $1
+		`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes a user within the transaction, along with the projects they own and every row referencing them that does not
+// cascade. Files they uploaded to projects of other users are handed over to the project owners. The buckets of the projects must be
+// removed from the store beforehand
+func (r *UserRepository) DeleteUser(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	queries := []string{
+		`DELETE FROM files WHERE project_id IN (SELECT id FROM projects WHERE owner_id = $1)`,
+		`UPDATE files SET uploaded_by = p.owner_id FROM projects p WHERE files.project_id = p.id AND files.uploaded_by = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		`DELETE FROM imports WHERE owner_id = $1`,
+		`DELETE FROM projects WHERE owner_id = $1`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetTx starts a new database transaction to be used in other operations. The isolation level is ReadCommitted. The transaction should be committed on success or rolled backed on error
+func (r *UserRepository) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 }
@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// errors
+var (
+	ErrDuplicateTask = errors.New("task already registered")
+)
+
+const (
+	// key of the postgres advisory lock held by the leader. Shared by every replica
+	leaderLockKey int64 = 0x5367735363686564
+	// delay between attempts of followers to become the leader
+	electionInterval = 15 * time.Second
+	// interval at which the leader verifies that its session, and with it the lock, is still alive
+	leaderCheckInterval = 15 * time.Second
+)
+
+// Task is the work of a periodic task. The context is cancelled when the scheduler loses its leadership or stops
+type Task func(ctx context.Context) error
+
+type task struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	run      Task
+}
+
+// Scheduler runs named periodic tasks on cron schedules. Every replica runs a scheduler but only the one holding a postgres advisory lock
+// runs the tasks, so that each scheduled run happens once across replicas
+type Scheduler struct {
+	db       *sql.DB
+	taskRepo *repository.ScheduledTaskRepository
+	id       string
+	tasks    []*task
+	leader   atomic.Bool
+}
+
+// New creates a new scheduler
+func New(db *sql.DB, taskRepo *repository.ScheduledTaskRepository) *Scheduler {
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		db:       db,
+		taskRepo: taskRepo,
+		id:       fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+	}
+}
+
+// Register adds a named task that runs on a standard 5-field cron expression or a descriptor such as @hourly. Tasks must be registered
+// before the scheduler runs
+func (s *Scheduler) Register(name, spec string, run Task) error {
+	for _, t := range s.tasks {
+		if t.name == name {
+			return fmt.Errorf("%w: %s", ErrDuplicateTask, name)
+		}
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule for task %s: %w", name, err)
+	}
+
+	s.tasks = append(s.tasks, &task{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// ID returns the identifier of the scheduler instance
+func (s *Scheduler) ID() string {
+	return s.id
+}
+
+// IsLeader reports whether this scheduler instance currently runs the tasks
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Run campaigns for leadership until the context is done, running the tasks for as long as it leads
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		if err := s.campaign(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("scheduler leader election failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(electionInterval):
+		}
+	}
+}
+
+// campaign tries to acquire the leader lock and runs the tasks while holding it. The lock belongs to the database session, so a
+// dedicated connection is held for the duration of the leadership
+func (s *Scheduler) campaign(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", leaderLockKey).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired {
+		return nil
+	}
+	// discard the session instead of returning it to the pool when leadership ends, which releases the lock with it
+	defer conn.Raw(func(any) error { return driver.ErrBadConn })
+
+	s.leader.Store(true)
+	defer s.leader.Store(false)
+	log.Printf("scheduler %s elected leader\n", s.id)
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, t := range s.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.schedule(leadCtx, t)
+		}()
+	}
+
+	// lead until stopped or until the session holding the lock is lost
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for leadCtx.Err() == nil {
+		select {
+		case <-leadCtx.Done():
+		case <-ticker.C:
+			if err := conn.PingContext(leadCtx); err != nil && leadCtx.Err() == nil {
+				log.Printf("scheduler %s lost leadership: %v\n", s.id, err)
+				cancel()
+			}
+		}
+	}
+	cancel()
+	wg.Wait()
+	return nil
+}
+
+// schedule runs a task on its schedule until the context is done
+func (s *Scheduler) schedule(ctx context.Context, t *task) {
+	record, err := s.taskRepo.UpsertTask(ctx, t.name, t.spec)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("failed to register task %s: %v\n", t.name, err)
+		}
+		return
+	}
+	lastRun := record.LastRunAt
+
+	for {
+		runAt := nextRun(t.schedule, lastRun, time.Now())
+		if err := s.taskRepo.SetNextRun(ctx, t.name, runAt); err != nil && ctx.Err() == nil {
+			log.Printf("failed to record next run of task %s: %v\n", t.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(runAt)):
+		}
+
+		s.execute(ctx, t, runAt)
+		lastRun = &runAt
+	}
+}
+
+// execute claims the run of a task scheduled at runAt and records its outcome. Runs already claimed by another leader are skipped
+func (s *Scheduler) execute(ctx context.Context, t *task, runAt time.Time) {
+	claimed, err := s.taskRepo.ClaimRun(ctx, t.name, runAt, s.id)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("failed to claim run of task %s: %v\n", t.name, err)
+		}
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	start := time.Now()
+	err = safeRun(ctx, t.run)
+	duration := time.Since(start)
+
+	status := models.TaskSucceeded
+	var errMsg *string
+	if err != nil {
+		msg := err.Error()
+		status, errMsg = models.TaskFailed, &msg
+		log.Printf("task %s failed after %s: %v\n", t.name, duration.Round(time.Millisecond), err)
+	}
+
+	// the outcome is saved even when leadership ends during the run
+	if err := s.taskRepo.FinishRun(context.Background(), t.name, status, errMsg, duration); err != nil {
+		log.Printf("failed to save outcome of task %s: %v\n", t.name, err)
+	}
+}
+
+// safeRun runs a task and converts panics into failures
+func safeRun(ctx context.Context, run Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+// nextRun returns the time of the next run of a schedule. A run missed since the last one, e.g. while no replica was leading, is due
+// immediately. Only the latest missed run is caught up
+func nextRun(schedule cron.Schedule, lastRun *time.Time, now time.Time) time.Time {
+	next := schedule.Next(now)
+	if lastRun == nil {
+		return next
+	}
+
+	missed := schedule.Next(*lastRun)
+	if !missed.Before(now) {
+		return next
+	}
+	// find the latest run before now
+	for run := schedule.Next(missed); run.Before(now); run = schedule.Next(run) {
+		missed = run
+	}
+	return missed
+}
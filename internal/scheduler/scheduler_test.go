@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestNextRun(t *testing.T) {
+	hourly, err := cron.ParseStandard("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	at := func(hour, minute int) *time.Time {
+		v := time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+		return &v
+	}
+
+	tests := []struct {
+		name    string
+		lastRun *time.Time
+		want    time.Time
+	}{
+		{name: "never run", lastRun: nil, want: *at(11, 0)},
+		{name: "up to date", lastRun: at(10, 0), want: *at(11, 0)},
+		{name: "one missed run", lastRun: at(9, 0), want: *at(10, 0)},
+		{name: "several missed runs", lastRun: at(5, 0), want: *at(10, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextRun(hourly, tt.lastRun, now); !got.Equal(tt.want) {
+				t.Fatalf("expected next run at %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := New(nil, nil)
+	noop := func(context.Context) error { return nil }
+
+	if err := s.Register("purge", "@daily", noop); err != nil {
+		t.Fatalf("expected task to register, got %v", err)
+	}
+	if err := s.Register("purge", "@hourly", noop); !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("expected duplicate task error, got %v", err)
+	}
+	if err := s.Register("invalid", "every day", noop); err == nil {
+		t.Fatal("expected invalid schedule to be rejected")
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sgs/internal/accounts"
+	"sgs/internal/config"
+	"sgs/internal/jobs"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errors
+var (
+	ErrExportInProgress     = errors.New("an export is already in progress")
+	ErrExportNotReady       = errors.New("export is not ready for download")
+	ErrDeletionConfirmation = errors.New("confirmation must match your username")
+	ErrRecentLoginRequired  = errors.New("login again to confirm the deletion of your account")
+)
+
+// the session deleting an account must have logged in within this window, so that a stolen session cannot delete it
+const accountDeletionLoginWindow = 10 * time.Minute
+
+// AccountHandler provides self-service profile management, exports and deletion of the logged-in user's account
+type AccountHandler struct {
+	cfg         *config.Config
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	exportRepo  *repository.AccountExportRepository
+	accounts    *accounts.Manager
+	queue       *jobs.Queue
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(cfg *config.Config, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, exportRepo *repository.AccountExportRepository,
+	accounts *accounts.Manager, queue *jobs.Queue) *AccountHandler {
+	return &AccountHandler{
+		cfg:         cfg,
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		exportRepo:  exportRepo,
+		accounts:    accounts,
+		queue:       queue,
+	}
+}
+
+// RequestExport starts building an archive of the projects, files and metadata of the logged-in user in the background
+func (s *AccountHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	exports, err := s.exportRepo.GetAccountExportsByUserID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve exports: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to request export"})
+		return
+	}
+	for _, export := range exports {
+		if export.Status == models.ExportPending || export.Status == models.ExportRunning {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrExportInProgress.Error(), Data: export})
+			return
+		}
+	}
+
+	export, err := s.exportRepo.CreateAccountExport(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to create export: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to request export"})
+		return
+	}
+	if _, err := s.queue.Enqueue(r.Context(), accounts.ExportJobType, accounts.ExportJob{ExportID: export.ID}, &userID); err != nil {
+		log.Printf("failed to queue export %s: %v\n", export.ID, err)
+		errMsg := "failed to queue export"
+		if err := s.exportRepo.UpdateAccountExportStatus(r.Context(), export.ID, models.ExportFailed, &errMsg); err != nil {
+			log.Printf("failed to mark export %s as failed: %v\n", export.ID, err)
+		}
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to request export"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusAccepted, models.APIResponse{Message: "Export started", Data: export})
+}
+
+// GetExports retrieves the exports of the logged-in user
+func (s *AccountHandler) GetExports(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	exports, err := s.exportRepo.GetAccountExportsByUserID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve exports: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve exports"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Exports retrieved successfully", Data: exports})
+}
+
+// GetExport retrieves a single export of the logged-in user
+func (s *AccountHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := s.getExport(w, r)
+	if !ok {
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Export retrieved successfully", Data: export})
+}
+
+// DownloadExport streams the archive of a completed export of the logged-in user
+func (s *AccountHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := s.getExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != models.ExportCompleted || (export.ExpiresAt != nil && export.ExpiresAt.Before(time.Now())) {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrExportNotReady.Error()})
+		return
+	}
+
+	reader, object, err := s.accounts.OpenExport(r.Context(), export)
+	if err != nil {
+		log.Printf("failed to open export archive: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to download export"})
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=sgs-export-%s.zip;", export.CreatedAt.Format("2006-01-02")))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("failed to stream export archive: %v\n", err)
+	}
+}
+
+// DeleteAccountRequest represents the account deletion payload
+type DeleteAccountRequest struct {
+	// username of the account, typed by the user to confirm the deletion
+	Confirmation string `json:"confirmation"`
+}
+
+// DeleteAccount disables the account of the logged-in user right away and deletes it along with their projects, files, api keys and
+// sessions in the background. The deletion must be confirmed with the username from a session that logged in recently
+func (s *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	sessionID, ok := GetSessionID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete account"})
+		return
+	}
+	if req.Confirmation != user.Username {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: ErrDeletionConfirmation.Error()})
+		return
+	}
+
+	session, err := s.sessionRepo.GetCurrentSession(r.Context(), sessionID)
+	if err != nil {
+		log.Printf("failed to retrieve session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete account"})
+		return
+	}
+	if time.Since(session.AuthenticatedAt) > accountDeletionLoginWindow {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrRecentLoginRequired.Error()})
+		return
+	}
+
+	if user.Role == models.RoleAdmin {
+		admins, err := s.userRepo.CountAdmins(r.Context())
+		if err != nil {
+			log.Printf("failed to count admins: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete account"})
+			return
+		}
+		if admins <= 1 {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrLastAdmin.Error()})
+			return
+		}
+	}
+
+	if err := s.userRepo.SetDisabled(r.Context(), userID, true); err != nil {
+		log.Printf("failed to disable user for deletion: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete account"})
+		return
+	}
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), userID, uuid.Nil); err != nil {
+		log.Printf("failed to revoke sessions of deleted user: %v\n", err)
+	}
+
+	job, err := s.queue.Enqueue(r.Context(), accounts.DeleteJobType, accounts.DeleteJob{UserID: userID}, &userID)
+	if err != nil {
+		log.Printf("failed to queue deletion of user %s: %v\n", userID, err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete account. The account has been disabled, contact an admin to complete the deletion"})
+		return
+	}
+	log.Printf("user %s requested the deletion of their account\n", userID)
+
+	s.sendResponse(w, http.StatusAccepted, models.APIResponse{Message: "Account deletion started", Data: job})
+}
+
+// helper methods
+
+// getExport retrieves the export in the path, which must belong to the logged-in user. A response is sent and false is returned when
+// the export cannot be retrieved
+func (s *AccountHandler) getExport(w http.ResponseWriter, r *http.Request) (*models.AccountExport, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return nil, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid export ID"})
+		return nil, false
+	}
+
+	export, err := s.exportRepo.GetAccountExportByID(r.Context(), id)
+	if err != nil && !errors.Is(err, repository.ErrAccountExportNotFound) {
+		log.Printf("failed to retrieve export: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve export"})
+		return nil, false
+	}
+	// exports of other users are reported as missing so that their ids cannot be probed
+	if err != nil || export.UserID != userID {
+		s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: repository.ErrAccountExportNotFound.Error()})
+		return nil, false
+	}
+	return export, true
+}
+
+func (s *AccountHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
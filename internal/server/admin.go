@@ -0,0 +1,250 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sgs/internal/config"
+	"sgs/internal/importer"
+	"sgs/internal/jobs"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// AdminHandler provides administrative functionality over the store
+type AdminHandler struct {
+	importer      *importer.Importer
+	queue         *jobs.Queue
+	importRepo    *repository.ImportRepository
+	userRepo      *repository.UserRepository
+	sessionRepo   *repository.SessionRepository
+	dashboardRepo *repository.DashboardRepository
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(importer *importer.Importer, queue *jobs.Queue, importRepo *repository.ImportRepository, userRepo *repository.UserRepository,
+	sessionRepo *repository.SessionRepository, dashboardRepo *repository.DashboardRepository) *AdminHandler {
+	return &AdminHandler{
+		importer:      importer,
+		queue:         queue,
+		importRepo:    importRepo,
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		dashboardRepo: dashboardRepo,
+	}
+}
+
+// GetUnmanagedBuckets lists buckets in the store that are not managed as projects
+func (s *AdminHandler) GetUnmanagedBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := s.importer.UnmanagedBuckets(r.Context())
+	if err != nil {
+		log.Printf("failed to retrieve unmanaged buckets: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve buckets"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Buckets retrieved successfully", Data: buckets})
+}
+
+// AdoptBucketRequest represents the bucket adoption payload
+type AdoptBucketRequest struct {
+	OwnerID uuid.UUID `json:"ownerId"`
+}
+
+// AdoptBucket imports an existing bucket as a project owned by the given user. The objects are scanned into files in the background
+func (s *AdminHandler) AdoptBucket(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	// parse the request body
+	var req AdoptBucketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if req.OwnerID == uuid.Nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "owner id is required"})
+		return
+	}
+
+	// verify that the owner exists
+	if _, err := s.userRepo.GetUserByID(r.Context(), req.OwnerID); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve bucket owner: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to adopt bucket"})
+		return
+	}
+
+	imp, err := s.importer.AdoptBucket(r.Context(), bucket, req.OwnerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, importer.ErrBucketNotFound):
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+		case errors.Is(err, importer.ErrBucketManaged), errors.Is(err, config.ErrBucketReserved):
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: err.Error()})
+		default:
+			log.Printf("failed to adopt bucket: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to adopt bucket"})
+		}
+		return
+	}
+
+	if !s.enqueueImport(w, r, imp) {
+		return
+	}
+
+	s.sendResponse(w, http.StatusAccepted, models.APIResponse{Message: "Bucket import started", Data: imp})
+}
+
+// ImportExternalRequest represents the payload for importing an external bucket into a project
+type ImportExternalRequest struct {
+	Endpoint  string `json:"endpoint"`
+	Secure    bool   `json:"secure"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// validate import request
+func (data *ImportExternalRequest) validate() error {
+	if data.Endpoint == "" || data.Bucket == "" {
+		return fmt.Errorf("source endpoint and bucket are required")
+	}
+	if data.AccessKey == "" || data.SecretKey == "" {
+		return fmt.Errorf("source access key and secret key are required")
+	}
+	return nil
+}
+
+// ImportExternal copies the objects of a bucket on an external S3-compatible endpoint into a project in the background
+func (s *AdminHandler) ImportExternal(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid project ID"})
+		return
+	}
+
+	// parse the request body
+	var req ImportExternalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	source := models.ImportSource{Endpoint: req.Endpoint, Secure: req.Secure, Bucket: req.Bucket, Prefix: req.Prefix, AccessKey: req.AccessKey}
+	imp, err := s.importer.ImportExternal(r.Context(), projectID, source, req.SecretKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrProjectNotFound), errors.Is(err, importer.ErrSourceNotFound):
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+		default:
+			log.Printf("failed to start external import: %v\n", err)
+			s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
+		}
+		return
+	}
+
+	if !s.enqueueImport(w, r, imp) {
+		return
+	}
+
+	s.sendResponse(w, http.StatusAccepted, models.APIResponse{Message: "Import started", Data: imp})
+}
+
+// GetImports retrieves all bucket imports
+func (s *AdminHandler) GetImports(w http.ResponseWriter, r *http.Request) {
+	imports, err := s.importRepo.GetImports(r.Context())
+	if err != nil {
+		log.Printf("failed to retrieve imports: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve imports"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Imports retrieved successfully", Data: imports})
+}
+
+// GetImport retrieves the progress of a single bucket import
+func (s *AdminHandler) GetImport(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid import ID"})
+		return
+	}
+
+	imp, err := s.importRepo.GetImportByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrImportNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve import: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve import"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Import retrieved successfully", Data: imp})
+}
+
+// ResumeImport queues a failed or unqueued import to continue from its last imported object
+func (s *AdminHandler) ResumeImport(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid import ID"})
+		return
+	}
+
+	imp, err := s.importRepo.GetImportByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrImportNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve import: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to resume import"})
+		return
+	}
+	if imp.Status == models.ImportCompleted || imp.Status == models.ImportRunning {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: fmt.Sprintf("Import is already %s", imp.Status)})
+		return
+	}
+
+	if err := s.importRepo.UpdateImportStatus(r.Context(), imp.ID, models.ImportPending, nil); err != nil {
+		log.Printf("failed to reset import status: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to resume import"})
+		return
+	}
+	if !s.enqueueImport(w, r, imp) {
+		return
+	}
+
+	s.sendResponse(w, http.StatusAccepted, models.APIResponse{Message: "Import resumed"})
+}
+
+// enqueueImport queues a job to process an import. A response is sent and false is returned when the job cannot be queued
+func (s *AdminHandler) enqueueImport(w http.ResponseWriter, r *http.Request, imp *models.Import) bool {
+	userID, _ := GetUserID(r)
+	if _, err := s.queue.Enqueue(r.Context(), importer.JobType, importer.Job{ImportID: imp.ID}, &userID); err != nil {
+		log.Printf("failed to queue import %s: %v\n", imp.ID, err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to queue import. Resume it to try again", Data: imp})
+		return false
+	}
+	return true
+}
+
+func (s *AdminHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sgs/internal/accounts"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errors
+var (
+	ErrSelfAdministration = errors.New("admins cannot perform this action on their own account")
+	ErrLastAdmin          = errors.New("the last admin cannot be demoted or deleted")
+)
+
+const (
+	defaultUsersLimit = 50
+	maxUsersLimit     = 500
+)
+
+// GetUsers lists the users matching the q, role and status query parameters, newest first. The page is selected with the limit and
+// offset query parameters
+func (s *AdminHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.UserFilter{Query: query.Get("q"), Role: query.Get("role"), Status: query.Get("status")}
+	if filter.Status != "" && filter.Status != models.UserStatusActive && filter.Status != models.UserStatusDisabled {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid status"})
+		return
+	}
+
+	limit := defaultUsersLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid limit"})
+			return
+		}
+		limit = min(parsed, maxUsersLimit)
+	}
+	offset := 0
+	if raw := query.Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid offset"})
+			return
+		}
+		offset = parsed
+	}
+
+	users, err := s.userRepo.GetUsers(r.Context(), filter, limit, offset)
+	if err != nil {
+		log.Printf("failed to retrieve users: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve users"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Users retrieved successfully", Data: users})
+}
+
+// GetUser retrieves a single user
+func (s *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getUser(w, r)
+	if !ok {
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "User retrieved successfully", Data: user})
+}
+
+// DisableUser stops a user from logging in. Their sessions are revoked and their api keys stop working until they are enabled again
+func (s *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getOtherUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.userRepo.SetDisabled(r.Context(), user.ID, true); err != nil {
+		log.Printf("failed to disable user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to disable user"})
+		return
+	}
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), user.ID, uuid.Nil); err != nil {
+		log.Printf("failed to revoke sessions of disabled user: %v\n", err)
+	}
+	adminID, _ := GetUserID(r)
+	log.Printf("admin %s disabled user %s\n", adminID, user.ID)
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "User disabled successfully"})
+}
+
+// EnableUser lets a disabled user login again
+func (s *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getOtherUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.userRepo.SetDisabled(r.Context(), user.ID, false); err != nil {
+		log.Printf("failed to enable user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to enable user"})
+		return
+	}
+	adminID, _ := GetUserID(r)
+	log.Printf("admin %s enabled user %s\n", adminID, user.ID)
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "User enabled successfully"})
+}
+
+// UpdateRoleRequest represents the payload for changing the role of a user
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// UpdateUserRole changes the role of a user. The last admin cannot be demoted
+func (s *AdminHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	var req UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if req.Role != models.RoleUser && req.Role != models.RoleAdmin {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "role must be user or admin"})
+		return
+	}
+
+	user, ok := s.getOtherUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Role == models.RoleAdmin && req.Role != models.RoleAdmin && !s.checkNotLastAdmin(w, r) {
+		return
+	}
+
+	if err := s.userRepo.SetRole(r.Context(), nil, user.ID, req.Role); err != nil {
+		log.Printf("failed to update user role: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update user role"})
+		return
+	}
+	adminID, _ := GetUserID(r)
+	log.Printf("admin %s changed the role of user %s to %s\n", adminID, user.ID, req.Role)
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "User role updated successfully"})
+}
+
+// DeleteUser disables a user right away and deletes them along with their projects and files in the background. The deletion job is
+// returned to follow its progress
+func (s *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getOtherUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Role == models.RoleAdmin && !s.checkNotLastAdmin(w, r) {
+		return
+	}
+
+	if err := s.userRepo.SetDisabled(r.Context(), user.ID, true); err != nil {
+		log.Printf("failed to disable user for deletion: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete user"})
+		return
+	}
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), user.ID, uuid.Nil); err != nil {
+		log.Printf("failed to revoke sessions of deleted user: %v\n", err)
+	}
+
+	adminID, _ := GetUserID(r)
+	job, err := s.queue.Enqueue(r.Context(), accounts.DeleteJobType, accounts.DeleteJob{UserID: user.ID}, &adminID)
+	if err != nil {
+		log.Printf("failed to queue deletion of user %s: %v\n", user.ID, err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to queue user deletion. The user has been disabled"})
+		return
+	}
+	log.Printf("admin %s queued the deletion of user %s\n", adminID, user.ID)
+
+	s.sendResponse(w, http.StatusAccepted, models.APIResponse{Message: "User deletion started", Data: job})
+}
+
+// GetSystemStats retrieves the totals of users, projects, files and api keys across the server
+func (s *AdminHandler) GetSystemStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.dashboardRepo.GetSystemStats(r.Context())
+	if err != nil {
+		log.Printf("failed to retrieve system stats: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve system stats"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "System stats retrieved successfully", Data: stats})
+}
+
+// helper methods
+
+// getUser retrieves the user in the path. A response is sent and false is returned when the user cannot be retrieved
+func (s *AdminHandler) getUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid user ID"})
+		return nil, false
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return nil, false
+		}
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// getOtherUser retrieves the user in the path like [AdminHandler.getUser], refusing the admin's own account so that admins cannot lock
+// themselves out
+func (s *AdminHandler) getOtherUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := s.getUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if adminID, _ := GetUserID(r); user.ID == adminID {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrSelfAdministration.Error()})
+		return nil, false
+	}
+	return user, true
+}
+
+// checkNotLastAdmin responds with [ErrLastAdmin] and returns false when there is a single admin left
+func (s *AdminHandler) checkNotLastAdmin(w http.ResponseWriter, r *http.Request) bool {
+	admins, err := s.userRepo.CountAdmins(r.Context())
+	if err != nil {
+		log.Printf("failed to count admins: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to count admins"})
+		return false
+	}
+	if admins <= 1 {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrLastAdmin.Error()})
+		return false
+	}
+	return true
+}
@@ -1,16 +1,17 @@
 package server
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sgs/internal/authz"
+	"sgs/internal/config"
 	"sgs/internal/models"
 	"sgs/internal/repository"
+	"sgs/internal/utils"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,19 +20,56 @@ import (
 
 // errors
 var (
-	ErrAPIKeyInUse           = errors.New("API Key already exists")
-	ErrAPIKeyAccessForbidden = errors.New("forbidden access. You don't have access to this API key")
+	ErrAPIKeyInUse = errors.New("API Key already exists")
 )
 
+const (
+	// prefix of api key tokens
+	apiKeyPrefix = "sgs_"
+	// number of leading characters of a token stored in clear to identify its key. The rest of the token is only stored as a digest
+	apiKeyPrefixLength = len(apiKeyPrefix) + 8
+)
+
+const (
+	// the grace period requested for a rotation cannot exceed this
+	maxAPIKeyRotationGrace = 30 * 24 * time.Hour
+	// number of days of usage reported along with api keys
+	usageReportDays = 30
+	// number of latest denials reported for an api key
+	apiKeyDenialsLimit = 100
+)
+
+// apiKeyStore is the subset of the api key repository used by the handlers
+type apiKeyStore interface {
+	CreateAPIKey(ctx context.Context, tokenHash, tokenPrefix, name string, projectID, userID uuid.UUID, expiresAt time.Time, scopes []string,
+		restrictions models.APIKeyRestrictions, sealedSigningSecret string) (*models.APIKey, error)
+	GetAPIKeyByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error)
+	GetAPIKeysByProjectID(ctx context.Context, projectID, userID uuid.UUID) ([]*models.APIKey, error)
+	GetAPIKeysByUserID(ctx context.Context, userID uuid.UUID) ([]*models.APIKey, error)
+	GetAPIKeyUsage(ctx context.Context, id uuid.UUID, since time.Time) ([]*models.APIKeyUsage, error)
+	GetAPIKeyUsageByUserID(ctx context.Context, userID uuid.UUID, since time.Time) ([]*models.APIKeyUsage, error)
+	GetAPIKeyRotationChain(ctx context.Context, id uuid.UUID) ([]*models.APIKey, error)
+	GetAPIKeyDenials(ctx context.Context, id uuid.UUID, limit int) ([]*models.APIKeyDenial, error)
+	RotateAPIKey(ctx context.Context, id uuid.UUID, tokenHash, tokenPrefix string, expiresAt, revokesAt time.Time,
+		sealedSigningSecret string) (*models.APIKey, error)
+	UpdateAPIKeyRestrictions(ctx context.Context, id, userID uuid.UUID, restrictions models.APIKeyRestrictions) (*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, userID uuid.UUID) error
+	DeleteAPIKey(ctx context.Context, id, userID uuid.UUID) error
+}
+
 // APIKeyHandler provides functionality for managing a APIKey
 type APIKeyHandler struct {
-	apiKeyRepo *repository.APIKeyRepository
+	cfg         *config.Config
+	apiKeyRepo  apiKeyStore
+	projectRepo projectStore
 }
 
 // NewAPIKeyHandler creates a new APIKey handler
-func NewAPIKeyHandler(apiKeyRepo *repository.APIKeyRepository) *APIKeyHandler {
+func NewAPIKeyHandler(cfg *config.Config, apiKeyRepo *repository.APIKeyRepository, projectRepo *repository.ProjectRepository) *APIKeyHandler {
 	return &APIKeyHandler{
-		apiKeyRepo: apiKeyRepo,
+		cfg:         cfg,
+		apiKeyRepo:  apiKeyRepo,
+		projectRepo: projectRepo,
 	}
 }
 
@@ -39,6 +77,12 @@ func NewAPIKeyHandler(apiKeyRepo *repository.APIKeyRepository) *APIKeyHandler {
 type CreateAPIKeyRequest struct {
 	Name      string    `json:"name"`
 	ExpiresAt time.Time `json:"expiresAt"`
+	// optional. Keys created without scopes get the default scopes
+	Scopes []string `json:"scopes"`
+	// optional. Keys created without restrictions can be used from any address at any time
+	Restrictions models.APIKeyRestrictions `json:"restrictions"`
+	// optional. Keys in signing mode only accept requests signed with their signing secret
+	Signed bool `json:"signed"`
 }
 
 // validate register request
@@ -47,12 +91,31 @@ func (data *CreateAPIKeyRequest) validate() error {
 		return fmt.Errorf("token name and expiry are required")
 	}
 
-	if data.ExpiresAt.IsZero() {
+	if err := validateAPIKeyExpiry(data.ExpiresAt); err != nil {
+		return err
+	}
+
+	scopes, err := normalizeScopes(data.Scopes)
+	if err != nil {
+		return err
+	}
+	data.Scopes = scopes
+
+	if err := normalizeRestrictions(&data.Restrictions); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateAPIKeyExpiry checks that the expiry of a new key is between 1 hour and 1 year from now
+func validateAPIKeyExpiry(expiry time.Time) error {
+	if expiry.IsZero() {
 		return fmt.Errorf("expiry time is required")
 	}
 
 	// convert ExpiresAt to UTC for comparison
-	expiresAt := data.ExpiresAt.UTC()
+	expiresAt := expiry.UTC()
 
 	// current utc time for validation
 	now := time.Now().UTC()
@@ -83,14 +146,15 @@ func (s *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 	userID, ok := GetUserID(r)
 	if !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
 	}
 
-	// get the project id
-	projectID, err := uuid.Parse(mux.Vars(r)["id"])
-	if err != nil {
-		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid project ID"})
+	// get the project and verify that its keys can be managed
+	project, ok := s.getManagedProject(w, r)
+	if !ok {
 		return
 	}
+	projectID := project.ID
 
 	// parse the request body
 	var req CreateAPIKeyRequest
@@ -104,24 +168,51 @@ func (s *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
 		return
 	}
+	// keys creating keys cannot grant more than their own scopes
+	if key, ok := GetRequestAPIKey(r); ok {
+		for _, scope := range req.Scopes {
+			if !key.HasScope(scope) {
+				s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: fmt.Sprintf("API key cannot grant the %s scope it lacks", scope)})
+				return
+			}
+		}
+		// nor lift their own restrictions. keys created without restrictions get the restrictions of the key creating them
+		if unrestricted(req.Restrictions) {
+			req.Restrictions = key.Restrictions
+		} else if !restrictionsWithin(req.Restrictions, key.Restrictions) {
+			s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "API key cannot grant access beyond its own restrictions"})
+			return
+		}
+	}
 
 	// generate token
-	token, err := s.generateAPIKey("sgs", req.Name, projectID)
+	token, err := generateAPIKey()
 	if err != nil {
 		log.Printf("failed to generate API key: %v\n", err)
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to generate API key"})
 		return
 	}
 
-	//  save bucket details in db
-	APIKey, err := s.apiKeyRepo.CreateAPIKey(r.Context(), token, req.Name, projectID, userID, req.ExpiresAt)
+	var signingSecret, sealedSigningSecret string
+	if req.Signed {
+		if signingSecret, sealedSigningSecret, err = newSigningSecret(s.cfg.JwtSecret); err != nil {
+			log.Printf("failed to generate API key signing secret: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to generate API key"})
+			return
+		}
+	}
+
+	// only the digest of the token is saved, so it is returned in this response only
+	APIKey, err := s.apiKeyRepo.CreateAPIKey(r.Context(), utils.HashToken(token), apiKeyTokenPrefix(token), req.Name, projectID, userID, req.ExpiresAt,
+		req.Scopes, req.Restrictions, sealedSigningSecret)
 	if err != nil {
 		log.Printf("failed to save API key in db: %v\n", err)
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
 		return
 	}
+	revealAPIKey(APIKey, token, signingSecret)
 
-	s.sendResponse(w, http.StatusCreated, models.APIResponse{Message: "API key created successfully", Data: APIKey})
+	s.sendResponse(w, http.StatusCreated, models.APIResponse{Message: "API key created successfully. Store it now, it will not be shown again", Data: APIKey})
 }
 
 // GetAPIKey retrieves a single APIKey by token
@@ -132,23 +223,14 @@ func (s *APIKeyHandler) GetAPIKeyByToken(w http.ResponseWriter, r *http.Request)
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
 		return
 	}
-	// get user id
-	userID, ok := GetUserID(r)
-	if !ok {
+	// ensure the user is logged in
+	if _, ok := GetUserID(r); !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
-	}
-
-	apiKey, err := s.apiKeyRepo.GetAPIKeyByID(r.Context(), id)
-	if err != nil {
-		log.Printf("failed to retrieve APIKey: %v\n", err)
-		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
 		return
 	}
 
-	// verify that key owner legitimacy
-	if apiKey.UserID != userID {
-		log.Printf("Forbidden api key access: %v\n", err)
-		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: ErrAPIKeyAccessForbidden.Error()})
+	apiKey, ok := s.getManagedAPIKey(w, r, id)
+	if !ok {
 		return
 	}
 
@@ -163,23 +245,21 @@ func (s *APIKeyHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
 		return
 	}
-	// get user id
-	userID, ok := GetUserID(r)
-	if !ok {
+	// ensure the user is logged in
+	if _, ok := GetUserID(r); !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
 	}
 
-	apiKey, err := s.apiKeyRepo.GetAPIKeyByID(r.Context(), id)
-	if err != nil {
-		log.Printf("failed to retrieve APIKey: %v\n", err)
-		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
+	apiKey, ok := s.getManagedAPIKey(w, r, id)
+	if !ok {
 		return
 	}
 
-	// verify that key owner legitimacy
-	if apiKey.UserID != userID {
-		log.Printf("Forbidden api key access: %v\n", err)
-		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: ErrAPIKeyAccessForbidden.Error()})
+	apiKey.Usage, err = s.apiKeyRepo.GetAPIKeyUsage(r.Context(), apiKey.ID, usageReportSince())
+	if err != nil {
+		log.Printf("failed to retrieve API key usage: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve API key"})
 		return
 	}
 
@@ -192,15 +272,15 @@ func (s *APIKeyHandler) GetProjectAPIKeys(w http.ResponseWriter, r *http.Request
 	userID, ok := GetUserID(r)
 	if !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
 	}
-	// get the project id
-	projectID, err := uuid.Parse(mux.Vars(r)["id"])
-	if err != nil {
-		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid project ID"})
+	// get the project and verify that its keys can be managed
+	project, ok := s.getManagedProject(w, r)
+	if !ok {
 		return
 	}
 
-	APIKeys, err := s.apiKeyRepo.GetAPIKeysByProjectID(r.Context(), projectID, userID)
+	APIKeys, err := s.apiKeyRepo.GetAPIKeysByProjectID(r.Context(), project.ID, userID)
 	if err != nil {
 		log.Printf("failed to retrieve API Keys: %v\n", err)
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Failed to retrieve API keys"})
@@ -225,9 +305,205 @@ func (s *APIKeyHandler) GetUserAPIKeys(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	usage, err := s.apiKeyRepo.GetAPIKeyUsageByUserID(r.Context(), userID, usageReportSince())
+	if err != nil {
+		log.Printf("failed to retrieve API key usage: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve API keys"})
+		return
+	}
+	byKey := map[uuid.UUID][]*models.APIKeyUsage{}
+	for _, day := range usage {
+		byKey[day.APIKeyID] = append(byKey[day.APIKeyID], day)
+	}
+	for _, key := range APIKeys {
+		key.Usage = byKey[key.ID]
+	}
+
 	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "API keys retrieved successfully", Data: APIKeys})
 }
 
+// RotateAPIKeyRequest represents the api key rotation payload
+type RotateAPIKeyRequest struct {
+	// optional. Seconds the rotated key keeps working, defaults to the configured grace period
+	GracePeriod *int64 `json:"gracePeriod"`
+	// optional. Defaults to the lifetime of the rotated key from now, up to 1 year
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// RotateAPIKey issues a successor of an APIKey with the same name, project and scopes. The rotated key keeps working for the grace
+// period so that its consumers can switch over, and is revoked automatically afterwards
+func (s *APIKeyHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := GetUserID(r); !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
+		return
+	}
+
+	var req RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+			return
+		}
+	}
+
+	key, ok := s.getManagedAPIKey(w, r, id)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || key.ReplacedBy != nil || key.ExpiresAt.Before(now) {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: repository.ErrAPIKeyRotated.Error()})
+		return
+	}
+
+	grace := s.cfg.APIKeyRotationGrace
+	if req.GracePeriod != nil {
+		if *req.GracePeriod < 0 || *req.GracePeriod > int64(maxAPIKeyRotationGrace/time.Second) {
+			s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{
+				Message: fmt.Sprintf("grace period must be between 0 and %d seconds", int64(maxAPIKeyRotationGrace/time.Second)),
+			})
+			return
+		}
+		grace = time.Duration(*req.GracePeriod) * time.Second
+	}
+	expiresAt := now.Add(min(key.ExpiresAt.Sub(key.CreatedAt), now.AddDate(1, 0, 0).Sub(now)))
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if err := validateAPIKeyExpiry(expiresAt); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	token, err := generateAPIKey()
+	if err != nil {
+		log.Printf("failed to generate API key: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to rotate API key"})
+		return
+	}
+	var signingSecret, sealedSigningSecret string
+	if key.Signed {
+		if signingSecret, sealedSigningSecret, err = newSigningSecret(s.cfg.JwtSecret); err != nil {
+			log.Printf("failed to generate API key signing secret: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to rotate API key"})
+			return
+		}
+	}
+	successor, err := s.apiKeyRepo.RotateAPIKey(r.Context(), key.ID, utils.HashToken(token), apiKeyTokenPrefix(token), expiresAt, now.Add(grace),
+		sealedSigningSecret)
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyRotated) {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to rotate API key: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to rotate API key"})
+		return
+	}
+	revealAPIKey(successor, token, signingSecret)
+
+	s.sendResponse(w, http.StatusCreated, models.APIResponse{Message: "API key rotated successfully. Store it now, it will not be shown again", Data: successor})
+}
+
+// GetAPIKeyRotations retrieves the rotation chain of an APIKey, from the first key to the latest successor
+func (s *APIKeyHandler) GetAPIKeyRotations(w http.ResponseWriter, r *http.Request) {
+	if _, ok := GetUserID(r); !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
+		return
+	}
+
+	if _, ok := s.getManagedAPIKey(w, r, id); !ok {
+		return
+	}
+
+	keys, err := s.apiKeyRepo.GetAPIKeyRotationChain(r.Context(), id)
+	if err != nil {
+		log.Printf("failed to retrieve API key rotations: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve API key rotations"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "API key rotations retrieved successfully", Data: keys})
+}
+
+// UpdateAPIKeyRestrictions replaces the addresses and times an APIKey can be used from. Empty restrictions lift them
+func (s *APIKeyHandler) UpdateAPIKeyRestrictions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
+		return
+	}
+
+	var restrictions models.APIKeyRestrictions
+	if err := json.NewDecoder(r.Body).Decode(&restrictions); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if err := normalizeRestrictions(&restrictions); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	if _, ok := s.getManagedAPIKey(w, r, id); !ok {
+		return
+	}
+
+	key, err := s.apiKeyRepo.UpdateAPIKeyRestrictions(r.Context(), id, userID, restrictions)
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to update API key restrictions: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update API key restrictions"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "API key restrictions updated successfully", Data: key})
+}
+
+// GetAPIKeyDenials retrieves the latest requests made with an APIKey that were denied, along with the reason
+func (s *APIKeyHandler) GetAPIKeyDenials(w http.ResponseWriter, r *http.Request) {
+	if _, ok := GetUserID(r); !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
+		return
+	}
+
+	key, ok := s.getManagedAPIKey(w, r, id)
+	if !ok {
+		return
+	}
+
+	denials, err := s.apiKeyRepo.GetAPIKeyDenials(r.Context(), key.ID, apiKeyDenialsLimit)
+	if err != nil {
+		log.Printf("failed to retrieve API key denials: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve API key denials"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "API key denials retrieved successfully", Data: denials})
+}
+
 // RevokeAPIKey revokes an APIKey
 func (s *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 	// get the APIKey id
@@ -238,15 +514,18 @@ func (s *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
 		return
 	}
-	// get user id
 	userID, ok := GetUserID(r)
 	if !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	if _, ok := s.getManagedAPIKey(w, r, apiKeyID); !ok {
+		return
 	}
 
 	if err := s.apiKeyRepo.RevokeAPIKey(r.Context(), apiKeyID, userID); err != nil {
 		if err == repository.ErrAPIKeyNotFound {
-			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "API key not found"})
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: "API key not found"})
 			return
 		}
 		log.Printf("failed to revoke API Key: %v\n", err)
@@ -267,15 +546,18 @@ func (s *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid APIKey ID"})
 		return
 	}
-	// get user id
 	userID, ok := GetUserID(r)
 	if !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	if _, ok := s.getManagedAPIKey(w, r, apiKeyID); !ok {
+		return
 	}
 
 	if err := s.apiKeyRepo.DeleteAPIKey(r.Context(), apiKeyID, userID); err != nil {
 		if err == repository.ErrAPIKeyNotFound {
-			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "API key not found"})
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: "API key not found"})
 			return
 		}
 		log.Printf("failed to delete API Key: %v\n", err)
@@ -286,6 +568,47 @@ func (s *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "API key deleted successfully"})
 }
 
+// getManagedAPIKey retrieves an api key and verifies that the caller can manage it. Keys the caller cannot manage are reported as not
+// found. A response is sent and false is returned when the key cannot be managed
+func (s *APIKeyHandler) getManagedAPIKey(w http.ResponseWriter, r *http.Request, id uuid.UUID) (*models.APIKey, bool) {
+	key, err := s.apiKeyRepo.GetAPIKeyByID(r.Context(), id)
+	if err != nil && !errors.Is(err, repository.ErrAPIKeyNotFound) {
+		log.Printf("failed to retrieve APIKey: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve API key"})
+		return nil, false
+	}
+	if err != nil || authz.Authorize(requestPrincipal(r), authz.ActionManageAPIKeys, authz.APIKeyResource(key)) != nil {
+		s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: repository.ErrAPIKeyNotFound.Error()})
+		return nil, false
+	}
+	return key, true
+}
+
+// getManagedProject retrieves the project in the path and verifies that the caller can manage its api keys. A response is sent and
+// false is returned when it cannot
+func (s *APIKeyHandler) getManagedProject(w http.ResponseWriter, r *http.Request) (*models.Project, bool) {
+	projectID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid project ID"})
+		return nil, false
+	}
+	project, err := s.projectRepo.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, repository.ErrProjectNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return nil, false
+		}
+		log.Printf("failed to retrieve project: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve project"})
+		return nil, false
+	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionManageAPIKeys, authz.ProjectResource(project)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return nil, false
+	}
+	return project, true
+}
+
 func (s *APIKeyHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -294,26 +617,36 @@ func (s *APIKeyHandler) sendResponse(w http.ResponseWriter, status int, resp mod
 
 // helper methods
 
-func (s *APIKeyHandler) generateAPIKey(prefix, name string, projectID uuid.UUID) (string, error) {
-	// create a random salt of length 32 - len(prefix)
-	salt := make([]byte, 32-len(prefix))
-	if _, err := rand.Read(salt); err != nil {
-		return "", fmt.Errorf("failed to generate random salt: %w", err)
+// generateAPIKey returns a new random api key token
+func generateAPIKey() (string, error) {
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
 	}
+	return apiKeyPrefix + secret, nil
+}
 
-	// combine element with salt
-	raw := append([]byte(projectID.String()), salt...)
-	raw = append(raw, []byte(name)...)
+// revealAPIKey sets the secret of a new key for the response creating it. Keys in signing mode never accept their token, so they are
+// given their signing secret instead and are identified by their token prefix
+func revealAPIKey(key *models.APIKey, token, signingSecret string) {
+	if key.Signed {
+		key.SigningSecret = signingSecret
+		return
+	}
+	key.Token = token
+}
 
-	// create hash
-	hash := sha256.New()
-	hash.Write(raw)
-	hashBytes := hash.Sum(nil)
+// usageReportSince returns the first day, in UTC, of the usage reported along with api keys
+func usageReportSince() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()-usageReportDays+1, 0, 0, 0, 0, time.UTC)
+}
 
-	// create final token in base64 encoding and limit token length to 32
-	token := fmt.Sprintf("%s_%s", prefix, base64.URLEncoding.EncodeToString(hashBytes))
-	if len(token) > 32 {
-		token = token[:32]
+// apiKeyTokenPrefix returns the leading characters of a token identifying its key. Tokens too short to have been issued return an
+// empty prefix
+func apiKeyTokenPrefix(token string) string {
+	if len(token) < apiKeyPrefixLength {
+		return ""
 	}
-	return token, nil
+	return token[:apiKeyPrefixLength]
 }
@@ -1,41 +1,95 @@
 package server
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
 	"sgs/internal/config"
+	"sgs/internal/ldapauth"
+	"sgs/internal/mailer"
 	"sgs/internal/models"
+	"sgs/internal/oidc"
 	"sgs/internal/repository"
+	"sgs/internal/signing"
+	"sgs/internal/usage"
 	"sgs/internal/utils"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // errors
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrInvalidToken       = errors.New("invalid token")
-	ErrExpiredToken       = errors.New("token has expired")
-	ErrUsernameInUse      = errors.New("username already in use")
+	ErrInvalidCredentials    = errors.New("invalid credentials")
+	ErrInvalidToken          = errors.New("invalid token")
+	ErrExpiredToken          = errors.New("token has expired")
+	ErrUsernameInUse         = errors.New("username already in use")
+	ErrEmailInUse            = errors.New("email already in use")
+	ErrInvalidSession        = errors.New("invalid or expired session")
+	ErrAccountDisabled       = errors.New("account has been disabled")
+	ErrPasswordResetRequired = errors.New("a password reset is required. Check your email for the reset link or request a new one")
+)
+
+const (
+	// access tokens are short-lived and renewed with the refresh token of their session
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
 )
 
 // AuthHandler provides authentication functionality
 type AuthHandler struct {
-	cfg        *config.Config
-	userRepo   *repository.UserRepository
-	apiKeyRepo *repository.APIKeyRepository
+	cfg              *config.Config
+	userRepo         *repository.UserRepository
+	apiKeyRepo       *repository.APIKeyRepository
+	sessionRepo      *repository.SessionRepository
+	inviteRepo       *repository.InviteRepository
+	verificationRepo *repository.EmailVerificationRepository
+	mfaRepo          *repository.MFARepository
+	identityRepo     *repository.IdentityRepository
+	oidcRepo         *repository.OIDCRepository
+	mailer           mailer.Mailer
+	// nil when single sign-on is not configured
+	oidcProvider *oidc.Provider
+	// nil when ldap is not configured
+	ldapAuth *ldapauth.Authenticator
+	keyRing  *signing.KeyRing
+
+	loginFailureRepo *repository.LoginFailureRepository
+	tokenRepo        *repository.PersonalAccessTokenRepository
+	fileRepo         *repository.FileRepository
+	usage            *usage.Tracker
 }
 
 // NewAuthHandler creates a new authentication handler
-func NewAuthHandler(cfg *config.Config, userRepo *repository.UserRepository, apiKeyRepo *repository.APIKeyRepository) *AuthHandler {
+func NewAuthHandler(cfg *config.Config, userRepo *repository.UserRepository, apiKeyRepo *repository.APIKeyRepository, sessionRepo *repository.SessionRepository,
+	inviteRepo *repository.InviteRepository, verificationRepo *repository.EmailVerificationRepository, mfaRepo *repository.MFARepository,
+	identityRepo *repository.IdentityRepository, oidcRepo *repository.OIDCRepository, mailer mailer.Mailer, oidcProvider *oidc.Provider,
+	ldapAuth *ldapauth.Authenticator, keyRing *signing.KeyRing, loginFailureRepo *repository.LoginFailureRepository,
+	tokenRepo *repository.PersonalAccessTokenRepository, fileRepo *repository.FileRepository, usage *usage.Tracker) *AuthHandler {
 	return &AuthHandler{
-		cfg:        cfg,
-		userRepo:   userRepo,
-		apiKeyRepo: apiKeyRepo,
+		cfg:              cfg,
+		userRepo:         userRepo,
+		apiKeyRepo:       apiKeyRepo,
+		sessionRepo:      sessionRepo,
+		inviteRepo:       inviteRepo,
+		verificationRepo: verificationRepo,
+		mfaRepo:          mfaRepo,
+		identityRepo:     identityRepo,
+		oidcRepo:         oidcRepo,
+		mailer:           mailer,
+		oidcProvider:     oidcProvider,
+		ldapAuth:         ldapAuth,
+		keyRing:          keyRing,
+		loginFailureRepo: loginFailureRepo,
+		tokenRepo:        tokenRepo,
+		fileRepo:         fileRepo,
+		usage:            usage,
 	}
 }
 
@@ -43,7 +97,10 @@ func NewAuthHandler(cfg *config.Config, userRepo *repository.UserRepository, api
 type RegisterRequest struct {
 	Username string  `json:"username"`
 	Password string  `json:"password"`
+	Email    *string `json:"email,omitempty"`
 	FullName *string `json:"fullName,omitempty"`
+	// required when registration is invite-only
+	InviteCode string `json:"inviteCode,omitempty"`
 }
 
 // validate register request
@@ -51,7 +108,20 @@ func (data *RegisterRequest) validate() error {
 	if data.Username == "" || data.Password == "" {
 		return fmt.Errorf("username and password are required")
 	}
-	if len(data.Password) < 6 {
+	if err := validatePassword(data.Password); err != nil {
+		return err
+	}
+	if data.Email != nil {
+		if _, err := mail.ParseAddress(*data.Email); err != nil {
+			return fmt.Errorf("invalid email address")
+		}
+	}
+	return nil
+}
+
+// validatePassword checks that a new password meets the password requirements
+func validatePassword(password string) error {
+	if len(password) < 6 {
 		return fmt.Errorf("password should be at least 6 characters")
 	}
 	return nil
@@ -71,6 +141,12 @@ func (s *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check that the registration mode allows the user
+	if err := s.checkRegistration(&req); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
+
 	// check if user already exists
 	_, err := s.userRepo.GetUserByUsername(r.Context(), req.Username)
 	if err == nil {
@@ -82,20 +158,65 @@ func (s *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Email != nil {
+		_, err := s.userRepo.GetUserByEmail(r.Context(), *req.Email)
+		if err == nil {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrEmailInUse.Error()})
+			return
+		}
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: err.Error()})
+			return
+		}
+	}
+
 	// hash the password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "failed to register user"})
 		return
 	}
-	// create the user
-	user, err := s.userRepo.CreateUser(r.Context(), req.Username, hashedPassword, req.FullName)
+	// create the user, using up the invite in the same transaction
+	tx, err := s.userRepo.GetTx(r.Context())
+	if err != nil {
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "failed to register user"})
+		return
+	}
+	defer tx.Rollback()
+
+	if s.cfg.RegistrationMode == config.RegistrationInvite {
+		email := ""
+		if req.Email != nil {
+			email = *req.Email
+		}
+		if err := s.inviteRepo.UseInvite(r.Context(), tx, utils.HashToken(req.InviteCode), email); err != nil {
+			if errors.Is(err, repository.ErrInvalidInvite) {
+				s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+				return
+			}
+			log.Printf("failed to use invite: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "failed to register user"})
+			return
+		}
+	}
+
+	user, err := s.userRepo.CreateUser(r.Context(), tx, req.Username, hashedPassword, req.Email, req.FullName)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: err.Error()})
 		return
 	}
 
-	s.sendResponse(w, 201, models.APIResponse{Message: "User registered successfully", Data: user})
+	if !s.cfg.EmailVerification {
+		s.sendResponse(w, 201, models.APIResponse{Message: "User registered successfully", Data: user})
+		return
+	}
+	if err := s.startEmailVerification(r.Context(), user); err != nil {
+		log.Printf("failed to start email verification: %v\n", err)
+	}
+	s.sendResponse(w, 201, models.APIResponse{Message: "User registered successfully. Check your email to verify your account before logging in", Data: user})
 }
 
 // LoginRequest represents the login payload
@@ -104,10 +225,18 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// TokenPair represents the tokens of a session. The access token authenticates requests until it expires, after which the refresh
+// token is exchanged for a new pair
+type TokenPair struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
 // LoginResponse represents the login response payload
 type LoginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	TokenPair
+	User models.User `json:"user"`
 }
 
 // validate register request
@@ -121,7 +250,9 @@ func (data *LoginRequest) validate() error {
 	return nil
 }
 
-// Login authenticates a user and returns an access token
+// Login authenticates a user with their password and starts a new session. When ldap is configured the directory is checked first and
+// users it does not know fall back to their local account. Failed logins delay and eventually lock further logins of the account and
+// the client
 func (s *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// parse the request body
 	var req LoginRequest
@@ -135,72 +266,249 @@ func (s *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// refuse logins of locked accounts and clients before checking the password
+	if !s.checkLoginLock(w, r, req.Username) {
+		return
+	}
+
+	if s.ldapAuth != nil {
+		user, err := s.ldapLogin(r.Context(), req.Username, req.Password)
+		if err == nil {
+			s.clearLoginFailures(r.Context(), req.Username)
+			s.completeLogin(w, r, user)
+			return
+		}
+		if !errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			log.Printf("failed to authenticate with ldap: %v\n", err)
+		}
+	}
+
 	user, err := s.userRepo.GetUserByUsername(r.Context(), req.Username)
 	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			log.Printf("failed to retrieve user: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
+			return
+		}
+		// spend as long as a wrong password would so that the response time does not reveal whether the user exists
+		utils.VerifyDummyPassword(req.Password)
+		s.recordLoginFailure(r.Context(), req.Username, clientIP(r, s.cfg.TrustedProxies))
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: ErrInvalidCredentials.Error()})
 		return
 	}
 	// verify the password
 	if err := utils.VerifyPassword(user.Password, req.Password); err != nil {
+		s.recordLoginFailure(r.Context(), req.Username, clientIP(r, s.cfg.TrustedProxies))
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: ErrInvalidCredentials.Error()})
 		return
 	}
+	s.clearLoginFailures(r.Context(), req.Username)
+	if user.PasswordResetRequired {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrPasswordResetRequired.Error()})
+		return
+	}
+	if s.cfg.EmailVerification && !user.EmailVerified {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrEmailNotVerified.Error()})
+		return
+	}
+
+	s.completeLogin(w, r, user)
+}
 
-	// Generate an access token valid for 1 day
-	token, err := s.generateAccessToken(user, 24*time.Hour)
+// completeLogin starts a new session for a user that passed the first login step. Users with two-factor authentication, or every user
+// when the server requires it, get a challenge to complete with a second factor instead
+func (s *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if user.DisabledAt != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrAccountDisabled.Error()})
+		return
+	}
+	if user.TOTPEnabled || s.cfg.MFARequired {
+		challenge, err := s.startMFAChallenge(r.Context(), user)
+		if err != nil {
+			log.Printf("failed to create two-factor challenge: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
+			return
+		}
+		s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Two-factor authentication required", Data: challenge})
+		return
+	}
+
+	// start a new session family
+	tokens, err := s.issueTokens(r, nil, user, nil)
 	if err != nil {
-		log.Printf("failed to generate access token: %v\n", err)
+		log.Printf("failed to create session: %v\n", err)
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
 		return
 	}
 
-	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Login successful", Data: LoginResponse{Token: token, User: *user}})
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Login successful", Data: LoginResponse{TokenPair: *tokens, User: *user}})
+}
+
+// RefreshRequest represents the payload for refreshing or ending a session
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
-// generateAccessToken creates a new JWT access token
-func (s *AuthHandler) generateAccessToken(user *models.User, ttl time.Duration) (string, error) {
-	// set expiration time and claims with timestamps in unix format
-	expiresAt := time.Now().Add(ttl)
+// Refresh exchanges a refresh token for a new token pair. Every refresh token can only be used once. Presenting a token that was
+// already exchanged means that it leaked, so the whole session family is revoked
+func (s *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	tx, err := s.sessionRepo.GetTx(r.Context())
+	if err != nil {
+		log.Printf("failed to start transaction: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to refresh session"})
+		return
+	}
+	defer tx.Rollback()
+
+	session, err := s.sessionRepo.GetSessionByTokenHashForUpdate(r.Context(), tx, utils.HashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: ErrInvalidSession.Error()})
+			return
+		}
+		log.Printf("failed to retrieve session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to refresh session"})
+		return
+	}
+
+	if session.ReplacedBy != nil && session.RevokedAt == nil {
+		tx.Rollback()
+		log.Printf("refresh token reuse detected for user %s. Revoking session family %s\n", session.UserID, session.FamilyID)
+		if err := s.sessionRepo.RevokeSessionFamily(r.Context(), session.FamilyID); err != nil {
+			log.Printf("failed to revoke session family: %v\n", err)
+		}
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: ErrInvalidSession.Error()})
+		return
+	}
+	if session.ReplacedBy != nil || session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: ErrInvalidSession.Error()})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), session.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: ErrInvalidSession.Error()})
+			return
+		}
+		log.Printf("failed to retrieve session user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to refresh session"})
+		return
+	}
+	// sessions started before two-factor authentication was required end so that the user enrolls on their next login
+	if user.DisabledAt != nil || (s.cfg.MFARequired && !user.TOTPEnabled) {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: ErrInvalidSession.Error()})
+		return
+	}
+
+	// rotate the refresh token within the family
+	tokens, err := s.issueTokens(r, tx, user, session)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("failed to rotate session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to refresh session"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Session refreshed successfully", Data: tokens})
+}
+
+// Logout ends the session of a refresh token. Access tokens already issued for the session stay valid until they expire
+func (s *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	session, err := s.sessionRepo.GetSessionByTokenHash(r.Context(), utils.HashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: ErrInvalidSession.Error()})
+			return
+		}
+		log.Printf("failed to retrieve session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to logout"})
+		return
+	}
+
+	if err := s.sessionRepo.RevokeSessionFamily(r.Context(), session.FamilyID); err != nil {
+		log.Printf("failed to revoke session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to logout"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Logout successful"})
+}
+
+// issueTokens stores a new refresh token for the device making the request and creates an access token bound to its session family.
+// Without a previous session a new family is started. Otherwise the previous session is replaced within the transaction, which is
+// required in that case
+func (s *AuthHandler) issueTokens(r *http.Request, tx *sql.Tx, user *models.User, previous *models.Session) (*TokenPair, error) {
+	ctx := r.Context()
+	refreshToken, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	familyID, authenticatedAt := uuid.New(), time.Now()
+	if previous != nil {
+		familyID, authenticatedAt = previous.FamilyID, previous.AuthenticatedAt
+	}
+	session, err := s.sessionRepo.CreateSession(ctx, tx, user.ID, familyID, utils.HashToken(refreshToken), authenticatedAt, time.Now().Add(refreshTokenTTL),
+		r.UserAgent(), clientIP(r, s.cfg.TrustedProxies))
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if err := s.sessionRepo.ReplaceSession(ctx, tx, previous.ID, session.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	expiresAt := time.Now().Add(accessTokenTTL)
+	token, err := s.generateAccessToken(ctx, user, familyID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{Token: token, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
+
+// generateAccessToken creates a new JWT access token bound to a session family. It is signed with the active access key, whose public
+// part is published at /.well-known/jwks.json
+func (s *AuthHandler) generateAccessToken(ctx context.Context, user *models.User, sessionID uuid.UUID, expiresAt time.Time) (string, error) {
+	// set claims with timestamps in unix format
 	claims := jwt.MapClaims{
+		"iss":      s.cfg.BaseURL.String(),
 		"sub":      user.ID.String(),
 		"username": user.Username,
+		"sid":      sessionID.String(),
 		"exp":      expiresAt.Unix(),
 		"iat":      time.Now().Unix(),
 	}
 
-	// generate token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// sign token with secret key
-	tokenString, err := token.SignedString([]byte(s.cfg.JwtSecret))
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
+	return s.keyRing.Sign(ctx, signing.PurposeAccess, claims)
 }
 
-// ValidateToken verifies a JWT token and returns the claims
-func (s *AuthHandler) ValidateToken(tokenString string) (jwt.MapClaims, error) {
+// ValidateToken verifies a JWT access token and returns the claims
+func (s *AuthHandler) ValidateToken(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
 	// parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidToken
-		}
-		return []byte(s.cfg.JwtSecret), nil
-	})
+	claims, err := s.keyRing.Parse(ctx, signing.PurposeAccess, tokenString, jwt.WithIssuer(s.cfg.BaseURL.String()))
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
 		}
 		return nil, err
 	}
-
-	// extract and validate claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims, nil
-	}
-	return nil, ErrInvalidToken
+	return claims, nil
 }
 
 func (s *AuthHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
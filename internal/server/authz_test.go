@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sgs/internal/config"
+	"sgs/internal/database"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errReached is returned by the tenant store for the operations a denied request must not reach
+var errReached = errors.New("reached")
+
+// tenantStore serves the project, file, api key and job of a single tenant. Looking them up by id is allowed, since handlers do it to
+// authorize requests, but every other operation on them is recorded as reached and fails
+type tenantStore struct {
+	db      *sql.DB
+	project *models.Project
+	file    *models.File
+	apiKey  *models.APIKey
+	job     *models.Job
+
+	reached []string
+}
+
+func (s *tenantStore) reach(operation string) error {
+	s.reached = append(s.reached, operation)
+	return errReached
+}
+
+func (s *tenantStore) GetTx(ctx context.Context) (*sql.Tx, error) { return s.db.BeginTx(ctx, nil) }
+
+// projects
+
+func (s *tenantStore) CreateProject(_ context.Context, _ *sql.Tx, _ uuid.UUID, bucket string) (*models.Project, error) {
+	// buckets are unique, so the bucket of the tenant cannot back another project
+	if bucket == s.project.Bucket {
+		return nil, fmt.Errorf("duplicate key value violates unique constraint \"projects_bucket_key\"")
+	}
+	return nil, s.reach("CreateProject")
+}
+func (s *tenantStore) GetProjectByID(_ context.Context, id uuid.UUID) (*models.Project, error) {
+	if id != s.project.ID {
+		return nil, repository.ErrProjectNotFound
+	}
+	return s.project, nil
+}
+func (s *tenantStore) GetProjectByIDTx(ctx context.Context, _ *sql.Tx, id uuid.UUID) (*models.Project, error) {
+	return s.GetProjectByID(ctx, id)
+}
+func (s *tenantStore) GetProjectsByOwnerID(_ context.Context, ownerID uuid.UUID) ([]*models.Project, error) {
+	if ownerID == s.project.OwnerID {
+		return nil, s.reach("GetProjectsByOwnerID")
+	}
+	return nil, nil
+}
+func (s *tenantStore) DeleteProjectByID(context.Context, uuid.UUID) error {
+	return s.reach("DeleteProjectByID")
+}
+func (s *tenantStore) CreateBucket(context.Context, string, bool) error {
+	return s.reach("CreateBucket")
+}
+func (s *tenantStore) RemoveBucket(context.Context, string) error { return s.reach("RemoveBucket") }
+
+// files
+
+func (s *tenantStore) CreateFile(context.Context, *sql.Tx, string, string, uuid.UUID, int64, string, uuid.UUID) (*models.File, error) {
+	return nil, s.reach("CreateFile")
+}
+func (s *tenantStore) GetFileByID(_ context.Context, id uuid.UUID) (*models.File, error) {
+	if id != s.file.ID {
+		return nil, repository.ErrFileNotFound
+	}
+	return s.file, nil
+}
+func (s *tenantStore) GetFileByIDTx(ctx context.Context, _ *sql.Tx, id uuid.UUID) (*models.File, error) {
+	return s.GetFileByID(ctx, id)
+}
+func (s *tenantStore) GetFiles(_ context.Context, projectID *uuid.UUID) ([]*models.File, error) {
+	if projectID == nil || *projectID == s.project.ID {
+		return nil, s.reach("GetFiles")
+	}
+	return nil, nil
+}
+func (s *tenantStore) GetFilesByOwnerID(_ context.Context, ownerID uuid.UUID) ([]*models.File, error) {
+	if ownerID == s.project.OwnerID {
+		return nil, s.reach("GetFilesByOwnerID")
+	}
+	return nil, nil
+}
+func (s *tenantStore) DeleteFileByID(context.Context, *sql.Tx, uuid.UUID) error {
+	return s.reach("DeleteFileByID")
+}
+func (s *tenantStore) CreateObject(context.Context, string, string, string, int64, io.Reader) (models.Object, error) {
+	return models.Object{}, s.reach("CreateObject")
+}
+func (s *tenantStore) GetObject(context.Context, string, string, io.Writer) error {
+	return s.reach("GetObject")
+}
+func (s *tenantStore) RemoveObject(context.Context, string, string) error {
+	return s.reach("RemoveObject")
+}
+func (s *tenantStore) GetPreferences(context.Context, uuid.UUID) (*models.UserPreferences, error) {
+	return nil, s.reach("GetPreferences")
+}
+
+// api keys
+
+func (s *tenantStore) CreateAPIKey(context.Context, string, string, string, uuid.UUID, uuid.UUID, time.Time, []string,
+	models.APIKeyRestrictions, string) (*models.APIKey, error) {
+	return nil, s.reach("CreateAPIKey")
+}
+func (s *tenantStore) GetAPIKeyByID(_ context.Context, id uuid.UUID) (*models.APIKey, error) {
+	if id != s.apiKey.ID {
+		return nil, repository.ErrAPIKeyNotFound
+	}
+	return s.apiKey, nil
+}
+func (s *tenantStore) GetAPIKeysByProjectID(_ context.Context, projectID, userID uuid.UUID) ([]*models.APIKey, error) {
+	if projectID == s.project.ID || userID == s.project.OwnerID {
+		return nil, s.reach("GetAPIKeysByProjectID")
+	}
+	return nil, nil
+}
+func (s *tenantStore) GetAPIKeysByUserID(_ context.Context, userID uuid.UUID) ([]*models.APIKey, error) {
+	if userID == s.project.OwnerID {
+		return nil, s.reach("GetAPIKeysByUserID")
+	}
+	return nil, nil
+}
+func (s *tenantStore) GetAPIKeyUsage(context.Context, uuid.UUID, time.Time) ([]*models.APIKeyUsage, error) {
+	return nil, s.reach("GetAPIKeyUsage")
+}
+func (s *tenantStore) GetAPIKeyUsageByUserID(_ context.Context, userID uuid.UUID, _ time.Time) ([]*models.APIKeyUsage, error) {
+	if userID == s.project.OwnerID {
+		return nil, s.reach("GetAPIKeyUsageByUserID")
+	}
+	return nil, nil
+}
+func (s *tenantStore) GetAPIKeyRotationChain(context.Context, uuid.UUID) ([]*models.APIKey, error) {
+	return nil, s.reach("GetAPIKeyRotationChain")
+}
+func (s *tenantStore) GetAPIKeyDenials(context.Context, uuid.UUID, int) ([]*models.APIKeyDenial, error) {
+	return nil, s.reach("GetAPIKeyDenials")
+}
+func (s *tenantStore) RotateAPIKey(context.Context, uuid.UUID, string, string, time.Time, time.Time, string) (*models.APIKey, error) {
+	return nil, s.reach("RotateAPIKey")
+}
+func (s *tenantStore) UpdateAPIKeyRestrictions(context.Context, uuid.UUID, uuid.UUID, models.APIKeyRestrictions) (*models.APIKey, error) {
+	return nil, s.reach("UpdateAPIKeyRestrictions")
+}
+func (s *tenantStore) RevokeAPIKey(context.Context, uuid.UUID, uuid.UUID) error {
+	return s.reach("RevokeAPIKey")
+}
+func (s *tenantStore) DeleteAPIKey(context.Context, uuid.UUID, uuid.UUID) error {
+	return s.reach("DeleteAPIKey")
+}
+
+// jobs
+
+func (s *tenantStore) GetJobByID(_ context.Context, id uuid.UUID) (*models.Job, error) {
+	if id != s.job.ID {
+		return nil, repository.ErrJobNotFound
+	}
+	return s.job, nil
+}
+func (s *tenantStore) GetJobs(_ context.Context, filter models.JobFilter, _ int) ([]*models.Job, error) {
+	if filter.UserID == nil || *filter.UserID == *s.job.UserID {
+		return nil, s.reach("GetJobs")
+	}
+	return nil, nil
+}
+func (s *tenantStore) RequeueDeadJob(context.Context, uuid.UUID) (*models.Job, error) {
+	return nil, s.reach("RequeueDeadJob")
+}
+
+// uploadBody returns a multipart form uploading a file, and its content type
+func uploadBody() (string, string) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "report.pdf")
+	part.Write([]byte("%PDF-1.4"))
+	form.Close()
+	return body.String(), form.FormDataContentType()
+}
+
+func TestHandlersDenyCrossTenantAccess(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	bobProject := &models.Project{ID: uuid.New(), OwnerID: bob, Bucket: "bob"}
+	bucket := bobProject.Bucket
+	tenant := &tenantStore{
+		db:      sql.OpenDB(&txDB{}),
+		project: bobProject,
+		file: &models.File{
+			ID: uuid.New(), Filename: "report.pdf", ObjectName: "report.pdf", ProjectID: bobProject.ID, UploadedBy: bob, Bucket: &bucket,
+			ProjectOwnerID: bob,
+		},
+		apiKey: &models.APIKey{
+			ID: uuid.New(), Name: "bob", ProjectID: bobProject.ID, UserID: bob, ExpiresAt: time.Now().Add(time.Hour), Scopes: models.APIKeyScopes,
+		},
+		job: &models.Job{ID: uuid.New(), Type: "account.export", Status: models.JobSucceeded, UserID: &bob},
+	}
+	// every id of the tenant, which must not leak in responses
+	secrets := []string{tenant.project.ID.String(), tenant.file.ID.String(), tenant.apiKey.ID.String(), tenant.job.ID.String()}
+
+	cfg := &config.Config{ExportBucket: "sgs-exports", APIKeyRotationGrace: time.Hour}
+	projects := &ProjectHandler{cfg: cfg, projectRepo: tenant, store: tenant}
+	files := &FileHandler{cfg: cfg, fileRepo: tenant, projectRepo: tenant, userRepo: tenant, store: tenant}
+	apiKeys := &APIKeyHandler{cfg: cfg, apiKeyRepo: tenant, projectRepo: tenant}
+	jobs := &JobHandler{jobRepo: tenant}
+
+	upload, uploadType := uploadBody()
+	expiresAt := fmt.Sprintf(`{"expiresAt": %q, "name": "alice"}`, time.Now().Add(2*time.Hour).UTC().Format(time.RFC3339))
+
+	tests := []struct {
+		route   string
+		handler http.HandlerFunc
+		id      uuid.UUID
+		body    string
+		// status of the request, which lists nothing of the tenant when it is ok
+		want []int
+	}{
+		{"POST /api/projects", projects.CreateProject, uuid.Nil, `{"bucket": "bob"}`, []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict}},
+		{"GET /api/projects", projects.GetUserProjects, uuid.Nil, "", []int{http.StatusOK}},
+		{"GET /api/projects/{id}", projects.GetProject, tenant.project.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"DELETE /api/projects/{id}", projects.DeleteProject, tenant.project.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"POST /api/projects/{id}/files", files.UploadFile, tenant.project.ID, upload, []int{http.StatusForbidden, http.StatusNotFound}},
+		{"GET /api/projects/{id}/files/meta", files.GetProjectFilesMeta, tenant.project.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"POST /api/projects/{id}/api-keys", apiKeys.CreateAPIKey, tenant.project.ID, expiresAt, []int{http.StatusForbidden, http.StatusNotFound}},
+		{"GET /api/projects/{id}/api-keys", apiKeys.GetProjectAPIKeys, tenant.project.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"GET /api/files/me", files.GetUserFilesMeta, uuid.Nil, "", []int{http.StatusOK}},
+		{"GET /api/files/{id}", files.GetFileMeta, tenant.file.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"DELETE /api/files/{id}", files.DeleteFile, tenant.file.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"GET /api/files/{id}/download", files.DownloadFileHandler, tenant.file.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"POST /api/files/{id}/share", files.GenerateSignedURLHandler, tenant.file.ID, "{}", []int{http.StatusForbidden, http.StatusNotFound}},
+		{"GET /api/api-keys/me", apiKeys.GetUserAPIKeys, uuid.Nil, "", []int{http.StatusOK}},
+		{"GET /api/api-keys/{id}", apiKeys.GetAPIKey, tenant.apiKey.ID, "", []int{http.StatusNotFound}},
+		{"DELETE /api/api-keys/{id}", apiKeys.DeleteAPIKey, tenant.apiKey.ID, "", []int{http.StatusNotFound}},
+		{"PATCH /api/api-keys/{id}/revoke", apiKeys.RevokeAPIKey, tenant.apiKey.ID, "", []int{http.StatusNotFound}},
+		{"POST /api/api-keys/{id}/rotate", apiKeys.RotateAPIKey, tenant.apiKey.ID, "", []int{http.StatusNotFound}},
+		{"GET /api/api-keys/{id}/rotations", apiKeys.GetAPIKeyRotations, tenant.apiKey.ID, "", []int{http.StatusNotFound}},
+		{"PUT /api/api-keys/{id}/restrictions", apiKeys.UpdateAPIKeyRestrictions, tenant.apiKey.ID, "{}", []int{http.StatusNotFound}},
+		{"GET /api/api-keys/{id}/denials", apiKeys.GetAPIKeyDenials, tenant.apiKey.ID, "", []int{http.StatusNotFound}},
+		{"GET /api/jobs", jobs.GetUserJobs, uuid.Nil, "", []int{http.StatusOK}},
+		{"GET /api/jobs/{id}", jobs.GetUserJob, tenant.job.ID, "", []int{http.StatusForbidden, http.StatusNotFound}},
+	}
+
+	principals := map[string]context.Context{
+		"user": context.WithValue(context.Background(), UserIDKey, alice),
+		"api key": context.WithValue(context.WithValue(context.Background(), UserIDKey, alice), APIKeyKey, &models.APIKey{
+			ID: uuid.New(), ProjectID: uuid.New(), UserID: alice, Scopes: models.APIKeyScopes,
+		}),
+	}
+	for _, tt := range tests {
+		method, path, _ := strings.Cut(tt.route, " ")
+		for name, ctx := range principals {
+			r := httptest.NewRequest(method, strings.Replace(path, "{id}", tt.id.String(), 1), strings.NewReader(tt.body)).WithContext(ctx)
+			r.Header.Set("Content-Type", "application/json")
+			if tt.body == upload {
+				r.Header.Set("Content-Type", uploadType)
+			}
+			if tt.id != uuid.Nil {
+				r = mux.SetURLVars(r, map[string]string{"id": tt.id.String()})
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if !slices.Contains(tt.want, w.Code) {
+				t.Errorf("%s by the %s of another tenant: status %d; want one of %v: %s", tt.route, name, w.Code, tt.want, w.Body)
+			}
+			for _, secret := range secrets {
+				if strings.Contains(w.Body.String(), secret) {
+					t.Errorf("%s by the %s of another tenant returned %s: %s", tt.route, name, secret, w.Body)
+				}
+			}
+			for _, operation := range tenant.reached {
+				t.Errorf("%s by the %s of another tenant reached %s", tt.route, name, operation)
+			}
+			tenant.reached = nil
+		}
+	}
+
+	// routes added later must be added to the tests
+	tested := map[string]bool{}
+	for _, tt := range tests {
+		tested[tt.route] = true
+	}
+	s := &Server{cfg: cfg, db: &database.DB{}}
+	err := s.router().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil || template == "/api/files/download-signed" {
+			return nil
+		}
+		if !strings.HasPrefix(template, "/api/projects") && !strings.HasPrefix(template, "/api/files") &&
+			!strings.HasPrefix(template, "/api/api-keys") && !strings.HasPrefix(template, "/api/jobs") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if !tested[method+" "+template] {
+				t.Errorf("route %s %s is not tested for cross-tenant access", method, template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
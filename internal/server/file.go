@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"io"
 	"mime/multipart"
 	"strconv"
 	"time"
@@ -10,9 +13,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sgs/internal/authz"
 	"sgs/internal/config"
 	"sgs/internal/models"
 	"sgs/internal/repository"
+	"sgs/internal/signing"
 	"sgs/internal/store"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -25,21 +30,50 @@ var (
 	ErrFileOwnership = errors.New("not authorized owner of this file")
 )
 
+// fileStore is the subset of the file repository used by the handlers
+type fileStore interface {
+	CreateFile(ctx context.Context, tx *sql.Tx, filename string, objectName string, projectID uuid.UUID, size int64, contentType string,
+		uploadedBy uuid.UUID) (*models.File, error)
+	GetFileByID(ctx context.Context, id uuid.UUID) (*models.File, error)
+	GetFileByIDTx(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*models.File, error)
+	GetFiles(ctx context.Context, projectID *uuid.UUID) ([]*models.File, error)
+	GetFilesByOwnerID(ctx context.Context, ownerID uuid.UUID) ([]*models.File, error)
+	DeleteFileByID(ctx context.Context, tx *sql.Tx, id uuid.UUID) error
+	GetTx(ctx context.Context) (*sql.Tx, error)
+}
+
+// objectStore creates, reads and removes the objects of files in the object store
+type objectStore interface {
+	CreateObject(ctx context.Context, bucketName, objectName, contentType string, size int64, fileReader io.Reader) (models.Object, error)
+	GetObject(ctx context.Context, bucketName, objectName string, writer io.Writer) error
+	RemoveObject(ctx context.Context, bucketName, objectName string) error
+}
+
+// preferencesStore reads the preferences of users
+type preferencesStore interface {
+	GetPreferences(ctx context.Context, id uuid.UUID) (*models.UserPreferences, error)
+}
+
 // FileHandler provides functionality for managing a File
 type FileHandler struct {
 	cfg         *config.Config
-	fileRepo    *repository.FileRepository
-	projectRepo *repository.ProjectRepository
-	store       *store.Store
+	fileRepo    fileStore
+	projectRepo projectStore
+	userRepo    preferencesStore
+	store       objectStore
+	keyRing     *signing.KeyRing
 }
 
 // NewFileHandler creates a new File handler
-func NewFileHandler(cfg *config.Config, fileRepo *repository.FileRepository, projectRepo *repository.ProjectRepository, store *store.Store) *FileHandler {
+func NewFileHandler(cfg *config.Config, fileRepo *repository.FileRepository, projectRepo *repository.ProjectRepository,
+	userRepo *repository.UserRepository, store *store.Store, keyRing *signing.KeyRing) *FileHandler {
 	return &FileHandler{
 		cfg:         cfg,
 		fileRepo:    fileRepo,
 		projectRepo: projectRepo,
+		userRepo:    userRepo,
 		store:       store,
+		keyRing:     keyRing,
 	}
 }
 
@@ -49,6 +83,7 @@ func (s *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := GetUserID(r)
 	if !ok {
 		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
 	}
 
 	// get project id
@@ -66,6 +101,16 @@ func (s *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer file.Close()
+	// read what follows the file so that a signed body too large to be verified by the middleware is verified before it is stored
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		if errors.Is(err, errSignedBodyMismatch) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to read request body: %v\n", err)
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Failed to upload file"})
+		return
+	}
 
 	// get file content type
 	contentType, err := s.detectContentType(file)
@@ -96,6 +141,10 @@ func (s *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to upload file"})
 		return
 	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionUploadFile, authz.ProjectResource(project)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
 
 	// generate filename
 	objectName := s.generateObjectName(project.Bucket, header.Filename)
@@ -141,12 +190,6 @@ func (s *FileHandler) DownloadFileHandler(w http.ResponseWriter, r *http.Request
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid file ID"})
 		return
 	}
-	// get logged-in user
-	userID, ok := GetUserID(r)
-	if !ok {
-		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
-	}
-
 	// retrieve file metadata from store and verify ownership
 	fileMeta, err := s.fileRepo.GetFileByID(r.Context(), fileID)
 	if err != nil {
@@ -158,9 +201,8 @@ func (s *FileHandler) DownloadFileHandler(w http.ResponseWriter, r *http.Request
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Failed to download file"})
 		return
 	}
-	if fileMeta.UploadedBy != userID {
-		log.Printf("Denied unauthorized user from accessing private file: %v\n", err)
-		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Failed to download file"})
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionReadFile, authz.FileResource(fileMeta)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
 		return
 	}
 
@@ -231,18 +273,6 @@ func (s *FileHandler) GenerateSignedURLHandler(w http.ResponseWriter, r *http.Re
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid file ID"})
 		return
 	}
-	// parse the request body
-	var req GenerateSignedURLRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Invalid request payload: %v\n", err)
-		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
-		return
-	}
-	// Validate input
-	if err := req.validate(); err != nil {
-		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
-		return
-	}
 
 	// retrieve the associated file
 	file, err := s.fileRepo.GetFileByID(r.Context(), fileID)
@@ -255,9 +285,39 @@ func (s *FileHandler) GenerateSignedURLHandler(w http.ResponseWriter, r *http.Re
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Failed to generate signed url"})
 		return
 	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionShareFile, authz.FileResource(file)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	// parse the request body
+	var req GenerateSignedURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid request payload: %v\n", err)
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	// links without an expiry use the default expiry of the user when they set one
+	if req.ExpiresAt.IsZero() {
+		userID, _ := GetUserID(r)
+		preferences, err := s.userRepo.GetPreferences(r.Context(), userID)
+		if err != nil {
+			log.Printf("failed to retrieve preferences: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to generate signed url"})
+			return
+		}
+		if preferences.DefaultShareExpiry != nil {
+			req.ExpiresAt = time.Now().Add(time.Duration(*preferences.DefaultShareExpiry) * time.Second)
+		}
+	}
+	// Validate input
+	if err := req.validate(); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
 
 	// generate token
-	token, err := s.generateSignedURL(file, req.ExpiresAt)
+	token, err := s.generateSignedURL(r.Context(), file, req.ExpiresAt)
 	if err != nil {
 		log.Printf("failed to generate signed url: %v\n", err)
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to generate signed url"})
@@ -281,9 +341,9 @@ func (s *FileHandler) DownloadSignedFileHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	content, err := s.validateSignedURL(token)
+	content, err := s.validateSignedURL(r.Context(), token)
 	if err != nil {
-		if err == jwt.ErrTokenExpired {
+		if errors.Is(err, ErrExpiredToken) {
 			s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "File download link is no longer valid. Generate a new one"})
 			return
 		}
@@ -343,6 +403,10 @@ func (s *FileHandler) GetFileMeta(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Failed to retrieve file"})
 		return
 	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionReadFile, authz.FileResource(file)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
 
 	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "File retrieved successfully", Data: file})
 }
@@ -358,6 +422,22 @@ func (s *FileHandler) GetProjectFilesMeta(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// verify that the project exists and can be listed
+	project, err := s.projectRepo.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, repository.ErrProjectNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve project: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve files"})
+		return
+	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionListFiles, authz.ProjectResource(project)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
+
 	//  get files metadata in db
 	files, err := s.fileRepo.GetFiles(r.Context(), &projectID)
 	if err != nil {
@@ -404,6 +484,7 @@ func (s *FileHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("failed to start db transaction: %v\n", err)
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "failed to delete file"})
+		return
 	}
 	// rollback if transaction is not committed
 	defer tx.Rollback()
@@ -417,6 +498,10 @@ func (s *FileHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
 		return
 	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionDeleteFile, authz.FileResource(file)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
 
 	//  delete file without committing
 	if err := s.fileRepo.DeleteFileByID(r.Context(), tx, fileID); err != nil {
@@ -477,8 +562,9 @@ func (s *FileHandler) sendResponse(w http.ResponseWriter, status int, resp model
 
 // signed url helpers
 
-// generateSignedURL creates a new signed url for a given file
-func (s *FileHandler) generateSignedURL(file *models.File, expiresAt time.Time) (string, error) {
+// generateSignedURL creates a new signed url for a given file. The token is signed with the share key, which cannot sign or verify
+// access tokens
+func (s *FileHandler) generateSignedURL(ctx context.Context, file *models.File, expiresAt time.Time) (string, error) {
 	// set expiration time and claims with timestamps in unix format
 	claims := jwt.MapClaims{
 		"sub":    file.ID.String(),
@@ -487,28 +573,14 @@ func (s *FileHandler) generateSignedURL(file *models.File, expiresAt time.Time)
 		"iat":    time.Now().Unix(),
 	}
 
-	// generate token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// sign token with secret key
-	tokenString, err := token.SignedString([]byte(s.cfg.JwtSecret))
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
+	return s.keyRing.Sign(ctx, signing.PurposeShare, claims)
 }
 
 // validateSignedURL verifies a signedURL token and returns the contents of the signed url. [ErrExpiredToken] is returned if the token
 // has expired or [ErrInvalidToken] if the token validation failed
-func (s *FileHandler) validateSignedURL(tokenString string) (*models.SignedURLContent, error) {
+func (s *FileHandler) validateSignedURL(ctx context.Context, tokenString string) (*models.SignedURLContent, error) {
 	// parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidToken
-		}
-		return []byte(s.cfg.JwtSecret), nil
-	})
+	claims, err := s.keyRing.Parse(ctx, signing.PurposeShare, tokenString)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
@@ -516,12 +588,6 @@ func (s *FileHandler) validateSignedURL(tokenString string) (*models.SignedURLCo
 		return nil, err
 	}
 
-	// extract and validate claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, ErrInvalidToken
-	}
-
 	content := &models.SignedURLContent{}
 	fileID, ok := claims["sub"].(string)
 	if !ok {
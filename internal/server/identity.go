@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+)
+
+// maximum length of usernames
+const maxUsernameLength = 100
+
+// externalIdentity represents a user authenticated by an external identity provider
+type externalIdentity struct {
+	// name of the provider, such as oidc
+	Provider string
+	// stable ID of the user at the provider
+	Subject       string
+	Username      string
+	Email         *string
+	EmailVerified bool
+	FullName      *string
+	// role granted by the provider. Empty to keep the current role of the user
+	Role string
+}
+
+// resolveRole returns the role mapped to the groups of a user. Users in any group mapped to admin are admins. When a mapping is
+// configured every other user gets the user role, otherwise roles are left unchanged
+func resolveRole(groups []string, mapping map[string]string) string {
+	if len(mapping) == 0 {
+		return ""
+	}
+	for _, group := range groups {
+		if mapping[group] == models.RoleAdmin {
+			return models.RoleAdmin
+		}
+	}
+	return models.RoleUser
+}
+
+// provisionExternalUser returns the local user of an external identity. Known identities return their linked user. Otherwise the
+// identity is linked to the local user with the same email when both sides verified it, or a new user is created just in time
+func (s *AuthHandler) provisionExternalUser(ctx context.Context, identity externalIdentity) (*models.User, error) {
+	userID, err := s.identityRepo.GetIdentityUserID(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		user, err := s.userRepo.GetUserByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return s.syncRole(ctx, user, identity.Role)
+	}
+	if !errors.Is(err, repository.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// link to an existing user by email. The local email must be verified as well so that an account registered with someone else's
+	// email cannot be taken over
+	var existing *models.User
+	if identity.Email != nil {
+		existing, err = s.userRepo.GetUserByEmail(ctx, *identity.Email)
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+	}
+	if existing != nil && identity.EmailVerified && existing.EmailVerified {
+		if err := s.identityRepo.CreateIdentity(ctx, nil, existing.ID, identity.Provider, identity.Subject); err != nil {
+			return nil, err
+		}
+		log.Printf("linked %s identity %s to user %s\n", identity.Provider, identity.Subject, existing.ID)
+		return s.syncRole(ctx, existing, identity.Role)
+	}
+	// the email belongs to another user, so the new user is created without it
+	if existing != nil {
+		identity.Email, identity.EmailVerified = nil, false
+	}
+
+	return s.createExternalUser(ctx, identity)
+}
+
+// createExternalUser creates a new user linked to an external identity. The user gets a random password so that they can only login
+// through the provider until they reset it
+func (s *AuthHandler) createExternalUser(ctx context.Context, identity externalIdentity) (*models.User, error) {
+	username, err := s.availableUsername(ctx, identity.Username)
+	if err != nil {
+		return nil, err
+	}
+	password, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.userRepo.GetTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := s.userRepo.CreateUser(ctx, tx, username, hashedPassword, identity.Email, identity.FullName)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Email != nil && identity.EmailVerified {
+		if err := s.userRepo.MarkEmailVerified(ctx, tx, user.ID); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+	if identity.Role != "" && identity.Role != user.Role {
+		if err := s.userRepo.SetRole(ctx, tx, user.ID, identity.Role); err != nil {
+			return nil, err
+		}
+		user.Role = identity.Role
+	}
+	if err := s.identityRepo.CreateIdentity(ctx, tx, user.ID, identity.Provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("provisioned user %s for %s identity %s\n", user.ID, identity.Provider, identity.Subject)
+	return user, nil
+}
+
+// syncRole updates the role of a user to the one granted by their provider
+func (s *AuthHandler) syncRole(ctx context.Context, user *models.User, role string) (*models.User, error) {
+	if role == "" || role == user.Role {
+		return user, nil
+	}
+	if err := s.userRepo.SetRole(ctx, nil, user.ID, role); err != nil {
+		return nil, err
+	}
+	user.Role = role
+	return user, nil
+}
+
+// availableUsername returns the username, or the username with a random suffix when it is already taken
+func (s *AuthHandler) availableUsername(ctx context.Context, username string) (string, error) {
+	if len(username) > maxUsernameLength-5 {
+		username = username[:maxUsernameLength-5]
+	}
+	candidate := username
+	for range 5 {
+		_, err := s.userRepo.GetUserByUsername(ctx, candidate)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		suffix := make([]byte, 2)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		candidate = username + "-" + hex.EncodeToString(suffix)
+	}
+	return "", fmt.Errorf("no available username for %q", username)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// invites expire after a week unless an expiry is given
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// InviteHandler provides management of registration invites
+type InviteHandler struct {
+	inviteRepo *repository.InviteRepository
+}
+
+// NewInviteHandler creates a new invite handler
+func NewInviteHandler(inviteRepo *repository.InviteRepository) *InviteHandler {
+	return &InviteHandler{
+		inviteRepo: inviteRepo,
+	}
+}
+
+// CreateInviteRequest represents the invite payload. All fields are optional
+type CreateInviteRequest struct {
+	Email     *string    `json:"email"`
+	MaxUses   int        `json:"maxUses"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// validate invite request
+func (data *CreateInviteRequest) validate() error {
+	if data.Email != nil {
+		if _, err := mail.ParseAddress(*data.Email); err != nil {
+			return fmt.Errorf("invalid email address")
+		}
+	}
+	if data.MaxUses < 0 {
+		return fmt.Errorf("max uses should be positive")
+	}
+	if data.ExpiresAt != nil && data.ExpiresAt.Before(time.Now()) {
+		return fmt.Errorf("expiry time cannot be in the past")
+	}
+	return nil
+}
+
+// CreateInviteResponse represents the minted invite along with its code. The code is only returned once
+type CreateInviteResponse struct {
+	Code   string         `json:"code"`
+	Invite *models.Invite `json:"invite"`
+}
+
+// CreateInvite mints a new invite code. Invites can be restricted to an email and used once unless configured otherwise
+func (s *InviteHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	userID, _ := GetUserID(r)
+
+	// parse the request body
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	expiresAt := time.Now().Add(defaultInviteTTL)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+
+	code, err := utils.GenerateToken(16)
+	if err != nil {
+		log.Printf("failed to generate invite code: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to create invite"})
+		return
+	}
+	invite, err := s.inviteRepo.CreateInvite(r.Context(), utils.HashToken(code), req.Email, req.MaxUses, expiresAt, &userID)
+	if err != nil {
+		log.Printf("failed to create invite: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to create invite"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusCreated, models.APIResponse{Message: "Invite created successfully", Data: CreateInviteResponse{Code: code, Invite: invite}})
+}
+
+// GetInvites retrieves all invites
+func (s *InviteHandler) GetInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := s.inviteRepo.GetInvites(r.Context())
+	if err != nil {
+		log.Printf("failed to retrieve invites: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve invites"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Invites retrieved successfully", Data: invites})
+}
+
+// RevokeInvite prevents further registrations with an invite
+func (s *InviteHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid invite ID"})
+		return
+	}
+
+	if err := s.inviteRepo.RevokeInvite(r.Context(), id); err != nil {
+		if errors.Is(err, repository.ErrInviteNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to revoke invite: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to revoke invite"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Invite revoked successfully"})
+}
+
+func (s *InviteHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errors
+var (
+	ErrJobAccessForbidden = errors.New("forbidden access. You don't have access to this job")
+)
+
+const (
+	defaultJobsLimit = 50
+	maxJobsLimit     = 500
+)
+
+// jobStore is the subset of the job repository used by the handlers
+type jobStore interface {
+	GetJobByID(ctx context.Context, id uuid.UUID) (*models.Job, error)
+	GetJobs(ctx context.Context, filter models.JobFilter, limit int) ([]*models.Job, error)
+	RequeueDeadJob(ctx context.Context, id uuid.UUID) (*models.Job, error)
+}
+
+// JobHandler provides the status of background jobs
+type JobHandler struct {
+	jobRepo jobStore
+}
+
+// NewJobHandler creates a new job handler
+func NewJobHandler(jobRepo *repository.JobRepository) *JobHandler {
+	return &JobHandler{
+		jobRepo: jobRepo,
+	}
+}
+
+// GetUserJobs retrieves the jobs requested by the logged-in user
+func (s *JobHandler) GetUserJobs(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	filter := s.parseFilter(r)
+	filter.UserID = &userID
+	s.listJobs(w, r, filter)
+}
+
+// GetUserJob retrieves a single job requested by the logged-in user
+func (s *JobHandler) GetUserJob(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	job, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+	if job.UserID == nil || *job.UserID != userID {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrJobAccessForbidden.Error()})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Job retrieved successfully", Data: job})
+}
+
+// GetJobs retrieves all jobs, optionally filtered by type and status
+func (s *JobHandler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	s.listJobs(w, r, s.parseFilter(r))
+}
+
+// GetJob retrieves any single job
+func (s *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Job retrieved successfully", Data: job})
+}
+
+// RetryJob queues a dead-lettered job again with its attempts reset
+func (s *JobHandler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid job ID"})
+		return
+	}
+
+	job, err := s.jobRepo.RequeueDeadJob(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrJobNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: "Dead job not found"})
+			return
+		}
+		log.Printf("failed to requeue job: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retry job"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Job queued for retry", Data: job})
+}
+
+// helper methods
+
+// parseFilter reads the type, status and limit query parameters
+func (s *JobHandler) parseFilter(r *http.Request) models.JobFilter {
+	query := r.URL.Query()
+	return models.JobFilter{
+		Type:   query.Get("type"),
+		Status: models.JobStatus(query.Get("status")),
+	}
+}
+
+func (s *JobHandler) listJobs(w http.ResponseWriter, r *http.Request, filter models.JobFilter) {
+	limit := defaultJobsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid limit"})
+			return
+		}
+		limit = min(parsed, maxJobsLimit)
+	}
+
+	jobs, err := s.jobRepo.GetJobs(r.Context(), filter, limit)
+	if err != nil {
+		log.Printf("failed to retrieve jobs: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve jobs"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Jobs retrieved successfully", Data: jobs})
+}
+
+// getJob retrieves the job in the path. A response is sent and false is returned when the job cannot be retrieved
+func (s *JobHandler) getJob(w http.ResponseWriter, r *http.Request) (*models.Job, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid job ID"})
+		return nil, false
+	}
+
+	job, err := s.jobRepo.GetJobByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrJobNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return nil, false
+		}
+		log.Printf("failed to retrieve job: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve job"})
+		return nil, false
+	}
+	return job, true
+}
+
+func (s *JobHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"sgs/internal/signing"
+)
+
+// jwksHandler publishes the public keys verifying access tokens as a JSON Web Key Set. Share link keys are not published since only sgs
+// verifies share links
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// verifiers refetch the set when a token has an unknown key id, so caching does not delay rotations
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(map[string][]signing.JWK{"keys": s.keyRing.JWKS(r.Context(), signing.PurposeAccess)}); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
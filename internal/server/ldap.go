@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"slices"
+
+	"sgs/internal/models"
+)
+
+// provider name of ldap identities
+const ldapProviderName = "ldap"
+
+// ldapLogin checks the credentials of a user against the directory and returns the local user of their entry, which is created on
+// their first login. Directory emails are trusted as verified
+func (s *AuthHandler) ldapLogin(ctx context.Context, username, password string) (*models.User, error) {
+	entry, err := s.ldapAuth.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := externalIdentity{
+		Provider:      ldapProviderName,
+		Subject:       entry.ID,
+		Username:      entry.Username,
+		EmailVerified: true,
+	}
+	if entry.Email != "" {
+		identity.Email = &entry.Email
+	}
+	if entry.Name != "" {
+		identity.FullName = &entry.Name
+	}
+	// users matching no role filter get the user role once filters are configured
+	if len(s.cfg.LDAPRoleFilters) > 0 {
+		identity.Role = models.RoleUser
+		if slices.Contains(entry.Roles, models.RoleAdmin) {
+			identity.Role = models.RoleAdmin
+		}
+	}
+
+	return s.provisionExternalUser(ctx, identity)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errors
+var (
+	ErrLoginLocked = errors.New("too many failed login attempts. Try again later")
+)
+
+const (
+	// failed logins of an account allowed without a delay, so that typos are not punished
+	freeLoginFailures = 2
+	// upper bound of the delay between failed logins of an account before it is locked
+	maxLoginDelay = 30 * time.Second
+)
+
+// loginDelay returns how long logins of an account are refused after its recent failures. The delay doubles with every failure past the
+// free ones until the limit is reached, which locks the account for the lockout duration
+func loginDelay(failures, limit int, lockout time.Duration) time.Duration {
+	if failures >= limit {
+		return lockout
+	}
+	if failures <= freeLoginFailures {
+		return 0
+	}
+	shift := min(failures-freeLoginFailures-1, 5)
+	return min(time.Second<<shift, maxLoginDelay, lockout)
+}
+
+// checkLoginLock responds with [ErrLoginLocked] when logins of the account or from the client are refused, and reports whether the
+// login may continue. Logins are refused when the lock cannot be checked
+func (s *AuthHandler) checkLoginLock(w http.ResponseWriter, r *http.Request, username string) bool {
+	lockedUntil, err := s.loginFailureRepo.GetLoginLock(r.Context(), username, clientIP(r, s.cfg.TrustedProxies))
+	if err != nil {
+		log.Printf("failed to check login lock: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
+		return false
+	}
+	if lockedUntil != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(*lockedUntil).Seconds()))))
+		s.sendResponse(w, http.StatusTooManyRequests, models.APIResponse{Message: ErrLoginLocked.Error()})
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed login against the account, known or not, and the client ip. Accounts are delayed and then locked,
+// client ips are locked once they reach their limit
+func (s *AuthHandler) recordLoginFailure(ctx context.Context, username, ip string) {
+	forgetBefore := time.Now().Add(-s.cfg.LoginLockout)
+
+	failures, err := s.loginFailureRepo.RecordLoginFailure(ctx, models.LoginScopeAccount, username, forgetBefore)
+	if err != nil {
+		log.Printf("failed to record login failure: %v\n", err)
+	} else if delay := loginDelay(failures, s.cfg.LoginMaxFailures, s.cfg.LoginLockout); delay > 0 {
+		if err := s.loginFailureRepo.LockLogin(ctx, models.LoginScopeAccount, username, time.Now().Add(delay)); err != nil {
+			log.Printf("failed to delay logins: %v\n", err)
+		} else if failures >= s.cfg.LoginMaxFailures {
+			log.Printf("locked account %q for %s after %d failed logins, the last from %s\n", username, delay, failures, ip)
+		}
+	}
+
+	failures, err = s.loginFailureRepo.RecordLoginFailure(ctx, models.LoginScopeIP, ip, forgetBefore)
+	if err != nil {
+		log.Printf("failed to record login failure: %v\n", err)
+	} else if failures >= s.cfg.LoginMaxIPFailures {
+		if err := s.loginFailureRepo.LockLogin(ctx, models.LoginScopeIP, ip, time.Now().Add(s.cfg.LoginLockout)); err != nil {
+			log.Printf("failed to lock logins: %v\n", err)
+		} else {
+			log.Printf("locked logins from %s for %s after %d failed logins, the last for %q\n", ip, s.cfg.LoginLockout, failures, username)
+		}
+	}
+}
+
+// clearLoginFailures forgets the failed logins of an account after it logged in with the right password
+func (s *AuthHandler) clearLoginFailures(ctx context.Context, username string) {
+	if _, err := s.loginFailureRepo.ClearLoginFailures(ctx, models.LoginScopeAccount, username); err != nil {
+		log.Printf("failed to clear login failures: %v\n", err)
+	}
+}
+
+// GetLoginLocks returns the accounts and client ips whose logins are currently refused
+func (s *AuthHandler) GetLoginLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := s.loginFailureRepo.GetLockedLogins(r.Context())
+	if err != nil {
+		log.Printf("failed to retrieve login locks: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve login locks"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Login locks retrieved successfully", Data: locks})
+}
+
+// UnlockUser lifts the lock of an account and forgets its failed logins
+func (s *AuthHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := GetUserID(r)
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to unlock user"})
+		return
+	}
+	unlocked, err := s.loginFailureRepo.ClearLoginFailures(r.Context(), models.LoginScopeAccount, user.Username)
+	if err != nil {
+		log.Printf("failed to unlock user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to unlock user"})
+		return
+	}
+	if unlocked {
+		log.Printf("admin %s unlocked account %q\n", adminID, user.Username)
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "User unlocked successfully"})
+}
+
+// UnlockIP lifts the lock of a client ip and forgets its failed logins
+func (s *AuthHandler) UnlockIP(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := GetUserID(r)
+	ip := mux.Vars(r)["ip"]
+
+	unlocked, err := s.loginFailureRepo.ClearLoginFailures(r.Context(), models.LoginScopeIP, ip)
+	if err != nil {
+		log.Printf("failed to unlock ip: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to unlock ip"})
+		return
+	}
+	if !unlocked {
+		s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: "No failed logins from this ip"})
+		return
+	}
+	log.Printf("admin %s unlocked logins from %s\n", adminID, ip)
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "IP unlocked successfully"})
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		limit    int
+		lockout  time.Duration
+		want     time.Duration
+	}{
+		{1, 5, 15 * time.Minute, 0},
+		{2, 5, 15 * time.Minute, 0},
+		{3, 5, 15 * time.Minute, time.Second},
+		{4, 5, 15 * time.Minute, 2 * time.Second},
+		{5, 5, 15 * time.Minute, 15 * time.Minute},
+		{9, 20, 15 * time.Minute, 30 * time.Second},
+		{60, 100, 15 * time.Minute, 30 * time.Second},
+		// delays never exceed the lockout
+		{4, 5, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures, tt.limit, tt.lockout); got != tt.want {
+			t.Errorf("loginDelay(%d, %d, %s) = %s; want %s", tt.failures, tt.limit, tt.lockout, got, tt.want)
+		}
+	}
+}
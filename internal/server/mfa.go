@@ -0,0 +1,537 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errors
+var (
+	ErrInvalidMFACode   = errors.New("invalid two-factor code")
+	ErrTOTPEnabled      = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled   = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotEnrolling = errors.New("two-factor authentication has not been set up. Request a new secret first")
+	ErrMFAPolicy        = errors.New("two-factor authentication is required by the server and cannot be disabled")
+)
+
+const (
+	// challenges only bridge the password and code steps of a login so they are short-lived
+	mfaChallengeTTL = 5 * time.Minute
+	// codes that can be submitted for a challenge before a new login is required
+	mfaChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	// issuer shown by authenticator apps
+	totpIssuer = "sgs"
+)
+
+// MFAChallengeResponse represents the response of a login that requires a second factor. The challenge token is exchanged for a
+// session at /auth/2fa/verify. Users that have not enrolled yet while the server requires two-factor authentication must first get a
+// secret at /auth/2fa/enroll
+type MFAChallengeResponse struct {
+	MFARequired        bool      `json:"mfaRequired"`
+	EnrollmentRequired bool      `json:"enrollmentRequired"`
+	ChallengeToken     string    `json:"challengeToken"`
+	ExpiresAt          time.Time `json:"expiresAt"`
+}
+
+// TOTPSetupResponse represents a new totp secret. The provisioning uri is rendered as a QR code for authenticator apps
+type TOTPSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// RecoveryCodesResponse represents a new set of recovery codes. The codes are only returned once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFALoginResponse represents the response of a completed two-factor login. Recovery codes are included when the login enrolled the user
+type MFALoginResponse struct {
+	LoginResponse
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// MFAStatus represents the two-factor authentication state of a user
+type MFAStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// MFAChallengeRequest represents the payload for acting on a login challenge
+type MFAChallengeRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+}
+
+// MFACodeRequest represents a second factor. Either a totp code or a recovery code is given
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+// VerifyMFARequest represents the payload for completing a two-factor login
+type VerifyMFARequest struct {
+	MFAChallengeRequest
+	MFACodeRequest
+}
+
+// DisableTOTPRequest represents the payload for disabling two-factor authentication
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+	MFACodeRequest
+}
+
+// VerifyMFA completes a login with the challenge token and a second factor. Users enrolling during the login confirm their new secret
+// with a totp code, which enables two-factor authentication and returns their recovery codes
+func (s *AuthHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req VerifyMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "code or recovery code is required"})
+		return
+	}
+
+	challengeID, userID, err := s.mfaRepo.AttemptMFAChallenge(r.Context(), utils.HashToken(req.ChallengeToken), mfaChallengeAttempts)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidMFAChallenge) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to attempt two-factor challenge: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify two-factor code"})
+		return
+	}
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user for two-factor challenge: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify two-factor code"})
+		return
+	}
+	if user.DisabledAt != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrAccountDisabled.Error()})
+		return
+	}
+	enrolling := !user.TOTPEnabled
+	if enrolling && user.TOTPSecret == nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: ErrTOTPNotEnrolling.Error()})
+		return
+	}
+
+	tx, err := s.mfaRepo.GetTx(r.Context())
+	if err != nil {
+		log.Printf("failed to start transaction: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify two-factor code"})
+		return
+	}
+	defer tx.Rollback()
+
+	// recovery codes are only accepted once enrolled
+	code := req.MFACodeRequest
+	if enrolling {
+		code.RecoveryCode = ""
+	}
+	if err := s.verifySecondFactor(r.Context(), tx, user, code); err != nil {
+		s.sendSecondFactorError(w, err)
+		return
+	}
+
+	var recoveryCodes []string
+	err = s.mfaRepo.ConsumeMFAChallenge(r.Context(), tx, challengeID)
+	if err == nil && enrolling {
+		if err = s.mfaRepo.EnableTOTP(r.Context(), tx, user.ID); err == nil {
+			recoveryCodes, err = s.replaceRecoveryCodes(r.Context(), tx, user.ID)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidMFAChallenge) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to complete two-factor challenge: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify two-factor code"})
+		return
+	}
+
+	tokens, err := s.issueTokens(r, tx, user, nil)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("failed to create session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
+		return
+	}
+	user.TOTPEnabled = true
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Login successful", Data: MFALoginResponse{
+		LoginResponse: LoginResponse{TokenPair: *tokens, User: *user},
+		RecoveryCodes: recoveryCodes,
+	}})
+}
+
+// EnrollTOTP creates a totp secret for a user that has to enroll before completing a login. The secret is confirmed with a code at
+// /auth/2fa/verify
+func (s *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	var req MFAChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	userID, err := s.mfaRepo.GetMFAChallengeUser(r.Context(), utils.HashToken(req.ChallengeToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidMFAChallenge) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve two-factor challenge: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to set up two-factor authentication"})
+		return
+	}
+
+	s.sendTOTPSetup(w, r, userID)
+}
+
+// GetMFAStatus retrieves the two-factor authentication state of the logged-in user
+func (s *AuthHandler) GetMFAStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	status := MFAStatus{Enabled: user.TOTPEnabled, Required: s.cfg.MFARequired}
+	if user.TOTPEnabled {
+		count, err := s.mfaRepo.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			log.Printf("failed to count recovery codes: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve two-factor status"})
+			return
+		}
+		status.RecoveryCodesRemaining = count
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Two-factor status retrieved successfully", Data: status})
+}
+
+// SetupTOTP creates a totp secret for the logged-in user. Two-factor authentication is enabled once a code of the secret is confirmed
+// at /auth/2fa/enable
+func (s *AuthHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	s.sendTOTPSetup(w, r, user.ID)
+}
+
+// EnableTOTP confirms the totp secret of the logged-in user with a code and enables two-factor authentication. Other sessions of the
+// user are revoked since they were started without a second factor
+func (s *AuthHandler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getSessionUser(w, r)
+	if !ok {
+		return
+	}
+	sessionID, _ := GetSessionID(r)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if user.TOTPEnabled {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrTOTPEnabled.Error()})
+		return
+	}
+	if user.TOTPSecret == nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: ErrTOTPNotEnrolling.Error()})
+		return
+	}
+
+	tx, err := s.mfaRepo.GetTx(r.Context())
+	if err != nil {
+		log.Printf("failed to start transaction: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to enable two-factor authentication"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := s.verifySecondFactor(r.Context(), tx, user, MFACodeRequest{Code: req.Code}); err != nil {
+		s.sendSecondFactorError(w, err)
+		return
+	}
+	var recoveryCodes []string
+	if err = s.mfaRepo.EnableTOTP(r.Context(), tx, user.ID); err == nil {
+		if recoveryCodes, err = s.replaceRecoveryCodes(r.Context(), tx, user.ID); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		log.Printf("failed to enable two-factor authentication: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to enable two-factor authentication"})
+		return
+	}
+
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), user.ID, sessionID); err != nil {
+		log.Printf("failed to revoke sessions after enabling two-factor authentication: %v\n", err)
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Two-factor authentication enabled successfully. Store the recovery codes in a safe place",
+		Data: RecoveryCodesResponse{RecoveryCodes: recoveryCodes}})
+}
+
+// DisableTOTP turns off two-factor authentication for the logged-in user after verifying their password and a second factor. It is
+// not allowed while the server requires two-factor authentication
+func (s *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req DisableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if s.cfg.MFARequired {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrMFAPolicy.Error()})
+		return
+	}
+	if !user.TOTPEnabled {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrTOTPNotEnabled.Error()})
+		return
+	}
+	if err := utils.VerifyPassword(user.Password, req.Password); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: ErrInvalidCredentials.Error()})
+		return
+	}
+	if err := s.verifySecondFactor(r.Context(), nil, user, req.MFACodeRequest); err != nil {
+		s.sendSecondFactorError(w, err)
+		return
+	}
+
+	if err := s.mfaRepo.DisableTOTP(r.Context(), user.ID); err != nil {
+		log.Printf("failed to disable two-factor authentication: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to disable two-factor authentication"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Two-factor authentication disabled successfully"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged-in user after verifying a totp code
+func (s *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if !user.TOTPEnabled {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrTOTPNotEnabled.Error()})
+		return
+	}
+
+	tx, err := s.mfaRepo.GetTx(r.Context())
+	if err != nil {
+		log.Printf("failed to start transaction: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to regenerate recovery codes"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := s.verifySecondFactor(r.Context(), tx, user, MFACodeRequest{Code: req.Code}); err != nil {
+		s.sendSecondFactorError(w, err)
+		return
+	}
+	recoveryCodes, err := s.replaceRecoveryCodes(r.Context(), tx, user.ID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("failed to regenerate recovery codes: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to regenerate recovery codes"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Recovery codes regenerated successfully", Data: RecoveryCodesResponse{RecoveryCodes: recoveryCodes}})
+}
+
+// ResetTOTP turns off two-factor authentication for a user that lost their authenticator and recovery codes. Every session of the user
+// is revoked. Users must enroll again on their next login when the server requires two-factor authentication
+func (s *AuthHandler) ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	if err := s.mfaRepo.DisableTOTP(r.Context(), userID); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to reset two-factor authentication: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to reset two-factor authentication"})
+		return
+	}
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), userID, uuid.Nil); err != nil {
+		log.Printf("failed to revoke sessions after two-factor reset: %v\n", err)
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Two-factor authentication reset successfully"})
+}
+
+// helper methods
+
+// startMFAChallenge stores a new login challenge for a user that passed the password step
+func (s *AuthHandler) startMFAChallenge(ctx context.Context, user *models.User) (*MFAChallengeResponse, error) {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	if err := s.mfaRepo.CreateMFAChallenge(ctx, user.ID, utils.HashToken(token), expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &MFAChallengeResponse{
+		MFARequired:        true,
+		EnrollmentRequired: !user.TOTPEnabled,
+		ChallengeToken:     token,
+		ExpiresAt:          expiresAt,
+	}, nil
+}
+
+// verifySecondFactor checks a totp code, or a recovery code when given, of a user. Accepted codes are used up within the transaction
+// when one is passed
+func (s *AuthHandler) verifySecondFactor(ctx context.Context, tx *sql.Tx, user *models.User, req MFACodeRequest) error {
+	if req.RecoveryCode != "" {
+		return s.mfaRepo.UseRecoveryCode(ctx, tx, user.ID, utils.HashToken(normalizeRecoveryCode(req.RecoveryCode)))
+	}
+	if user.TOTPSecret == nil {
+		return ErrInvalidMFACode
+	}
+
+	secret, err := utils.DecryptSecret(s.cfg.JwtSecret, *user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	counter, ok := utils.ValidateTOTP(secret, req.Code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return s.mfaRepo.UseTOTPCounter(ctx, tx, user.ID, counter)
+}
+
+// sendSecondFactorError responds to a failed [AuthHandler.verifySecondFactor]
+func (s *AuthHandler) sendSecondFactorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, repository.ErrInvalidRecoveryCode) || errors.Is(err, repository.ErrTOTPCodeUsed) {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
+		return
+	}
+	log.Printf("failed to verify two-factor code: %v\n", err)
+	s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify two-factor code"})
+}
+
+// sendTOTPSetup generates and stores a new totp secret for a user that has not enabled two-factor authentication yet
+func (s *AuthHandler) sendTOTPSetup(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user for two-factor setup: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to set up two-factor authentication"})
+		return
+	}
+	if user.TOTPEnabled {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrTOTPEnabled.Error()})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("failed to generate totp secret: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to set up two-factor authentication"})
+		return
+	}
+	sealed, err := utils.EncryptSecret(s.cfg.JwtSecret, secret)
+	if err == nil {
+		err = s.mfaRepo.SetTOTPSecret(r.Context(), user.ID, sealed)
+	}
+	if err != nil {
+		log.Printf("failed to store totp secret: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to set up two-factor authentication"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Scan the provisioning uri with an authenticator app and confirm with a code", Data: TOTPSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(totpIssuer, user.Username, secret),
+	}})
+}
+
+// getSessionUser retrieves the logged-in user of a session-authenticated request. Requests authenticated with api keys are rejected
+func (s *AuthHandler) getSessionUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return nil, false
+	}
+	if _, ok := GetSessionID(r); !ok {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
+		return nil, false
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes for a user and stores their hashes within the transaction
+func (s *AuthHandler) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = utils.HashToken(code)
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode strips the separators and casing of a recovery code as typed by a user
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
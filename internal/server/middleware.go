@@ -2,10 +2,19 @@ package server
 
 import (
 	"context"
-	"database/sql"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"sgs/internal/authz"
 	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/usage"
+	"sgs/internal/utils"
 	"strings"
 	"time"
 
@@ -21,65 +30,119 @@ const (
 	UserIDKey contextKey = "userID"
 	// APIKey is the token for the API key in the request context
 	APIKeyToken contextKey = "APIKey"
+	// APIKeyKey is the key for the API key authenticating the request in the request context
+	APIKeyKey contextKey = "apiKey"
+	// SessionIDKey is the key for the session of the access token in the request context
+	SessionIDKey contextKey = "sessionID"
+	// PersonalAccessTokenIDKey is the key for the personal access token in the request context
+	PersonalAccessTokenIDKey contextKey = "personalAccessTokenID"
 )
 
+// sessions record their last use at most once in this interval
+const sessionTouchInterval = time.Minute
+
 // AuthMiddleware checks JWT tokens and adds user info to the request context
 func AuthMiddleware(s *AuthHandler) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// first check if api key is present
-			if r.Header.Get("X-API-Key") != "" {
-				// extract api key
+			// first check if api key is present, either as a token or as the credential of a signed request
+			signed := strings.HasPrefix(r.Header.Get("Authorization"), signatureAlgorithm+" ")
+			if r.Header.Get("X-API-Key") != "" || signed {
+				ip := clientIP(r, s.cfg.TrustedProxies)
+				now := time.Now().UTC()
+
+				var key *models.APIKey
 				token := r.Header.Get("X-API-Key")
-				if token == "" {
-					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "API key required"})
-					return
+				if signed {
+					var ok bool
+					if key, ok = s.authenticateSignedRequest(w, r, ip, now); !ok {
+						return
+					}
+				} else {
+					// validate api key
+					var err error
+					key, err = s.findAPIKey(r.Context(), token)
+					if err != nil {
+						if errors.Is(err, repository.ErrAPIKeyNotFound) {
+							s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid API key"})
+							return
+						}
+						log.Printf("Failed to retrieve API key %v\n", err)
+						s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Failed to validate API key"})
+						return
+					}
+					// keys in signing mode never accept their token
+					if key.Signed {
+						s.denyAPIKey(r, key, ip, models.DenialUnsigned)
+						s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "API key requires signed requests"})
+						return
+					}
 				}
 
-				// ensure api key is only scoped to project specific paths
-				if !strings.Contains(r.URL.Path, "projects") {
-					s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
+				// validate key expiry
+				if key.RevokedAt != nil || (key.RevokesAt != nil && key.RevokesAt.Before(now)) {
+					s.denyAPIKey(r, key, ip, models.DenialRevoked)
+					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "API key has been revoked or has expired"})
+					return
+				}
+				if key.ExpiresAt.UTC().Before(now) {
+					s.denyAPIKey(r, key, ip, models.DenialExpired)
+					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "API key has been revoked or has expired"})
 					return
 				}
 
-				// validate api key
-				key, err := s.apiKeyRepo.GetAPIKeyByToken(r.Context(), token)
-				if err != nil {
-					if err == sql.ErrNoRows {
-						s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid API key"})
-						return
+				// validate the addresses and times the key is restricted to
+				if reason := checkAPIKeyRestrictions(key.Restrictions, ip, now); reason != "" {
+					s.denyAPIKey(r, key, ip, reason)
+					message := "API key cannot be used at this time"
+					if reason == models.DenialIP {
+						message = "API key cannot be used from this address"
 					}
-					log.Printf("Failed to retrieve API key %v\n", err)
-					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Failed to validate API key"})
+					s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: message})
 					return
 				}
 
-				// validate ownership
-				projectID, err := uuid.Parse(mux.Vars(r)["id"])
-				if err != nil {
-					s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid project ID"})
+				// ensure api key is only used on the routes open to api keys
+				route, ok := apiKeyRouteFor(r)
+				if !ok {
+					s.denyAPIKey(r, key, ip, models.DenialRoute)
+					s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
 					return
 				}
 
-				if projectID != key.ProjectID {
-					log.Println(projectID, key.ProjectID, key.ProjectBucket)
-					s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "You don't have access to this project"})
+				// validate scope
+				if scope, _ := authz.APIKeyScope(route.Action); !key.HasScope(scope) {
+					s.denyAPIKey(r, key, ip, models.DenialScope)
+					s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: fmt.Sprintf("API key lacks the %s scope", scope)})
 					return
 				}
 
-				// validate key expiry
-				now := time.Now().UTC()
-				if key.ExpiresAt.UTC().Before(now) || key.RevokedAt != nil {
-					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "API key has been revoked or has expired"})
+				// validate ownership
+				if !s.apiKeyOwnsResource(w, r, key, ip, route.Resource) {
 					return
 				}
 
-				// add owner info and key token into request
+				// add owner info and key into request
 				ctx := context.WithValue(r.Context(), UserIDKey, key.UserID)
-				ctx = context.WithValue(ctx, APIKeyToken, token)
+				if !signed {
+					ctx = context.WithValue(ctx, APIKeyToken, token)
+				}
+				ctx = context.WithValue(ctx, APIKeyKey, key)
 
-				// call the next handler with the context
-				next.ServeHTTP(w, r.WithContext(ctx))
+				// call the next handler with the context, counting the bytes of the bodies for the usage of the key
+				body := &countingReader{ReadCloser: r.Body}
+				r.Body = body
+				writer := &countingResponseWriter{ResponseWriter: w}
+				next.ServeHTTP(writer, r.WithContext(ctx))
+
+				s.usage.Record(usage.Event{
+					APIKeyID:  key.ID,
+					At:        now,
+					IP:        ip,
+					UserAgent: r.UserAgent(),
+					BytesIn:   body.n,
+					BytesOut:  writer.n,
+				})
 			} else {
 				// extract token from Authorization header
 				authHeader := r.Header.Get("Authorization")
@@ -97,8 +160,18 @@ func AuthMiddleware(s *AuthHandler) func(http.Handler) http.Handler {
 
 				tokenString := parts[1]
 
+				// personal access tokens act as their user without a session
+				if strings.HasPrefix(tokenString, personalAccessTokenPrefix) {
+					ctx, ok := s.authenticatePersonalAccessToken(w, r, tokenString)
+					if !ok {
+						return
+					}
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+
 				// validate the token
-				claims, err := s.ValidateToken(tokenString)
+				claims, err := s.ValidateToken(r.Context(), tokenString)
 				if err != nil {
 					log.Printf("Invalid jwt token: %v\n", err)
 					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid or expired token"})
@@ -119,8 +192,34 @@ func AuthMiddleware(s *AuthHandler) func(http.Handler) http.Handler {
 					return
 				}
 
-				// add user ID to request context
+				// reject tokens of revoked sessions
+				sessionIDStr, _ := claims["sid"].(string)
+				sessionID, err := uuid.Parse(sessionIDStr)
+				if err != nil {
+					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid or expired token"})
+					return
+				}
+				session, err := s.sessionRepo.GetCurrentSession(r.Context(), sessionID)
+				if err != nil {
+					if !errors.Is(err, repository.ErrSessionNotFound) {
+						log.Printf("Failed to retrieve session: %v\n", err)
+					}
+					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid or expired token"})
+					return
+				}
+				if session.RevokedAt != nil || session.UserID != userID {
+					s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Session has been revoked"})
+					return
+				}
+				if time.Since(session.LastSeenAt) > sessionTouchInterval {
+					if err := s.sessionRepo.TouchSession(r.Context(), session.ID); err != nil {
+						log.Printf("Failed to record session activity: %v\n", err)
+					}
+				}
+
+				// add user and session IDs to request context
 				ctx := context.WithValue(r.Context(), UserIDKey, userID)
+				ctx = context.WithValue(ctx, SessionIDKey, sessionID)
 
 				// call the next handler with the context
 				next.ServeHTTP(w, r.WithContext(ctx))
@@ -130,14 +229,248 @@ func AuthMiddleware(s *AuthHandler) func(http.Handler) http.Handler {
 
 }
 
+// findAPIKey retrieves the api key of a token. The keys sharing the prefix of the token are compared to its digest in constant time.
+// [repository.ErrAPIKeyNotFound] is returned when no key matches
+func (s *AuthHandler) findAPIKey(ctx context.Context, token string) (*models.APIKey, error) {
+	prefix := apiKeyTokenPrefix(token)
+	if prefix == "" {
+		return nil, repository.ErrAPIKeyNotFound
+	}
+	keys, err := s.apiKeyRepo.GetAPIKeysByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	tokenHash := []byte(utils.HashToken(token))
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key.TokenHash), tokenHash) == 1 {
+			return key, nil
+		}
+	}
+	return nil, repository.ErrAPIKeyNotFound
+}
+
+// apiKeyOwnsResource checks that the resource in the path belongs to the project of an api key. A response is sent and false is
+// returned when it does not
+func (s *AuthHandler) apiKeyOwnsResource(w http.ResponseWriter, r *http.Request, key *models.APIKey, ip, resource string) bool {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: fmt.Sprintf("Invalid %s ID", resource)})
+		return false
+	}
+
+	projectID := id
+	if resource == resourceFile {
+		file, err := s.fileRepo.GetFileByID(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, repository.ErrFileNotFound) {
+				s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+				return false
+			}
+			log.Printf("Failed to retrieve file for API key access: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to validate API key"})
+			return false
+		}
+		projectID = file.ProjectID
+	}
+
+	if projectID != key.ProjectID {
+		s.denyAPIKey(r, key, ip, models.DenialProject)
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "You don't have access to this project"})
+		return false
+	}
+	return true
+}
+
+// denyAPIKey records a denied request made with an api key, along with the reason
+func (s *AuthHandler) denyAPIKey(r *http.Request, key *models.APIKey, ip, reason string) {
+	denial := &models.APIKeyDenial{
+		APIKeyID:  key.ID,
+		Reason:    reason,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+	}
+	if err := s.apiKeyRepo.RecordAPIKeyDenial(r.Context(), denial); err != nil {
+		log.Printf("Failed to record API key denial: %v\n", err)
+	}
+}
+
+// authenticatePersonalAccessToken validates a personal access token and returns the request context acting as its user. Tokens are
+// rejected on the account and admin routes, so that a leaked token cannot take over the account. A response is sent and false is
+// returned when the token is rejected
+func (s *AuthHandler) authenticatePersonalAccessToken(w http.ResponseWriter, r *http.Request, raw string) (context.Context, bool) {
+	if strings.HasPrefix(r.URL.Path, "/api/auth/") || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Personal access tokens cannot manage the account"})
+		return nil, false
+	}
+
+	token, err := s.tokenRepo.GetPersonalAccessTokenByHash(r.Context(), utils.HashToken(raw))
+	if err != nil {
+		if !errors.Is(err, repository.ErrPersonalAccessTokenNotFound) {
+			log.Printf("Failed to retrieve personal access token: %v\n", err)
+		}
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid personal access token"})
+		return nil, false
+	}
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now())) {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Personal access token has been revoked or has expired"})
+		return nil, false
+	}
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > personalAccessTokenTouchInterval {
+		if err := s.tokenRepo.TouchPersonalAccessToken(r.Context(), token.ID); err != nil {
+			log.Printf("Failed to record personal access token activity: %v\n", err)
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey, token.UserID)
+	ctx = context.WithValue(ctx, PersonalAccessTokenIDKey, token.ID)
+	return ctx, true
+}
+
 // GetUserID retrieves the user ID from the request context
 func GetUserID(r *http.Request) (uuid.UUID, bool) {
 	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
 	return userID, ok
 }
 
+// GetSessionID retrieves the session of the access token from the request context. Requests authenticated with API keys have no session
+func GetSessionID(r *http.Request) (uuid.UUID, bool) {
+	sessionID, ok := r.Context().Value(SessionIDKey).(uuid.UUID)
+	return sessionID, ok
+}
+
+// clientIP returns the address of the client that sent the request. When the request comes from a trusted proxy, the client is the
+// last address of the X-Forwarded-For header that is not a trusted proxy, since earlier entries can be forged by the client. X-Real-IP
+// is used when a trusted proxy sends no X-Forwarded-For header
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	remote = remote.Unmap()
+	if !utils.PrefixesContain(trusted, remote) {
+		return remote.String()
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+		return remote.String()
+	}
+	hops := []netip.Addr{}
+	for _, header := range forwarded {
+		for _, item := range strings.Split(header, ",") {
+			addr, err := netip.ParseAddr(strings.TrimSpace(item))
+			if err != nil {
+				// the chain cannot be followed past a malformed entry
+				hops = hops[:0]
+				continue
+			}
+			hops = append(hops, addr.Unmap())
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !utils.PrefixesContain(trusted, hops[i]) {
+			return hops[i].String()
+		}
+	}
+	// every hop is a trusted proxy, so the first one is the client
+	if len(hops) > 0 {
+		return hops[0].String()
+	}
+	return remote.String()
+}
+
+// requestPrincipal returns the caller of an authenticated request for authorization
+func requestPrincipal(r *http.Request) authz.Principal {
+	userID, _ := GetUserID(r)
+	key, _ := GetRequestAPIKey(r)
+	return authz.Principal{UserID: userID, APIKey: key}
+}
+
+// GetPersonalAccessTokenID retrieves the personal access token authenticating the request from the request context
+func GetPersonalAccessTokenID(r *http.Request) (uuid.UUID, bool) {
+	id, ok := r.Context().Value(PersonalAccessTokenIDKey).(uuid.UUID)
+	return id, ok
+}
+
+// GetRequestAPIKey retrieves the api key authenticating the request from the request context
+func GetRequestAPIKey(r *http.Request) (*models.APIKey, bool) {
+	key, ok := r.Context().Value(APIKeyKey).(*models.APIKey)
+	return key, ok
+}
+
 // GetAPIKeyToken retrieves the api key token from the request context
 func GetAPIKeyToken(r *http.Request) (string, bool) {
 	token, ok := r.Context().Value(APIKeyToken).(string)
 	return token, ok
 }
+
+// AdminMiddleware restricts access to users with the admin role. It must run after [AuthMiddleware] and rejects requests
+// authenticated with API keys or personal access tokens
+func AdminMiddleware(s *AuthHandler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, apiKey := GetRequestAPIKey(r)
+			_, personalAccessToken := GetPersonalAccessTokenID(r)
+			if apiKey || personalAccessToken {
+				s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
+				return
+			}
+			userID, ok := GetUserID(r)
+			if !ok {
+				s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+				return
+			}
+
+			user, err := s.userRepo.GetUserByID(r.Context(), userID)
+			if err != nil {
+				log.Printf("Failed to retrieve user for admin access: %v\n", err)
+				s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+				return
+			}
+			if user.Role != models.RoleAdmin {
+				s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Admin access required"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter counts the bytes written to a response body
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer so that [http.ResponseController] reaches it
+func (c *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{"203.0.113.5:4321", nil, "", "203.0.113.5"},
+		// headers of untrusted clients are ignored
+		{"203.0.113.5:4321", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.5"},
+		{"10.0.0.2:4321", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		// entries added by trusted proxies are skipped, and entries before the client can be forged
+		{"10.0.0.2:4321", []string{"192.0.2.66, 198.51.100.1, 10.0.0.3"}, "", "198.51.100.1"},
+		{"10.0.0.2:4321", []string{"192.0.2.66", "198.51.100.1, 10.0.0.3"}, "", "198.51.100.1"},
+		{"10.0.0.2:4321", []string{"10.0.0.4, 10.0.0.3"}, "", "10.0.0.4"},
+		{"10.0.0.2:4321", []string{"198.51.100.1, garbage"}, "", "10.0.0.2"},
+		{"10.0.0.2:4321", nil, "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.2:4321", nil, "", "10.0.0.2"},
+		{"[::1]:4321", []string{"2001:db8::5"}, "", "2001:db8::5"},
+		{"[::ffff:203.0.113.5]:4321", nil, "", "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/projects", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r, trusted); got != tt.want {
+			t.Errorf("clientIP(%s, %v, %q) = %s; want %s", tt.remoteAddr, tt.forwardedFor, tt.realIP, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/oidc"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+)
+
+// errors
+var (
+	ErrSSONotConfigured = errors.New("single sign-on is not configured")
+	ErrSSOFailed        = errors.New("single sign-on failed. Try again")
+)
+
+const (
+	// time a user has to sign in at the provider
+	oidcStateTTL = 10 * time.Minute
+	// login codes are exchanged by the web app right after the redirect
+	oidcLoginCodeTTL = time.Minute
+	// provider name of openid connect identities
+	oidcProviderName = "oidc"
+)
+
+// OIDCLogin redirects the user to the login page of the openid connect provider. The pending login is stored with its PKCE verifier
+// and nonce until the provider redirects back to [AuthHandler.OIDCCallback]
+func (s *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidcProvider == nil {
+		s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: ErrSSONotConfigured.Error()})
+		return
+	}
+
+	state, err := utils.GenerateToken(32)
+	if err != nil {
+		log.Printf("failed to generate sso state: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to start single sign-on"})
+		return
+	}
+	nonce, err := utils.GenerateToken(32)
+	if err != nil {
+		log.Printf("failed to generate sso nonce: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to start single sign-on"})
+		return
+	}
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		log.Printf("failed to generate sso code verifier: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to start single sign-on"})
+		return
+	}
+
+	authURL, err := s.oidcProvider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("failed to build sso url: %v\n", err)
+		s.sendResponse(w, http.StatusBadGateway, models.APIResponse{Message: "Single sign-on provider is unavailable"})
+		return
+	}
+	if err := s.oidcRepo.CreateState(r.Context(), utils.HashToken(state), verifier, nonce, time.Now().Add(oidcStateTTL)); err != nil {
+		log.Printf("failed to store sso state: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to start single sign-on"})
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes the login at the provider. The user is provisioned or linked and redirected to the web app with a
+// single-use login code that is exchanged for a session at /auth/oidc/exchange. Failures redirect to the login page of the web app
+func (s *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidcProvider == nil {
+		s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: ErrSSONotConfigured.Error()})
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("sso provider returned an error: %s %s\n", providerErr, query.Get("error_description"))
+		s.redirectToApp(w, r, "/login", "error", ErrSSOFailed.Error())
+		return
+	}
+
+	verifier, nonce, err := s.oidcRepo.ConsumeState(r.Context(), utils.HashToken(query.Get("state")))
+	if err != nil {
+		if !errors.Is(err, repository.ErrInvalidOIDCState) {
+			log.Printf("failed to consume sso state: %v\n", err)
+		}
+		s.redirectToApp(w, r, "/login", "error", ErrSSOFailed.Error())
+		return
+	}
+
+	claims, err := s.oidcProvider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("failed to complete sso: %v\n", err)
+		s.redirectToApp(w, r, "/login", "error", ErrSSOFailed.Error())
+		return
+	}
+	claimed := oidc.IdentityFromClaims(claims)
+	if claimed.Subject == "" {
+		log.Println("sso id token has no subject")
+		s.redirectToApp(w, r, "/login", "error", ErrSSOFailed.Error())
+		return
+	}
+
+	identity := externalIdentity{
+		Provider:      oidcProviderName,
+		Subject:       claimed.Subject,
+		Username:      claimed.Username,
+		EmailVerified: claimed.EmailVerified,
+		Role:          resolveRole(oidc.ClaimStrings(claims, s.cfg.OIDCRoleClaim), s.cfg.OIDCRoleMapping),
+	}
+	if claimed.Email != "" {
+		identity.Email = &claimed.Email
+	}
+	if claimed.Name != "" {
+		identity.FullName = &claimed.Name
+	}
+	user, err := s.provisionExternalUser(r.Context(), identity)
+	if err != nil {
+		log.Printf("failed to provision sso user: %v\n", err)
+		s.redirectToApp(w, r, "/login", "error", ErrSSOFailed.Error())
+		return
+	}
+
+	code, err := utils.GenerateToken(32)
+	if err == nil {
+		err = s.oidcRepo.CreateLoginCode(r.Context(), user.ID, utils.HashToken(code), time.Now().Add(oidcLoginCodeTTL))
+	}
+	if err != nil {
+		log.Printf("failed to store sso login code: %v\n", err)
+		s.redirectToApp(w, r, "/login", "error", ErrSSOFailed.Error())
+		return
+	}
+
+	s.redirectToApp(w, r, "/sso/callback", "code", code)
+}
+
+// OIDCExchangeRequest represents the payload for exchanging a single sign-on login code
+type OIDCExchangeRequest struct {
+	Code string `json:"code"`
+}
+
+// OIDCExchange trades a single sign-on login code for a session. Users with two-factor authentication get a challenge like a local login
+func (s *AuthHandler) OIDCExchange(w http.ResponseWriter, r *http.Request) {
+	var req OIDCExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	userID, err := s.oidcRepo.ConsumeLoginCode(r.Context(), utils.HashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidOIDCLoginCode) {
+			s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to consume sso login code: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
+		return
+	}
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve sso user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to login"})
+		return
+	}
+
+	s.completeLogin(w, r, user)
+}
+
+// redirectToApp redirects to a page of the web app with a single query parameter
+func (s *AuthHandler) redirectToApp(w http.ResponseWriter, r *http.Request, path, key, value string) {
+	target := s.cfg.AppURL.JoinPath(path)
+	query := target.Query()
+	query.Set(key, value)
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sgs/internal/config"
+	"sgs/internal/mailer"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// reset tokens are short-lived since they are sent over email
+	passwordResetTTL = time.Hour
+	// maximum duration of the delivery of a reset email
+	passwordResetSendTimeout = 30 * time.Second
+)
+
+// passwordUserStore is the subset of the user repository used by the password handler
+type passwordUserStore interface {
+	GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdatePassword(ctx context.Context, tx *sql.Tx, id uuid.UUID, password string) error
+	RequirePasswordReset(ctx context.Context, id uuid.UUID) error
+}
+
+// passwordResetStore is the subset of the password reset repository used by the password handler
+type passwordResetStore interface {
+	CreatePasswordReset(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+	ConsumePasswordReset(ctx context.Context, tx *sql.Tx, tokenHash string) (uuid.UUID, error)
+	GetTx(ctx context.Context) (*sql.Tx, error)
+}
+
+// sessionRevoker revokes the sessions of a user
+type sessionRevoker interface {
+	RevokeOtherSessions(ctx context.Context, userID, keepFamilyID uuid.UUID) (int64, error)
+}
+
+// PasswordHandler provides password change and reset functionality
+type PasswordHandler struct {
+	cfg         *config.Config
+	userRepo    passwordUserStore
+	sessionRepo sessionRevoker
+	resetRepo   passwordResetStore
+	mailer      mailer.Mailer
+}
+
+// NewPasswordHandler creates a new password handler
+func NewPasswordHandler(cfg *config.Config, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, resetRepo *repository.PasswordResetRepository, mailer mailer.Mailer) *PasswordHandler {
+	return &PasswordHandler{
+		cfg:         cfg,
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		resetRepo:   resetRepo,
+		mailer:      mailer,
+	}
+}
+
+// ChangePasswordRequest represents the password change payload
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// ChangePassword replaces the password of the logged-in user after verifying the current one. Other sessions of the user are revoked
+func (s *PasswordHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	sessionID, ok := GetSessionID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
+		return
+	}
+
+	// parse the request body
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if req.CurrentPassword == "" {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "current password is required"})
+		return
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to change password"})
+		return
+	}
+	if err := utils.VerifyPassword(user.Password, req.CurrentPassword); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "current password is incorrect"})
+		return
+	}
+
+	if err := s.setPassword(r.Context(), userID, req.NewPassword, sessionID); err != nil {
+		log.Printf("failed to change password: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to change password"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Password changed successfully"})
+}
+
+// ForgotPasswordRequest represents the payload for requesting a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ForgotPassword emails a password reset link to the user with the given email. The response is the same whether or not the email
+// belongs to a user so that it cannot be used to discover accounts
+func (s *PasswordHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	resp := models.APIResponse{Message: "If an account with this email exists, a password reset link has been sent to it"}
+	user, err := s.userRepo.GetUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			log.Printf("failed to retrieve user for password reset: %v\n", err)
+		}
+		s.sendResponse(w, http.StatusAccepted, resp)
+		return
+	}
+
+	token, err := s.createResetToken(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("failed to create password reset token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to request password reset"})
+		return
+	}
+
+	// deliver the email in the background so that the response time does not reveal whether the account exists
+	go s.sendResetEmail(user, token)
+
+	s.sendResponse(w, http.StatusAccepted, resp)
+}
+
+// ResetPasswordRequest represents the payload for resetting a password with an emailed token
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// ResetPassword replaces the password of the user of a reset token. The token is consumed and every session of the user is revoked
+func (s *PasswordHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("failed to hash password: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to reset password"})
+		return
+	}
+
+	// consume the token and update the password together so that a failed update leaves the token usable
+	tx, err := s.resetRepo.GetTx(r.Context())
+	if err != nil {
+		log.Printf("failed to start transaction: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to reset password"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := s.resetRepo.ConsumePasswordReset(r.Context(), tx, utils.HashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidPasswordReset) {
+			s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to consume password reset token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to reset password"})
+		return
+	}
+	if err = s.userRepo.UpdatePassword(r.Context(), tx, userID, hashedPassword); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("failed to reset password: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to reset password"})
+		return
+	}
+
+	// sign out everywhere since the old password may have been compromised
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), userID, uuid.Nil); err != nil {
+		log.Printf("failed to revoke sessions after password reset: %v\n", err)
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Password reset successfully. Proceed to login"})
+}
+
+// ForcePasswordReset makes a user choose a new password through the reset flow before they can login again. Every session of the user
+// is revoked and a reset link is emailed to them when they have an email address
+func (s *PasswordHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := GetUserID(r)
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid user ID"})
+		return
+	}
+	if userID == adminID {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: ErrSelfAdministration.Error()})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to force password reset"})
+		return
+	}
+	if err := s.userRepo.RequirePasswordReset(r.Context(), user.ID); err != nil {
+		log.Printf("failed to require password reset: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to force password reset"})
+		return
+	}
+	if _, err := s.sessionRepo.RevokeOtherSessions(r.Context(), user.ID, uuid.Nil); err != nil {
+		log.Printf("failed to revoke sessions after forcing a password reset: %v\n", err)
+	}
+	log.Printf("admin %s forced a password reset of user %s\n", adminID, user.ID)
+
+	if user.Email == nil {
+		s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Password reset required. The user has no email address to send the reset link to"})
+		return
+	}
+	token, err := s.createResetToken(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("failed to create password reset token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Password reset required, but the reset link could not be sent"})
+		return
+	}
+	go s.sendResetEmail(user, token)
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Password reset required. A reset link has been sent to the user"})
+}
+
+// helper methods
+
+// createResetToken stores a new password reset token of a user and returns it
+func (s *PasswordHandler) createResetToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := s.resetRepo.CreatePasswordReset(ctx, userID, utils.HashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// setPassword hashes and stores a new password, then revokes every session of the user except the one to keep
+func (s *PasswordHandler) setPassword(ctx context.Context, userID uuid.UUID, password string, keepSessionID uuid.UUID) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, nil, userID, hashedPassword); err != nil {
+		return err
+	}
+	if _, err := s.sessionRepo.RevokeOtherSessions(ctx, userID, keepSessionID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// sendResetEmail delivers a password reset link to a user
+func (s *PasswordHandler) sendResetEmail(user *models.User, token string) {
+	resetURL := s.cfg.AppURL.JoinPath("/reset-password")
+	resetURL.RawQuery = "token=" + token
+
+	msg, err := mailer.Render(mailer.TemplatePasswordReset, *user.Email, map[string]string{
+		"Username":  user.Username,
+		"ResetURL":  resetURL.String(),
+		"ExpiresIn": "1 hour",
+	})
+	if err != nil {
+		log.Printf("failed to render password reset email: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), passwordResetSendTimeout)
+	defer cancel()
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("failed to send password reset email to user %s: %v\n", user.ID, err)
+	}
+}
+
+func (s *PasswordHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sgs/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// txDB is a database whose only use is starting transactions. It records whether the last transaction was committed
+type txDB struct {
+	committed bool
+}
+
+func (db *txDB) Connect(context.Context) (driver.Conn, error) { return db, nil }
+func (db *txDB) Driver() driver.Driver                        { return nil }
+func (db *txDB) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("unexpected statement") }
+func (db *txDB) Close() error                                 { return nil }
+func (db *txDB) Begin() (driver.Tx, error)                    { return db, nil }
+func (db *txDB) Commit() error                                { db.committed = true; return nil }
+func (db *txDB) Rollback() error                              { return nil }
+
+type fakePasswordResets struct {
+	db     *sql.DB
+	userID uuid.UUID
+}
+
+func (f *fakePasswordResets) CreatePasswordReset(context.Context, uuid.UUID, string, time.Time) error {
+	return nil
+}
+func (f *fakePasswordResets) ConsumePasswordReset(context.Context, *sql.Tx, string) (uuid.UUID, error) {
+	return f.userID, nil
+}
+func (f *fakePasswordResets) GetTx(ctx context.Context) (*sql.Tx, error) {
+	return f.db.BeginTx(ctx, nil)
+}
+
+type fakePasswordUsers struct {
+	updateErr error
+}
+
+func (f *fakePasswordUsers) GetUserByID(context.Context, uuid.UUID) (*models.User, error) {
+	return nil, sql.ErrNoRows
+}
+func (f *fakePasswordUsers) GetUserByEmail(context.Context, string) (*models.User, error) {
+	return nil, sql.ErrNoRows
+}
+func (f *fakePasswordUsers) UpdatePassword(context.Context, *sql.Tx, uuid.UUID, string) error {
+	return f.updateErr
+}
+func (f *fakePasswordUsers) RequirePasswordReset(context.Context, uuid.UUID) error { return nil }
+
+type fakeSessionRevoker struct {
+	revoked bool
+}
+
+func (f *fakeSessionRevoker) RevokeOtherSessions(context.Context, uuid.UUID, uuid.UUID) (int64, error) {
+	f.revoked = true
+	return 1, nil
+}
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		name       string
+		updateErr  error
+		wantStatus int
+	}{
+		{"update succeeds", nil, http.StatusOK},
+		// a failed update must not be reported as a reset, and leaves the token usable
+		{"update fails", errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &txDB{}
+			sessions := &fakeSessionRevoker{}
+			s := &PasswordHandler{
+				userRepo:    &fakePasswordUsers{updateErr: tt.updateErr},
+				sessionRepo: sessions,
+				resetRepo:   &fakePasswordResets{db: sql.OpenDB(db), userID: uuid.New()},
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/auth/password/reset", strings.NewReader(`{"token": "token", "newPassword": "secret123"}`))
+			w := httptest.NewRecorder()
+			s.ResetPassword(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d; want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			success := tt.updateErr == nil
+			if db.committed != success {
+				t.Errorf("committed = %v; want %v", db.committed, success)
+			}
+			if sessions.revoked != success {
+				t.Errorf("sessions revoked = %v; want %v", sessions.revoked, success)
+			}
+		})
+	}
+}
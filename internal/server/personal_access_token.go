@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// prefix telling personal access tokens apart from access tokens in the Authorization header
+	personalAccessTokenPrefix = "sgs_pat_"
+	// number of characters of a token kept to tell tokens apart in listings
+	personalAccessTokenPrefixLength = len(personalAccessTokenPrefix) + 4
+	// personal access tokens record their last use at most once in this interval
+	personalAccessTokenTouchInterval = time.Minute
+)
+
+// PersonalAccessTokenHandler provides management of the personal access tokens of a user
+type PersonalAccessTokenHandler struct {
+	tokenRepo *repository.PersonalAccessTokenRepository
+}
+
+// NewPersonalAccessTokenHandler creates a new personal access token handler
+func NewPersonalAccessTokenHandler(tokenRepo *repository.PersonalAccessTokenRepository) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{
+		tokenRepo: tokenRepo,
+	}
+}
+
+// CreatePersonalAccessTokenRequest represents the personal access token creation payload
+type CreatePersonalAccessTokenRequest struct {
+	Name string `json:"name"`
+	// optional. Tokens without an expiry are valid until revoked
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// validate personal access token creation request
+func (data *CreatePersonalAccessTokenRequest) validate() error {
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" || len(data.Name) > 255 {
+		return fmt.Errorf("token name is required and must be at most 255 characters")
+	}
+	if data.ExpiresAt != nil && data.ExpiresAt.Before(time.Now().Add(time.Hour)) {
+		return fmt.Errorf("expiry time must be at least 1 hour from now")
+	}
+	return nil
+}
+
+// CreatePersonalAccessToken creates a token acting as the logged-in user. The token is only returned in this response
+func (s *PersonalAccessTokenHandler) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	var req CreatePersonalAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		log.Printf("failed to generate personal access token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to create personal access token"})
+		return
+	}
+	raw := personalAccessTokenPrefix + secret
+
+	token, err := s.tokenRepo.CreatePersonalAccessToken(r.Context(), userID, req.Name, utils.HashToken(raw), raw[:personalAccessTokenPrefixLength], req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, repository.ErrPersonalAccessTokenExists) {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to save personal access token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to create personal access token"})
+		return
+	}
+	token.Token = raw
+
+	s.sendResponse(w, http.StatusCreated, models.APIResponse{Message: "Personal access token created successfully. Store it now, it will not be shown again", Data: token})
+}
+
+// GetPersonalAccessTokens retrieves the personal access tokens of the logged-in user
+func (s *PersonalAccessTokenHandler) GetPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	tokens, err := s.tokenRepo.GetPersonalAccessTokensByUserID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve personal access tokens: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve personal access tokens"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Personal access tokens retrieved successfully", Data: tokens})
+}
+
+// RevokePersonalAccessToken revokes a personal access token of the logged-in user. Requests made with it are rejected from then on
+func (s *PersonalAccessTokenHandler) RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid personal access token ID"})
+		return
+	}
+
+	if err := s.tokenRepo.RevokePersonalAccessToken(r.Context(), id, userID); err != nil {
+		if errors.Is(err, repository.ErrPersonalAccessTokenNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to revoke personal access token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to revoke personal access token"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Personal access token revoked successfully"})
+}
+
+func (s *PersonalAccessTokenHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"strings"
+	"time"
+	// embed the time zone database so that time zones validate on hosts without one
+	_ "time/tzdata"
+)
+
+const (
+	maxFullNameLength = 255
+	// bounds of the default lifetime of share links, matching the bounds of share links created with an explicit expiry
+	minDefaultShareExpiry = 5 * time.Minute
+	maxDefaultShareExpiry = 365 * 24 * time.Hour
+)
+
+// ProfileResponse represents the profile of the logged-in user
+type ProfileResponse struct {
+	models.User
+	Preferences models.UserPreferences `json:"preferences"`
+}
+
+// GetProfile retrieves the profile and preferences of the logged-in user
+func (s *AccountHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve profile"})
+		return
+	}
+	preferences, err := s.userRepo.GetPreferences(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve preferences: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve profile"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Profile retrieved successfully", Data: ProfileResponse{User: *user, Preferences: *preferences}})
+}
+
+// UpdateProfileRequest represents the profile update payload. Omitted fields are left unchanged and an empty full name clears it
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	FullName *string `json:"fullName"`
+}
+
+// UpdateProfile changes the username and full name of the logged-in user
+func (s *AccountHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve user: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update profile"})
+		return
+	}
+
+	username, fullName := user.Username, user.FullName
+	if req.Username != nil {
+		username = strings.TrimSpace(*req.Username)
+		if username == "" || len(username) > maxUsernameLength {
+			s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: fmt.Sprintf("username must be between 1 and %d characters", maxUsernameLength)})
+			return
+		}
+	}
+	if req.FullName != nil {
+		fullName = nil
+		if name := strings.TrimSpace(*req.FullName); name != "" {
+			if len(name) > maxFullNameLength {
+				s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: fmt.Sprintf("full name must be at most %d characters", maxFullNameLength)})
+				return
+			}
+			fullName = &name
+		}
+	}
+
+	if username != user.Username {
+		existing, err := s.userRepo.GetUserByUsername(r.Context(), username)
+		if err == nil && existing.ID != user.ID {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrUsernameInUse.Error()})
+			return
+		}
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			log.Printf("failed to check username: %v\n", err)
+			s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update profile"})
+			return
+		}
+	}
+
+	updated, err := s.userRepo.UpdateProfile(r.Context(), user.ID, username, fullName)
+	if err != nil {
+		// another user took the username since it was checked
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: ErrUsernameInUse.Error()})
+			return
+		}
+		log.Printf("failed to update profile: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update profile"})
+		return
+	}
+	if updated.Username != user.Username {
+		log.Printf("user %s changed their username from %q to %q\n", user.ID, user.Username, updated.Username)
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Profile updated successfully", Data: updated})
+}
+
+// GetPreferences retrieves the preferences of the logged-in user
+func (s *AccountHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	preferences, err := s.userRepo.GetPreferences(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve preferences: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve preferences"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Preferences retrieved successfully", Data: preferences})
+}
+
+// UpdatePreferences changes the preferences of the logged-in user. The payload is applied over the current preferences, so that
+// omitted fields are left unchanged
+func (s *AccountHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+
+	preferences, err := s.userRepo.GetPreferences(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve preferences: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update preferences"})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(preferences); err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+	if err := validatePreferences(preferences); err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
+		return
+	}
+
+	if err := s.userRepo.UpdatePreferences(r.Context(), userID, *preferences); err != nil {
+		log.Printf("failed to update preferences: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to update preferences"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Preferences updated successfully", Data: preferences})
+}
+
+// validatePreferences checks that the preferences of a user are within bounds
+func validatePreferences(preferences *models.UserPreferences) error {
+	if expiry := preferences.DefaultShareExpiry; expiry != nil {
+		minSeconds, maxSeconds := int64(minDefaultShareExpiry/time.Second), int64(maxDefaultShareExpiry/time.Second)
+		if *expiry < minSeconds || *expiry > maxSeconds {
+			return fmt.Errorf("default share expiry must be between %d and %d seconds", minSeconds, maxSeconds)
+		}
+	}
+	// local time is the time zone of the server rather than of the user
+	if preferences.Timezone == "" || preferences.Timezone == "Local" {
+		return fmt.Errorf("invalid timezone %q", preferences.Timezone)
+	}
+	if _, err := time.LoadLocation(preferences.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", preferences.Timezone)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+
+	"sgs/internal/models"
+)
+
+func TestValidatePreferences(t *testing.T) {
+	expiry := func(seconds int64) *int64 { return &seconds }
+	tests := []struct {
+		expiry   *int64
+		timezone string
+		valid    bool
+	}{
+		{nil, "UTC", true},
+		{expiry(3600), "Europe/Paris", true},
+		{expiry(300), "America/New_York", true},
+		{expiry(299), "UTC", false},
+		{expiry(365*24*3600 + 1), "UTC", false},
+		// large values must not overflow into the bounds
+		{expiry(1 << 62), "UTC", false},
+		{nil, "", false},
+		{nil, "Local", false},
+		{nil, "Mars/Olympus_Mons", false},
+	}
+	for _, tt := range tests {
+		preferences := models.UserPreferences{DefaultShareExpiry: tt.expiry, Timezone: tt.timezone}
+		if err := validatePreferences(&preferences); (err == nil) != tt.valid {
+			t.Errorf("validatePreferences(%v, %q) = %v; want valid %t", tt.expiry, tt.timezone, err, tt.valid)
+		}
+	}
+}
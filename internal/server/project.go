@@ -1,14 +1,19 @@
 package server
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sgs/internal/authz"
+	"sgs/internal/config"
 	"sgs/internal/models"
 	"sgs/internal/repository"
 	"sgs/internal/store"
+	"slices"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -20,38 +25,64 @@ var (
 	ErrProjectInUse    = errors.New("project already exists")
 )
 
+// projectStore is the subset of the project repository used by the handlers
+type projectStore interface {
+	CreateProject(ctx context.Context, tx *sql.Tx, ownerID uuid.UUID, bucket string) (*models.Project, error)
+	GetProjectByID(ctx context.Context, id uuid.UUID) (*models.Project, error)
+	GetProjectByIDTx(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*models.Project, error)
+	GetProjectsByOwnerID(ctx context.Context, ownerID uuid.UUID) ([]*models.Project, error)
+	DeleteProjectByID(ctx context.Context, id uuid.UUID) error
+	GetTx(ctx context.Context) (*sql.Tx, error)
+}
+
+// bucketStore creates and removes the buckets of projects in the object store
+type bucketStore interface {
+	CreateBucket(ctx context.Context, name string, enableLocking bool) error
+	RemoveBucket(ctx context.Context, name string) error
+}
+
 // ProjectHandler provides functionality for managing a project
 type ProjectHandler struct {
-	projectRepo *repository.ProjectRepository
-	store       *store.Store
+	cfg         *config.Config
+	projectRepo projectStore
+	store       bucketStore
 }
 
 // NewProjectHandler creates a new Project handler
-func NewProjectHandler(projectRepo *repository.ProjectRepository, store *store.Store) *ProjectHandler {
+func NewProjectHandler(cfg *config.Config, projectRepo *repository.ProjectRepository, store *store.Store) *ProjectHandler {
 	return &ProjectHandler{
+		cfg:         cfg,
 		projectRepo: projectRepo,
 		store:       store,
 	}
 }
 
-// CreateProjectRequest represents the registration payload
+// CreateProjectRequest represents the registration payload. Projects are owned by the user creating them
 type CreateProjectRequest struct {
-	OwnerID uuid.UUID `json:"ownerId"`
-	Bucket  string    `json:"bucket"`
+	Bucket string `json:"bucket"`
 }
 
 // validate register request
 func (data *CreateProjectRequest) validate() error {
-	if data.OwnerID.String() == "" || data.Bucket == "" {
-		return fmt.Errorf("owner id and bucket are required")
+	if data.Bucket == "" {
+		return fmt.Errorf("bucket is required")
 	}
-	// parse owner id as uuid
-	// if _, ok
 	return nil
 }
 
 // CreateProject creates a new project
 func (s *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
+	// get user id
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return
+	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionCreateProject, authz.Resource{OwnerID: userID}); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
+
 	// parse the request body
 	var req CreateProjectRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -64,6 +95,11 @@ func (s *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: err.Error()})
 		return
 	}
+	// the export bucket holds the archives of every user
+	if slices.Contains(s.cfg.ReservedBuckets(), req.Bucket) {
+		s.sendResponse(w, http.StatusConflict, models.APIResponse{Message: config.ErrBucketReserved.Error()})
+		return
+	}
 
 	// start transaction to ensure that bucket creation and project saving is atomic
 	tx, err := s.projectRepo.GetTx(r.Context())
@@ -76,7 +112,7 @@ func (s *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	//  save bucket details in db
-	project, err := s.projectRepo.CreateProject(r.Context(), tx, req.OwnerID, req.Bucket)
+	project, err := s.projectRepo.CreateProject(r.Context(), tx, userID, req.Bucket)
 	if err != nil {
 		log.Printf("failed to save project in db: %v\n", err)
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
@@ -118,6 +154,10 @@ func (s *ProjectHandler) GetProject(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
 		return
 	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionReadProject, authz.ProjectResource(project)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
 
 	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Project retrieved successfully", Data: project})
 }
@@ -173,6 +213,10 @@ func (s *ProjectHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to delete project"})
 		return
 	}
+	if err := authz.Authorize(requestPrincipal(r), authz.ActionDeleteProject, authz.ProjectResource(bucket)); err != nil {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: err.Error()})
+		return
+	}
 
 	// phase 2
 	//  delete project
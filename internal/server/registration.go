@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sgs/internal/config"
+	"sgs/internal/mailer"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/utils"
+)
+
+// errors
+var (
+	ErrRegistrationDisabled = errors.New("registration is disabled")
+	ErrInviteRequired       = errors.New("an invite code is required to register")
+	ErrEmailRequired        = errors.New("an email address is required to register")
+	ErrEmailDomainForbidden = errors.New("registration is not allowed for this email domain")
+	ErrEmailNotVerified     = errors.New("email address has not been verified. Check your email for the verification link")
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	// maximum duration of the delivery of a verification email
+	emailVerificationSendTimeout = 30 * time.Second
+)
+
+// checkRegistration verifies that the registration mode of the server allows the request
+func (s *AuthHandler) checkRegistration(req *RegisterRequest) error {
+	switch s.cfg.RegistrationMode {
+	case config.RegistrationDisabled:
+		return ErrRegistrationDisabled
+	case config.RegistrationInvite:
+		if req.InviteCode == "" {
+			return ErrInviteRequired
+		}
+	case config.RegistrationDomains:
+		if req.Email == nil {
+			return ErrEmailRequired
+		}
+		if !slices.Contains(s.cfg.AllowedEmailDomains, emailDomain(*req.Email)) {
+			return ErrEmailDomainForbidden
+		}
+	}
+	if s.cfg.EmailVerification && req.Email == nil {
+		return ErrEmailRequired
+	}
+	return nil
+}
+
+// emailDomain returns the lowercase domain of an email address
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(email[at+1:], ">"))
+}
+
+// VerifyEmailRequest represents the email verification payload
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmail marks the email of the user of a verification token as verified. The token is consumed
+func (s *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	tx, err := s.verificationRepo.GetTx(r.Context())
+	if err != nil {
+		log.Printf("failed to start transaction: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify email"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := s.verificationRepo.ConsumeEmailVerification(r.Context(), tx, utils.HashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidEmailVerification) {
+			s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to consume email verification token: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify email"})
+		return
+	}
+	if err = s.userRepo.MarkEmailVerified(r.Context(), tx, userID); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("failed to verify email: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to verify email"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Email verified successfully. Proceed to login"})
+}
+
+// ResendVerificationRequest represents the payload for requesting a new verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// ResendVerification emails a new verification link to an unverified user. The response is the same whether or not the email belongs
+// to a user so that it cannot be used to discover accounts
+func (s *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Invalid request payload"})
+		return
+	}
+
+	resp := models.APIResponse{Message: "If an unverified account with this email exists, a verification link has been sent to it"}
+	user, err := s.userRepo.GetUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			log.Printf("failed to retrieve user for email verification: %v\n", err)
+		}
+		s.sendResponse(w, http.StatusAccepted, resp)
+		return
+	}
+	if user.EmailVerified {
+		s.sendResponse(w, http.StatusAccepted, resp)
+		return
+	}
+
+	if err := s.startEmailVerification(r.Context(), user); err != nil {
+		log.Printf("failed to start email verification: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to send verification email"})
+		return
+	}
+	s.sendResponse(w, http.StatusAccepted, resp)
+}
+
+// startEmailVerification stores a new verification token for a user and emails it in the background
+func (s *AuthHandler) startEmailVerification(ctx context.Context, user *models.User) error {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+	if err := s.verificationRepo.CreateEmailVerification(ctx, user.ID, utils.HashToken(token), time.Now().Add(emailVerificationTTL)); err != nil {
+		return err
+	}
+
+	go s.sendVerificationEmail(user, token)
+	return nil
+}
+
+// sendVerificationEmail delivers an email verification link to a user
+func (s *AuthHandler) sendVerificationEmail(user *models.User, token string) {
+	verifyURL := s.cfg.AppURL.JoinPath("/verify-email")
+	verifyURL.RawQuery = "token=" + token
+
+	msg, err := mailer.Render(mailer.TemplateEmailVerification, *user.Email, map[string]string{
+		"Username":  user.Username,
+		"VerifyURL": verifyURL.String(),
+		"ExpiresIn": "24 hours",
+	})
+	if err != nil {
+		log.Printf("failed to render verification email: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), emailVerificationSendTimeout)
+	defer cancel()
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("failed to send verification email to user %s: %v\n", user.ID, err)
+	}
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sgs/internal/models"
+	"sgs/internal/utils"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// scheme of the Authorization header of requests signed with the signing secret of an api key
+	signatureAlgorithm = "SGS1-HMAC-SHA256"
+	// headers of signed requests carrying the signing time, a value unique to the request and the hex sha256 digest of the body
+	signatureDateHeader    = "X-SGS-Date"
+	signatureNonceHeader   = "X-SGS-Nonce"
+	signatureContentHeader = "X-SGS-Content-SHA256"
+	// layout of the signing time, in UTC
+	signatureDateLayout = "20060102T150405Z"
+	// bodies up to this size are checked against their signed digest before the request is handled. Larger bodies are checked as they
+	// are read, and reading them fails at the end when they do not match
+	maxBufferedSignedBody = 1 << 20
+)
+
+// headers every signature must cover
+var requiredSignedHeaders = []string{"host", "x-sgs-content-sha256", "x-sgs-date", "x-sgs-nonce"}
+
+var (
+	signatureNonceRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+	sha256HexRegexp      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// errSignedBodyMismatch is returned when reading a signed body that does not match its signed digest
+var errSignedBodyMismatch = errors.New("request body does not match the signed content hash")
+
+// requestSignature is the parsed Authorization header of a signed request
+type requestSignature struct {
+	// token prefix of the api key
+	Credential string
+	// lowercase names of the signed headers, sorted
+	SignedHeaders []string
+	Signature     string
+}
+
+// parseSignature parses an Authorization header of the form
+// SGS1-HMAC-SHA256 Credential=<token prefix>, SignedHeaders=<header>;<header>, Signature=<hex hmac>
+func parseSignature(header string) (*requestSignature, error) {
+	params, ok := strings.CutPrefix(header, signatureAlgorithm+" ")
+	if !ok {
+		return nil, fmt.Errorf("invalid authorization format")
+	}
+	var signature requestSignature
+	for _, param := range strings.Split(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid signature parameter %q", param)
+		}
+		switch name {
+		case "Credential":
+			signature.Credential = value
+		case "SignedHeaders":
+			signature.SignedHeaders = strings.Split(value, ";")
+		case "Signature":
+			signature.Signature = value
+		default:
+			return nil, fmt.Errorf("unknown signature parameter %q", name)
+		}
+	}
+	if signature.Credential == "" || signature.Signature == "" {
+		return nil, fmt.Errorf("signature credential and signature are required")
+	}
+	if !slices.IsSorted(signature.SignedHeaders) {
+		return nil, fmt.Errorf("signed headers must be sorted")
+	}
+	for _, name := range requiredSignedHeaders {
+		if !slices.Contains(signature.SignedHeaders, name) {
+			return nil, fmt.Errorf("signed headers must include %s", name)
+		}
+	}
+	for _, name := range signature.SignedHeaders {
+		if name != strings.ToLower(name) || name == "authorization" {
+			return nil, fmt.Errorf("invalid signed header %q", name)
+		}
+	}
+	return &signature, nil
+}
+
+// canonicalRequest returns the parts of a request covered by its signature: the method, the escaped path, the query with keys and
+// values sorted, the signed headers as name:value lines, the list of signed headers and the signed digest of the body, separated by
+// newlines
+func canonicalRequest(r *http.Request, signedHeaders []string) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		values := slices.Clone(query[key])
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, signatureEscape(key)+"="+signatureEscape(value))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(path + "\n")
+	b.WriteString(strings.Join(pairs, "&") + "\n")
+	for _, name := range signedHeaders {
+		values := r.Header.Values(name)
+		if name == "host" {
+			values = []string{r.Host}
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+	b.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(r.Header.Get(signatureContentHeader))
+	return b.String()
+}
+
+// signatureEscape percent-encodes a query key or value, with spaces encoded as %20
+func signatureEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// stringToSign returns the string signed with the signing secret: the algorithm, the signing time, the nonce and the hex sha256 digest
+// of the canonical request, separated by newlines
+func stringToSign(r *http.Request, signedHeaders []string) string {
+	digest := sha256.Sum256([]byte(canonicalRequest(r, signedHeaders)))
+	return strings.Join([]string{
+		signatureAlgorithm,
+		r.Header.Get(signatureDateHeader),
+		r.Header.Get(signatureNonceHeader),
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+}
+
+// signString returns the hex HMAC-SHA256 of a string with a signing secret
+func signString(secret, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newSigningSecret returns a new signing secret and the secret sealed for storage
+func newSigningSecret(passphrase string) (string, string, error) {
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate signing secret: %w", err)
+	}
+	sealed, err := utils.EncryptSecret(passphrase, secret)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to seal signing secret: %w", err)
+	}
+	return secret, sealed, nil
+}
+
+// authenticateSignedRequest verifies the signature of a request made with an api key in signing mode and returns the key. Signatures
+// older or newer than the skew window and nonces the key already used are rejected as replays. A response is sent and false is returned
+// when the request is rejected
+func (s *AuthHandler) authenticateSignedRequest(w http.ResponseWriter, r *http.Request, ip string, now time.Time) (*models.APIKey, bool) {
+	signature, err := parseSignature(r.Header.Get("Authorization"))
+	if err != nil {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: err.Error()})
+		return nil, false
+	}
+	date, err := time.Parse(signatureDateLayout, r.Header.Get(signatureDateHeader))
+	if err != nil {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: fmt.Sprintf("Invalid %s header", signatureDateHeader)})
+		return nil, false
+	}
+	nonce := r.Header.Get(signatureNonceHeader)
+	if !signatureNonceRegexp.MatchString(nonce) {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: fmt.Sprintf("Invalid %s header", signatureNonceHeader)})
+		return nil, false
+	}
+	contentHash := r.Header.Get(signatureContentHeader)
+	if !sha256HexRegexp.MatchString(contentHash) {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: fmt.Sprintf("Invalid %s header", signatureContentHeader)})
+		return nil, false
+	}
+
+	key, err := s.findSigningAPIKey(r.Context(), signature, stringToSign(r, signature.SignedHeaders))
+	if err != nil {
+		log.Printf("Failed to retrieve API key %v\n", err)
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Failed to validate API key"})
+		return nil, false
+	}
+	if key == nil {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Invalid request signature"})
+		return nil, false
+	}
+
+	// replay protection
+	skew := s.cfg.APIKeySignatureSkew
+	if date.Before(now.Add(-skew)) || date.After(now.Add(skew)) {
+		s.denyAPIKey(r, key, ip, models.DenialReplay)
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Request signature is outside the allowed clock skew"})
+		return nil, false
+	}
+	fresh, err := s.apiKeyRepo.UseAPIKeyNonce(r.Context(), key.ID, nonce, date.Add(skew))
+	if err != nil {
+		log.Printf("Failed to record request nonce: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to validate API key"})
+		return nil, false
+	}
+	if !fresh {
+		s.denyAPIKey(r, key, ip, models.DenialReplay)
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Request nonce has already been used"})
+		return nil, false
+	}
+
+	matches, err := verifySignedBody(r, contentHash)
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, models.APIResponse{Message: "Failed to read request body"})
+		return nil, false
+	}
+	if !matches {
+		s.denyAPIKey(r, key, ip, models.DenialSignature)
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: errSignedBodyMismatch.Error()})
+		return nil, false
+	}
+	return key, true
+}
+
+// findSigningAPIKey returns the key in signing mode whose token prefix is the credential of a signature and whose signing secret made
+// the signature, or nil when no key did
+func (s *AuthHandler) findSigningAPIKey(ctx context.Context, signature *requestSignature, toSign string) (*models.APIKey, error) {
+	keys, err := s.apiKeyRepo.GetAPIKeysByPrefix(ctx, signature.Credential)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if !key.Signed {
+			continue
+		}
+		secret, err := utils.DecryptSecret(s.cfg.JwtSecret, key.SealedSigningSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open signing secret: %w", err)
+		}
+		if hmac.Equal([]byte(signString(secret, toSign)), []byte(signature.Signature)) {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// verifySignedBody checks the body of a request against its signed digest. Small bodies are read and checked now, larger and chunked
+// bodies are checked once read to the end by the handler, so handlers storing a large body must read it to the end before storing it
+func verifySignedBody(r *http.Request, contentHash string) (bool, error) {
+	if r.ContentLength >= 0 && r.ContentLength <= maxBufferedSignedBody {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return false, err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		digest := sha256.Sum256(body)
+		return hex.EncodeToString(digest[:]) == contentHash, nil
+	}
+	r.Body = &signedBodyReader{ReadCloser: r.Body, hash: sha256.New(), contentHash: contentHash}
+	return true, nil
+}
+
+// signedBodyReader hashes a body as it is read and fails at its end when it does not match the signed digest
+type signedBodyReader struct {
+	io.ReadCloser
+	hash        hash.Hash
+	contentHash string
+}
+
+func (b *signedBodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(b.hash.Sum(nil)) != b.contentHash {
+		return n, errSignedBodyMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"sgs/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestParseSignature(t *testing.T) {
+	required := "host;x-sgs-content-sha256;x-sgs-date;x-sgs-nonce"
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=" + required + ", Signature=abc", true},
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=content-type;" + required + ", Signature=abc", true},
+		{"Bearer sgs_1a2b3c4d", false},
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=" + required, false},
+		// every required header must be signed
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=host;x-sgs-date;x-sgs-nonce, Signature=abc", false},
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=x-sgs-content-sha256;host;x-sgs-date;x-sgs-nonce, Signature=abc", false},
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=Host;x-sgs-content-sha256;x-sgs-date;x-sgs-nonce, Signature=abc", false},
+		{"SGS1-HMAC-SHA256 Credential=sgs_1a2b3c4d, SignedHeaders=" + required + ", Signature=abc, Region=eu", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseSignature(tt.header); (err == nil) != tt.valid {
+			t.Errorf("parseSignature(%q) = %v; want valid %t", tt.header, err, tt.valid)
+		}
+	}
+}
+
+func TestCanonicalRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://files.example.com/api/projects/1/files/meta?sort=name&q=a+b&sort=date", nil)
+	r.Header.Set("Content-Type", "  application/json  ")
+	r.Header.Set(signatureContentHeader, emptyBodyHash)
+	r.Header.Set(signatureDateHeader, "20260302T100000Z")
+	r.Header.Set(signatureNonceHeader, "0123456789abcdef")
+
+	want := "GET\n" +
+		"/api/projects/1/files/meta\n" +
+		"q=a%20b&sort=date&sort=name\n" +
+		"content-type:application/json\n" +
+		"host:files.example.com\n" +
+		"x-sgs-content-sha256:" + emptyBodyHash + "\n" +
+		"x-sgs-date:20260302T100000Z\n" +
+		"x-sgs-nonce:0123456789abcdef\n" +
+		"content-type;host;x-sgs-content-sha256;x-sgs-date;x-sgs-nonce\n" +
+		emptyBodyHash
+	signed := []string{"content-type", "host", "x-sgs-content-sha256", "x-sgs-date", "x-sgs-nonce"}
+	if got := canonicalRequest(r, signed); got != want {
+		t.Errorf("canonicalRequest() = %q; want %q", got, want)
+	}
+}
+
+func TestSignatureCoversRequest(t *testing.T) {
+	signed := []string{"content-type", "host", "x-sgs-content-sha256", "x-sgs-date", "x-sgs-nonce"}
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodDelete, "http://files.example.com/api/files/7?force=true", nil)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(signatureContentHeader, emptyBodyHash)
+		r.Header.Set(signatureDateHeader, "20260302T100000Z")
+		r.Header.Set(signatureNonceHeader, "0123456789abcdef")
+		return r
+	}
+	signature := signString("secret", stringToSign(newRequest(), signed))
+
+	tests := []struct {
+		name   string
+		tamper func(r *http.Request)
+	}{
+		{"method", func(r *http.Request) { r.Method = http.MethodGet }},
+		{"path", func(r *http.Request) { r.URL.Path = "/api/files/8" }},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "force=false" }},
+		{"host", func(r *http.Request) { r.Host = "evil.example.com" }},
+		{"signed header", func(r *http.Request) { r.Header.Set("Content-Type", "text/plain") }},
+		{"content hash", func(r *http.Request) { r.Header.Set(signatureContentHeader, strings.Repeat("0", 64)) }},
+		{"date", func(r *http.Request) { r.Header.Set(signatureDateHeader, "20260302T100001Z") }},
+		{"nonce", func(r *http.Request) { r.Header.Set(signatureNonceHeader, "fedcba9876543210") }},
+	}
+	if got := signString("secret", stringToSign(newRequest(), signed)); got != signature {
+		t.Fatalf("signature of an unchanged request = %s; want %s", got, signature)
+	}
+	if got := signString("other", stringToSign(newRequest(), signed)); got == signature {
+		t.Errorf("signature with another secret matches")
+	}
+	// unsigned headers can change
+	r := newRequest()
+	r.Header.Set("User-Agent", "curl")
+	if got := signString("secret", stringToSign(r, signed)); got != signature {
+		t.Errorf("signature changed with an unsigned header")
+	}
+	for _, tt := range tests {
+		r := newRequest()
+		tt.tamper(r)
+		if got := signString("secret", stringToSign(r, signed)); got == signature {
+			t.Errorf("signature does not cover the %s", tt.name)
+		}
+	}
+}
+
+func TestVerifySignedBody(t *testing.T) {
+	body := `{"name":"report.pdf"}`
+	digest := sha256.Sum256([]byte(body))
+	bodyHash := hex.EncodeToString(digest[:])
+	tests := []struct {
+		name          string
+		contentLength int64
+		contentHash   string
+		matches       bool
+		readErr       error
+	}{
+		{"buffered", int64(len(body)), bodyHash, true, nil},
+		{"buffered mismatch", int64(len(body)), emptyBodyHash, false, nil},
+		// chunked bodies are checked when read to the end
+		{"streamed", -1, bodyHash, true, nil},
+		{"streamed mismatch", -1, emptyBodyHash, true, errSignedBodyMismatch},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/projects/1/files", strings.NewReader(body))
+		r.ContentLength = tt.contentLength
+		matches, err := verifySignedBody(r, tt.contentHash)
+		if err != nil || matches != tt.matches {
+			t.Errorf("%s: verifySignedBody() = %t, %v; want %t", tt.name, matches, err, tt.matches)
+			continue
+		}
+		read, err := io.ReadAll(r.Body)
+		if !errors.Is(err, tt.readErr) {
+			t.Errorf("%s: reading the body returned %v; want %v", tt.name, err, tt.readErr)
+		}
+		if tt.readErr == nil && string(read) != body {
+			t.Errorf("%s: handler read %q; want %q", tt.name, read, body)
+		}
+	}
+}
+
+func TestUploadVerifiesLargeSignedBody(t *testing.T) {
+	alice := uuid.New()
+	project := &models.Project{ID: uuid.New(), OwnerID: alice, Bucket: "alice"}
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	part, _ := writer.CreateFormFile("file", "backup.tar")
+	part.Write(bytes.Repeat([]byte("sgs"), maxBufferedSignedBody))
+	writer.Close()
+	digest := sha256.Sum256(form.Bytes())
+	formHash := hex.EncodeToString(digest[:])
+
+	tests := []struct {
+		name string
+		body []byte
+		// the upload is denied before reaching the store when the body does not match
+		want int
+	}{
+		{"signed body", form.Bytes(), http.StatusBadRequest},
+		{"tampered file", bytes.Replace(form.Bytes(), []byte("sgs"), []byte("xyz"), 1), http.StatusUnauthorized},
+		// the multipart reader stops at the closing boundary, before data appended to the body
+		{"appended data", append(slices.Clone(form.Bytes()), "appended"...), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tenant := &tenantStore{db: sql.OpenDB(&txDB{}), project: project}
+		files := &FileHandler{fileRepo: tenant, projectRepo: tenant, store: tenant}
+
+		ctx := context.WithValue(context.Background(), UserIDKey, alice)
+		r := httptest.NewRequest(http.MethodPost, "/api/projects/"+project.ID.String()+"/files", bytes.NewReader(tt.body)).WithContext(ctx)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		r = mux.SetURLVars(r, map[string]string{"id": project.ID.String()})
+		if _, err := verifySignedBody(r, formHash); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		files.UploadFile(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d; want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+		// the fake store fails the creation of the file, which only a verified body reaches
+		if reached := len(tenant.reached) > 0; reached != (tt.want != http.StatusUnauthorized) {
+			t.Errorf("%s: reached the store: %v", tt.name, tenant.reached)
+		}
+	}
+}
+
+// digest of an empty body
+const emptyBodyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"sgs/internal/models"
+	"sgs/internal/utils"
+	"slices"
+	"time"
+)
+
+// an api key cannot be restricted to more CIDRs than this
+const maxAPIKeyCIDRs = 50
+
+// normalizeRestrictions validates the restrictions of a key. CIDRs are stored masked, days sorted and deduplicated, and keys restricted
+// in time without a time zone use UTC
+func normalizeRestrictions(restrictions *models.APIKeyRestrictions) error {
+	if len(restrictions.AllowedCIDRs) > maxAPIKeyCIDRs {
+		return fmt.Errorf("at most %d CIDRs can be allowed", maxAPIKeyCIDRs)
+	}
+	cidrs := []string{}
+	for _, cidr := range restrictions.AllowedCIDRs {
+		prefix, err := utils.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		if !slices.Contains(cidrs, prefix.String()) {
+			cidrs = append(cidrs, prefix.String())
+		}
+	}
+	restrictions.AllowedCIDRs = cidrs
+
+	days := []int{}
+	for _, day := range restrictions.AllowedDays {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("allowed days must be between 0 for Sunday and 6 for Saturday")
+		}
+		if !slices.Contains(days, day) {
+			days = append(days, day)
+		}
+	}
+	slices.Sort(days)
+	restrictions.AllowedDays = days
+
+	if hours := restrictions.AllowedHours; hours != nil {
+		if hours.Start < 0 || hours.Start > 23 || hours.End < 1 || hours.End > 24 || hours.Start == hours.End%24 {
+			return fmt.Errorf("allowed hours must start between 0 and 23, end between 1 and 24 and not span the whole day")
+		}
+	}
+
+	if len(restrictions.AllowedDays) == 0 && restrictions.AllowedHours == nil {
+		restrictions.Timezone = ""
+		return nil
+	}
+	if restrictions.Timezone == "" {
+		restrictions.Timezone = "UTC"
+	}
+	if _, err := loadTimezone(restrictions.Timezone); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkAPIKeyRestrictions returns the reason a request from an address at a time is denied by the restrictions of a key, or an empty
+// string when it is allowed
+func checkAPIKeyRestrictions(restrictions models.APIKeyRestrictions, ip string, now time.Time) string {
+	if len(restrictions.AllowedCIDRs) > 0 {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return models.DenialIP
+		}
+		prefixes := make([]netip.Prefix, 0, len(restrictions.AllowedCIDRs))
+		for _, cidr := range restrictions.AllowedCIDRs {
+			if prefix, err := utils.ParsePrefix(cidr); err == nil {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		if !utils.PrefixesContain(prefixes, addr) {
+			return models.DenialIP
+		}
+	}
+
+	if len(restrictions.AllowedDays) == 0 && restrictions.AllowedHours == nil {
+		return ""
+	}
+	location, err := loadTimezone(restrictions.Timezone)
+	if err != nil {
+		return models.DenialHour
+	}
+	local := now.In(location)
+	if len(restrictions.AllowedDays) > 0 && !slices.Contains(restrictions.AllowedDays, int(local.Weekday())) {
+		return models.DenialDay
+	}
+	if hours := restrictions.AllowedHours; hours != nil {
+		hour := local.Hour()
+		allowed := hour >= hours.Start && hour < hours.End
+		if hours.End < hours.Start {
+			// the window spans midnight
+			allowed = hour >= hours.Start || hour < hours.End
+		}
+		if !allowed {
+			return models.DenialHour
+		}
+	}
+	return ""
+}
+
+// unrestricted reports whether normalized restrictions allow any address at any time
+func unrestricted(restrictions models.APIKeyRestrictions) bool {
+	return len(restrictions.AllowedCIDRs) == 0 && len(restrictions.AllowedDays) == 0 && restrictions.AllowedHours == nil
+}
+
+// restrictionsWithin reports whether normalized restrictions allow no address, day or hour that the limit denies, so that a key with
+// the restrictions cannot be used where a key with the limit cannot
+func restrictionsWithin(restrictions, limit models.APIKeyRestrictions) bool {
+	if len(limit.AllowedCIDRs) > 0 {
+		if len(restrictions.AllowedCIDRs) == 0 {
+			return false
+		}
+		for _, cidr := range restrictions.AllowedCIDRs {
+			prefix, err := utils.ParsePrefix(cidr)
+			if err != nil {
+				return false
+			}
+			contained := false
+			for _, limitCIDR := range limit.AllowedCIDRs {
+				limitPrefix, err := utils.ParsePrefix(limitCIDR)
+				if err == nil && limitPrefix.Bits() <= prefix.Bits() && limitPrefix.Contains(prefix.Addr()) {
+					contained = true
+					break
+				}
+			}
+			if !contained {
+				return false
+			}
+		}
+	}
+
+	if len(limit.AllowedDays) == 0 && limit.AllowedHours == nil {
+		return true
+	}
+	// days and hours are only comparable in the same time zone
+	if restrictions.Timezone != limit.Timezone {
+		return false
+	}
+	if len(limit.AllowedDays) > 0 {
+		if len(restrictions.AllowedDays) == 0 {
+			return false
+		}
+		for _, day := range restrictions.AllowedDays {
+			if !slices.Contains(limit.AllowedDays, day) {
+				return false
+			}
+		}
+	}
+	if limit.AllowedHours != nil {
+		if restrictions.AllowedHours == nil {
+			return false
+		}
+		allowed, limitAllowed := allowedHours(*restrictions.AllowedHours), allowedHours(*limit.AllowedHours)
+		for hour := range allowed {
+			if allowed[hour] && !limitAllowed[hour] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// allowedHours returns whether each hour of the day is in a window of hours
+func allowedHours(hours models.APIKeyHours) [24]bool {
+	var allowed [24]bool
+	for hour := hours.Start; hour%24 != hours.End%24; hour++ {
+		allowed[hour%24] = true
+	}
+	return allowed
+}
+
+// loadTimezone loads an IANA time zone. The local time zone of the server is rejected since it depends on the deployment
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if name == "Local" {
+		return nil, fmt.Errorf("invalid timezone %q", name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", name)
+	}
+	return location, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"sgs/internal/models"
+)
+
+func TestNormalizeRestrictions(t *testing.T) {
+	tests := []struct {
+		restrictions models.APIKeyRestrictions
+		valid        bool
+	}{
+		{models.APIKeyRestrictions{}, true},
+		{models.APIKeyRestrictions{AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::1"}}, true},
+		{models.APIKeyRestrictions{AllowedCIDRs: []string{"10.0.0.0/33"}}, false},
+		{models.APIKeyRestrictions{AllowedDays: []int{1, 5}, Timezone: "Europe/Paris"}, true},
+		{models.APIKeyRestrictions{AllowedDays: []int{7}}, false},
+		{models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 22, End: 6}}, true},
+		{models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 8, End: 24}}, true},
+		// a window of the whole day restricts nothing
+		{models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 0, End: 24}}, false},
+		{models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 9, End: 25}}, false},
+		{models.APIKeyRestrictions{AllowedDays: []int{1}, Timezone: "Local"}, false},
+		{models.APIKeyRestrictions{AllowedDays: []int{1}, Timezone: "Mars/Olympus_Mons"}, false},
+	}
+	for _, tt := range tests {
+		restrictions := tt.restrictions
+		if err := normalizeRestrictions(&restrictions); (err == nil) != tt.valid {
+			t.Errorf("normalizeRestrictions(%+v) = %v; want valid %t", tt.restrictions, err, tt.valid)
+		}
+	}
+}
+
+func TestCheckAPIKeyRestrictions(t *testing.T) {
+	// a Monday
+	monday := time.Date(2026, time.March, 2, 10, 0, 0, 0, time.UTC)
+	runners := []string{"10.20.0.0/16", "2001:db8::/32"}
+	office := &models.APIKeyHours{Start: 9, End: 17}
+	night := &models.APIKeyHours{Start: 22, End: 6}
+	tests := []struct {
+		restrictions models.APIKeyRestrictions
+		ip           string
+		now          time.Time
+		want         string
+	}{
+		{models.APIKeyRestrictions{}, "203.0.113.5", monday, ""},
+		{models.APIKeyRestrictions{AllowedCIDRs: runners}, "10.20.3.4", monday, ""},
+		{models.APIKeyRestrictions{AllowedCIDRs: runners}, "::ffff:10.20.3.4", monday, ""},
+		{models.APIKeyRestrictions{AllowedCIDRs: runners}, "2001:db8::7", monday, ""},
+		{models.APIKeyRestrictions{AllowedCIDRs: runners}, "10.21.3.4", monday, models.DenialIP},
+		{models.APIKeyRestrictions{AllowedCIDRs: runners}, "not-an-ip", monday, models.DenialIP},
+		{models.APIKeyRestrictions{AllowedDays: []int{1, 2, 3, 4, 5}}, "203.0.113.5", monday, ""},
+		{models.APIKeyRestrictions{AllowedDays: []int{0, 6}}, "203.0.113.5", monday, models.DenialDay},
+		// late on Sunday in UTC is already Monday in Tokyo
+		{models.APIKeyRestrictions{AllowedDays: []int{1}, Timezone: "Asia/Tokyo"}, "203.0.113.5", monday.Add(-12 * time.Hour), ""},
+		{models.APIKeyRestrictions{AllowedHours: office}, "203.0.113.5", monday, ""},
+		{models.APIKeyRestrictions{AllowedHours: office}, "203.0.113.5", monday.Add(7 * time.Hour), models.DenialHour},
+		{models.APIKeyRestrictions{AllowedHours: office, Timezone: "America/New_York"}, "203.0.113.5", monday, models.DenialHour},
+		// windows ending before they start span midnight
+		{models.APIKeyRestrictions{AllowedHours: night}, "203.0.113.5", monday.Add(13 * time.Hour), ""},
+		{models.APIKeyRestrictions{AllowedHours: night}, "203.0.113.5", monday.Add(-7 * time.Hour), ""},
+		{models.APIKeyRestrictions{AllowedHours: night}, "203.0.113.5", monday, models.DenialHour},
+		// the address is checked before the time
+		{models.APIKeyRestrictions{AllowedCIDRs: runners, AllowedHours: night}, "10.21.3.4", monday, models.DenialIP},
+	}
+	for _, tt := range tests {
+		if got := checkAPIKeyRestrictions(tt.restrictions, tt.ip, tt.now); got != tt.want {
+			t.Errorf("checkAPIKeyRestrictions(%+v, %s, %s) = %q; want %q", tt.restrictions, tt.ip, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestRestrictionsWithin(t *testing.T) {
+	runners := []string{"10.20.0.0/16", "2001:db8::/32"}
+	office := &models.APIKeyHours{Start: 9, End: 17}
+	night := &models.APIKeyHours{Start: 22, End: 6}
+	tests := []struct {
+		restrictions models.APIKeyRestrictions
+		limit        models.APIKeyRestrictions
+		want         bool
+	}{
+		{models.APIKeyRestrictions{}, models.APIKeyRestrictions{}, true},
+		{models.APIKeyRestrictions{AllowedCIDRs: runners}, models.APIKeyRestrictions{}, true},
+		{models.APIKeyRestrictions{}, models.APIKeyRestrictions{AllowedCIDRs: runners}, false},
+		{models.APIKeyRestrictions{AllowedCIDRs: []string{"10.20.3.0/24", "2001:db8::7/128"}}, models.APIKeyRestrictions{AllowedCIDRs: runners}, true},
+		{models.APIKeyRestrictions{AllowedCIDRs: []string{"10.0.0.0/8"}}, models.APIKeyRestrictions{AllowedCIDRs: runners}, false},
+		{models.APIKeyRestrictions{AllowedCIDRs: []string{"10.20.3.0/24", "10.21.0.0/24"}}, models.APIKeyRestrictions{AllowedCIDRs: runners}, false},
+		{
+			models.APIKeyRestrictions{AllowedDays: []int{1, 2}, Timezone: "UTC"},
+			models.APIKeyRestrictions{AllowedDays: []int{1, 2, 3}, Timezone: "UTC"}, true,
+		},
+		{
+			models.APIKeyRestrictions{AllowedDays: []int{0, 1}, Timezone: "UTC"},
+			models.APIKeyRestrictions{AllowedDays: []int{1, 2, 3}, Timezone: "UTC"}, false,
+		},
+		{
+			models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 10, End: 12}, Timezone: "UTC"},
+			models.APIKeyRestrictions{AllowedHours: office, Timezone: "UTC"}, true,
+		},
+		{
+			models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 16, End: 18}, Timezone: "UTC"},
+			models.APIKeyRestrictions{AllowedHours: office, Timezone: "UTC"}, false,
+		},
+		// windows spanning midnight are compared hour by hour
+		{
+			models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 23, End: 2}, Timezone: "UTC"},
+			models.APIKeyRestrictions{AllowedHours: night, Timezone: "UTC"}, true,
+		},
+		{
+			models.APIKeyRestrictions{AllowedHours: &models.APIKeyHours{Start: 2, End: 8}, Timezone: "UTC"},
+			models.APIKeyRestrictions{AllowedHours: night, Timezone: "UTC"}, false,
+		},
+		// the same hours in another time zone are other hours
+		{
+			models.APIKeyRestrictions{AllowedHours: office, Timezone: "Asia/Tokyo"},
+			models.APIKeyRestrictions{AllowedHours: office, Timezone: "UTC"}, false,
+		},
+		{
+			models.APIKeyRestrictions{AllowedCIDRs: runners},
+			models.APIKeyRestrictions{AllowedHours: office, Timezone: "UTC"}, false,
+		},
+	}
+	for _, tt := range tests {
+		if got := restrictionsWithin(tt.restrictions, tt.limit); got != tt.want {
+			t.Errorf("restrictionsWithin(%+v, %+v) = %t; want %t", tt.restrictions, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -10,8 +10,15 @@ import (
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
+	// Wrap the router with CORS middleware
+	return s.corsMiddleware(s.router())
+}
+
+// router registers the routes and their handlers
+func (s *Server) router() *mux.Router {
 	// setup router
-	r := mux.NewRouter()
+	router := mux.NewRouter()
+	r := router
 
 	// setup repos and handlers
 	userRepo := repository.NewUserRepository(s.db.DB)
@@ -19,12 +26,36 @@ func (s *Server) RegisterRoutes() http.Handler {
 	fileRepo := repository.NewFileRepository(s.db.DB)
 	dashboardRepo := repository.NewDashboardRepository(s.db.DB)
 	apiKeyRepo := repository.NewAPIKeyRepository(s.db.DB)
-
-	authHandler := NewAuthHandler(s.cfg, userRepo, apiKeyRepo)
-	projectHandler := NewProjectHandler(projectRepo, s.store)
-	fileHandler := NewFileHandler(s.cfg, fileRepo, projectRepo, s.store)
+	importRepo := repository.NewImportRepository(s.db.DB)
+	jobRepo := repository.NewJobRepository(s.db.DB)
+	taskRepo := repository.NewScheduledTaskRepository(s.db.DB)
+	sessionRepo := repository.NewSessionRepository(s.db.DB)
+	resetRepo := repository.NewPasswordResetRepository(s.db.DB)
+	inviteRepo := repository.NewInviteRepository(s.db.DB)
+	verificationRepo := repository.NewEmailVerificationRepository(s.db.DB)
+	mfaRepo := repository.NewMFARepository(s.db.DB)
+	identityRepo := repository.NewIdentityRepository(s.db.DB)
+	oidcRepo := repository.NewOIDCRepository(s.db.DB)
+	loginFailureRepo := repository.NewLoginFailureRepository(s.db.DB)
+	tokenRepo := repository.NewPersonalAccessTokenRepository(s.db.DB)
+
+	authHandler := NewAuthHandler(s.cfg, userRepo, apiKeyRepo, sessionRepo, inviteRepo, verificationRepo, mfaRepo, identityRepo, oidcRepo,
+		s.mailer, s.oidcProvider, s.ldapAuth, s.keyRing, loginFailureRepo, tokenRepo, fileRepo, s.usage)
+	projectHandler := NewProjectHandler(s.cfg, projectRepo, s.store)
+	fileHandler := NewFileHandler(s.cfg, fileRepo, projectRepo, userRepo, s.store, s.keyRing)
 	dashboardHandler := NewDashboardHandler(dashboardRepo)
-	apiKeyHandler := NewAPIKeyHandler(apiKeyRepo)
+	apiKeyHandler := NewAPIKeyHandler(s.cfg, apiKeyRepo, projectRepo)
+	adminHandler := NewAdminHandler(s.importer, s.queue, importRepo, userRepo, sessionRepo, dashboardRepo)
+	jobHandler := NewJobHandler(jobRepo)
+	schedulerHandler := NewSchedulerHandler(s.scheduler, taskRepo)
+	sessionHandler := NewSessionHandler(sessionRepo)
+	inviteHandler := NewInviteHandler(inviteRepo)
+	passwordHandler := NewPasswordHandler(s.cfg, userRepo, sessionRepo, resetRepo, s.mailer)
+	tokenHandler := NewPersonalAccessTokenHandler(tokenRepo)
+	accountHandler := NewAccountHandler(s.cfg, userRepo, sessionRepo, repository.NewAccountExportRepository(s.db.DB), s.accounts, s.queue)
+
+	// public keys verifying the access tokens, served at the well-known path other services look them up at
+	r.HandleFunc("/.well-known/jwks.json", s.jwksHandler).Methods(http.MethodGet)
 
 	// api router
 	r = r.PathPrefix("/api").Subrouter()
@@ -36,6 +67,10 @@ func (s *Server) RegisterRoutes() http.Handler {
 	protected := r.NewRoute().Subrouter()
 	protected.Use(AuthMiddleware(authHandler))
 
+	// admin routes
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(AdminMiddleware(authHandler))
+
 	// Register routes
 	r.HandleFunc("/health", s.healthHandler).Methods(http.MethodGet)
 	r.HandleFunc("/ping", s.PingHandler).Methods(http.MethodGet)
@@ -43,6 +78,46 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// auth routes
 	r.HandleFunc("/auth/register", authHandler.Register).Methods(http.MethodPost)
 	r.HandleFunc("/auth/login", authHandler.Login).Methods(http.MethodPost)
+	r.HandleFunc("/auth/refresh", authHandler.Refresh).Methods(http.MethodPost)
+	r.HandleFunc("/auth/logout", authHandler.Logout).Methods(http.MethodPost)
+	r.HandleFunc("/auth/verify-email", authHandler.VerifyEmail).Methods(http.MethodPost)
+	r.HandleFunc("/auth/verify-email/resend", authHandler.ResendVerification).Methods(http.MethodPost)
+	// openid connect single sign-on. the provider redirects back to the callback
+	r.HandleFunc("/auth/oidc/login", authHandler.OIDCLogin).Methods(http.MethodGet)
+	r.HandleFunc("/auth/oidc/callback", authHandler.OIDCCallback).Methods(http.MethodGet)
+	r.HandleFunc("/auth/oidc/exchange", authHandler.OIDCExchange).Methods(http.MethodPost)
+	// two-factor authentication. enroll and verify complete a login with its challenge token
+	r.HandleFunc("/auth/2fa/enroll", authHandler.EnrollTOTP).Methods(http.MethodPost)
+	r.HandleFunc("/auth/2fa/verify", authHandler.VerifyMFA).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa", authHandler.GetMFAStatus).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/2fa/setup", authHandler.SetupTOTP).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/enable", authHandler.EnableTOTP).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/disable", authHandler.DisableTOTP).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes).Methods(http.MethodPost)
+	// password change and reset
+	protected.HandleFunc("/auth/password", passwordHandler.ChangePassword).Methods(http.MethodPut)
+	r.HandleFunc("/auth/password/forgot", passwordHandler.ForgotPassword).Methods(http.MethodPost)
+	r.HandleFunc("/auth/password/reset", passwordHandler.ResetPassword).Methods(http.MethodPost)
+	// session management. other sessions is registered first so that it is not matched as a session id
+	protected.HandleFunc("/auth/sessions", sessionHandler.GetSessions).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/sessions/others", sessionHandler.RevokeOtherSessions).Methods(http.MethodDelete)
+	protected.HandleFunc("/auth/sessions/{id}", sessionHandler.RevokeSession).Methods(http.MethodDelete)
+
+	// personal access tokens
+	protected.HandleFunc("/auth/tokens", tokenHandler.CreatePersonalAccessToken).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/tokens", tokenHandler.GetPersonalAccessTokens).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/tokens/{id}", tokenHandler.RevokePersonalAccessToken).Methods(http.MethodDelete)
+
+	// self-service profile, preferences, account export and deletion
+	protected.HandleFunc("/auth/me", accountHandler.GetProfile).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/me", accountHandler.UpdateProfile).Methods(http.MethodPut)
+	protected.HandleFunc("/auth/me", accountHandler.DeleteAccount).Methods(http.MethodDelete)
+	protected.HandleFunc("/auth/me/preferences", accountHandler.GetPreferences).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/me/preferences", accountHandler.UpdatePreferences).Methods(http.MethodPut)
+	protected.HandleFunc("/auth/me/exports", accountHandler.RequestExport).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/me/exports", accountHandler.GetExports).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/me/exports/{id}", accountHandler.GetExport).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/me/exports/{id}/download", accountHandler.DownloadExport).Methods(http.MethodGet)
 
 	// project routes
 	protected.HandleFunc("/projects", projectHandler.CreateProject).Methods(http.MethodPost)
@@ -73,9 +148,49 @@ func (s *Server) RegisterRoutes() http.Handler {
 	protected.HandleFunc("/api-keys/{id}", apiKeyHandler.GetAPIKey).Methods(http.MethodGet)
 	protected.HandleFunc("/api-keys/{id}", apiKeyHandler.DeleteAPIKey).Methods(http.MethodDelete)
 	protected.HandleFunc("/api-keys/{id}/revoke", apiKeyHandler.RevokeAPIKey).Methods(http.MethodPatch)
-
-	// Wrap the router with CORS middleware
-	return s.corsMiddleware(r)
+	protected.HandleFunc("/api-keys/{id}/rotate", apiKeyHandler.RotateAPIKey).Methods(http.MethodPost)
+	protected.HandleFunc("/api-keys/{id}/rotations", apiKeyHandler.GetAPIKeyRotations).Methods(http.MethodGet)
+	protected.HandleFunc("/api-keys/{id}/restrictions", apiKeyHandler.UpdateAPIKeyRestrictions).Methods(http.MethodPut)
+	protected.HandleFunc("/api-keys/{id}/denials", apiKeyHandler.GetAPIKeyDenials).Methods(http.MethodGet)
+
+	// background jobs
+	protected.HandleFunc("/jobs", jobHandler.GetUserJobs).Methods(http.MethodGet)
+	protected.HandleFunc("/jobs/{id}", jobHandler.GetUserJob).Methods(http.MethodGet)
+
+	// admin bucket adoption and imports
+	admin.HandleFunc("/buckets/unmanaged", adminHandler.GetUnmanagedBuckets).Methods(http.MethodGet)
+	admin.HandleFunc("/buckets/{bucket}/adopt", adminHandler.AdoptBucket).Methods(http.MethodPost)
+	admin.HandleFunc("/projects/{id}/imports", adminHandler.ImportExternal).Methods(http.MethodPost)
+	admin.HandleFunc("/imports", adminHandler.GetImports).Methods(http.MethodGet)
+	admin.HandleFunc("/imports/{id}", adminHandler.GetImport).Methods(http.MethodGet)
+	admin.HandleFunc("/imports/{id}/resume", adminHandler.ResumeImport).Methods(http.MethodPost)
+	// admin jobs
+	admin.HandleFunc("/jobs", jobHandler.GetJobs).Methods(http.MethodGet)
+	admin.HandleFunc("/jobs/{id}", jobHandler.GetJob).Methods(http.MethodGet)
+	admin.HandleFunc("/jobs/{id}/retry", jobHandler.RetryJob).Methods(http.MethodPost)
+	// admin registration invites
+	admin.HandleFunc("/invites", inviteHandler.CreateInvite).Methods(http.MethodPost)
+	admin.HandleFunc("/invites", inviteHandler.GetInvites).Methods(http.MethodGet)
+	admin.HandleFunc("/invites/{id}", inviteHandler.RevokeInvite).Methods(http.MethodDelete)
+	// admin user management
+	admin.HandleFunc("/users", adminHandler.GetUsers).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id}", adminHandler.GetUser).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id}", adminHandler.DeleteUser).Methods(http.MethodDelete)
+	admin.HandleFunc("/users/{id}/disable", adminHandler.DisableUser).Methods(http.MethodPost)
+	admin.HandleFunc("/users/{id}/enable", adminHandler.EnableUser).Methods(http.MethodPost)
+	admin.HandleFunc("/users/{id}/role", adminHandler.UpdateUserRole).Methods(http.MethodPut)
+	admin.HandleFunc("/users/{id}/password-reset", passwordHandler.ForcePasswordReset).Methods(http.MethodPost)
+	admin.HandleFunc("/stats", adminHandler.GetSystemStats).Methods(http.MethodGet)
+	// admin two-factor reset for users that lost their authenticator
+	admin.HandleFunc("/users/{id}/2fa", authHandler.ResetTOTP).Methods(http.MethodDelete)
+	// admin login locks
+	admin.HandleFunc("/login-locks", authHandler.GetLoginLocks).Methods(http.MethodGet)
+	admin.HandleFunc("/login-locks/ips/{ip}", authHandler.UnlockIP).Methods(http.MethodDelete)
+	admin.HandleFunc("/users/{id}/lock", authHandler.UnlockUser).Methods(http.MethodDelete)
+	// admin scheduled tasks
+	admin.HandleFunc("/scheduler/tasks", schedulerHandler.GetTasks).Methods(http.MethodGet)
+
+	return router
 }
 
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
@@ -83,7 +198,7 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-API-KEY, X-CSRF-Token")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-API-KEY, X-CSRF-Token, X-SGS-Content-SHA256, X-SGS-Date, X-SGS-Nonce")
 		w.Header().Set("Access-Control-Allow-Credentials", "false")
 
 		// Handle preflight OPTIONS requests
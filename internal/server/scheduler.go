@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+	"sgs/internal/scheduler"
+)
+
+// SchedulerHandler provides the status of the periodic tasks
+type SchedulerHandler struct {
+	scheduler *scheduler.Scheduler
+	taskRepo  *repository.ScheduledTaskRepository
+}
+
+// NewSchedulerHandler creates a new scheduler handler
+func NewSchedulerHandler(scheduler *scheduler.Scheduler, taskRepo *repository.ScheduledTaskRepository) *SchedulerHandler {
+	return &SchedulerHandler{
+		scheduler: scheduler,
+		taskRepo:  taskRepo,
+	}
+}
+
+// SchedulerStatus represents the scheduled tasks as seen by the replica serving the request
+type SchedulerStatus struct {
+	Instance string                  `json:"instance"`
+	Leader   bool                    `json:"leader"`
+	Tasks    []*models.ScheduledTask `json:"tasks"`
+}
+
+// GetTasks retrieves the scheduled tasks along with their last run times and results
+func (s *SchedulerHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := s.taskRepo.GetTasks(r.Context())
+	if err != nil {
+		log.Printf("failed to retrieve scheduled tasks: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve scheduled tasks"})
+		return
+	}
+
+	status := SchedulerStatus{Instance: s.scheduler.ID(), Leader: s.scheduler.IsLeader(), Tasks: tasks}
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Scheduled tasks retrieved successfully", Data: status})
+}
+
+func (s *SchedulerHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sgs/internal/authz"
+	"sgs/internal/models"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+// kinds of resources named by the id path variable of the routes open to api keys
+const (
+	resourceProject = "project"
+	resourceFile    = "file"
+)
+
+// apiKeyRoute describes a route open to api keys
+type apiKeyRoute struct {
+	// action of the route, which the key must carry the scope of
+	Action authz.Action
+	// kind of resource in the path, which must belong to the project of the key
+	Resource string
+}
+
+// apiKeyRoutes lists the routes open to api keys by method and path template. Every other route rejects api keys
+var apiKeyRoutes = map[string]apiKeyRoute{
+	"GET /api/projects/{id}":            {Action: authz.ActionReadProject, Resource: resourceProject},
+	"POST /api/projects/{id}/files":     {Action: authz.ActionUploadFile, Resource: resourceProject},
+	"GET /api/projects/{id}/files/meta": {Action: authz.ActionListFiles, Resource: resourceProject},
+	"POST /api/projects/{id}/api-keys":  {Action: authz.ActionManageAPIKeys, Resource: resourceProject},
+	"GET /api/projects/{id}/api-keys":   {Action: authz.ActionManageAPIKeys, Resource: resourceProject},
+	"GET /api/files/{id}":               {Action: authz.ActionReadFile, Resource: resourceFile},
+	"GET /api/files/{id}/download":      {Action: authz.ActionReadFile, Resource: resourceFile},
+	"DELETE /api/files/{id}":            {Action: authz.ActionDeleteFile, Resource: resourceFile},
+	"POST /api/files/{id}/share":        {Action: authz.ActionShareFile, Resource: resourceFile},
+}
+
+// apiKeyRouteFor returns the description of the matched route of a request when it is open to api keys
+func apiKeyRouteFor(r *http.Request) (apiKeyRoute, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return apiKeyRoute{}, false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return apiKeyRoute{}, false
+	}
+	keyRoute, ok := apiKeyRoutes[r.Method+" "+template]
+	return keyRoute, ok
+}
+
+// normalizeScopes validates the scopes requested for a key and returns them deduplicated in a stable order. Keys created without scopes
+// get [models.DefaultAPIKeyScopes]
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return slices.Clone(models.DefaultAPIKeyScopes), nil
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	normalized := []string{}
+	for _, scope := range models.APIKeyScopes {
+		if slices.Contains(scopes, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
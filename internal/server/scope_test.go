@@ -0,0 +1,42 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"sgs/internal/authz"
+	"sgs/internal/models"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		want   []string
+		valid  bool
+	}{
+		{nil, models.DefaultAPIKeyScopes, true},
+		{[]string{models.ScopeFilesRead}, []string{models.ScopeFilesRead}, true},
+		// scopes are deduplicated and ordered
+		{[]string{models.ScopeKeysManage, models.ScopeFilesRead, models.ScopeKeysManage}, []string{models.ScopeFilesRead, models.ScopeKeysManage}, true},
+		{[]string{models.ScopeFilesRead, "files:admin"}, nil, false},
+		{[]string{""}, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeScopes(tt.scopes)
+		if (err == nil) != tt.valid {
+			t.Errorf("normalizeScopes(%v) error = %v; want valid %t", tt.scopes, err, tt.valid)
+			continue
+		}
+		if tt.valid && !slices.Equal(got, tt.want) {
+			t.Errorf("normalizeScopes(%v) = %v; want %v", tt.scopes, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyRoutesHaveScopes(t *testing.T) {
+	for route, keyRoute := range apiKeyRoutes {
+		if _, ok := authz.APIKeyScope(keyRoute.Action); !ok {
+			t.Errorf("route %s is open to api keys but action %s is not", route, keyRoute.Action)
+		}
+	}
+}
@@ -1,45 +1,164 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"sgs/internal/accounts"
 	"sgs/internal/config"
 	"sgs/internal/database"
+	"sgs/internal/importer"
+	"sgs/internal/jobs"
+	"sgs/internal/ldapauth"
+	"sgs/internal/listener"
+	"sgs/internal/mailer"
+	"sgs/internal/oidc"
+	"sgs/internal/repository"
+	"sgs/internal/scheduler"
+	"sgs/internal/signing"
 	"sgs/internal/store"
+	"sgs/internal/usage"
 )
 
 type Server struct {
-	cfg   *config.Config
-	db    *database.DB
-	store *store.Store
+	cfg       *config.Config
+	db        *database.DB
+	store     *store.Store
+	importer  *importer.Importer
+	accounts  *accounts.Manager
+	queue     *jobs.Queue
+	scheduler *scheduler.Scheduler
+	mailer    mailer.Mailer
+	// nil when single sign-on is not configured
+	oidcProvider *oidc.Provider
+	// nil when ldap is not configured
+	ldapAuth *ldapauth.Authenticator
+	keyRing  *signing.KeyRing
+	usage    *usage.Tracker
 }
 
-func NewServer() (*http.Server, error) {
+// retired access keys keep verifying for longer than the access tokens they signed live, including tokens signed by replicas that
+// have not reloaded the keys yet
+const accessKeyRetention = time.Hour
+
+// NewServer sets up the http server along with the background workers. The workers should be started and stopped together with the
+// http server
+func NewServer() (*http.Server, *Workers, error) {
 	// get config
 	cfg, err := config.New()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// connect to store
 	store, err := store.New(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// connect to db
 	db, err := database.New(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// setup mail delivery
+	mailer, err := mailer.New(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// setup single sign-on
+	var oidcProvider *oidc.Provider
+	if cfg.OIDCIssuerURL != "" {
+		oidcProvider = oidc.New(cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, cfg.OIDCScopes)
+	}
+
+	// setup ldap authentication
+	var ldapAuth *ldapauth.Authenticator
+	if cfg.LDAPURL != "" {
+		ldapAuth = ldapauth.New(ldapauth.Config{
+			URL:                cfg.LDAPURL,
+			StartTLS:           cfg.LDAPStartTLS,
+			InsecureSkipVerify: cfg.LDAPInsecureSkipVerify,
+			BindDN:             cfg.LDAPBindDN,
+			BindPassword:       cfg.LDAPBindPassword,
+			BaseDN:             cfg.LDAPBaseDN,
+			UserFilter:         cfg.LDAPUserFilter,
+			IDAttribute:        cfg.LDAPIDAttribute,
+			UsernameAttribute:  cfg.LDAPUsernameAttribute,
+			EmailAttribute:     cfg.LDAPEmailAttribute,
+			NameAttribute:      cfg.LDAPNameAttribute,
+			RoleFilters:        cfg.LDAPRoleFilters,
+		})
+	}
+
+	// setup jwt signing keys
+	signingKeyRepo := repository.NewSigningKeyRepository(db.DB)
+	keyRing := signing.New(signingKeyRepo, signing.Config{
+		Algorithm: cfg.JWTAlgorithm,
+		Secret:    cfg.JwtSecret,
+		Retention: map[string]time.Duration{signing.PurposeAccess: accessKeyRetention, signing.PurposeShare: cfg.ShareKeyRetention},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := keyRing.Init(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize signing keys: %w", err)
 	}
 
+	projectRepo := repository.NewProjectRepository(db.DB)
+	fileRepo := repository.NewFileRepository(db.DB)
+	jobRepo := repository.NewJobRepository(db.DB)
+	userRepo := repository.NewUserRepository(db.DB)
+	apiKeyRepo := repository.NewAPIKeyRepository(db.DB)
+	sessionRepo := repository.NewSessionRepository(db.DB)
+	tokenRepo := repository.NewPersonalAccessTokenRepository(db.DB)
+
+	accountManager := accounts.New(userRepo, projectRepo, fileRepo, apiKeyRepo, sessionRepo, tokenRepo, repository.NewAccountExportRepository(db.DB),
+		store, cfg.ExportBucket, cfg.ExportRetention)
+
 	NewServer := &Server{
-		cfg:   cfg,
-		db:    db,
-		store: store,
+		cfg:       cfg,
+		db:        db,
+		store:     store,
+		importer:  importer.New(projectRepo, fileRepo, repository.NewImportRepository(db.DB), store, cfg.JwtSecret, cfg.ReservedBuckets()),
+		accounts:  accountManager,
+		queue:     jobs.New(jobRepo),
+		scheduler: scheduler.New(db.DB, repository.NewScheduledTaskRepository(db.DB)),
+		mailer:    mailer,
+
+		oidcProvider: oidcProvider,
+		ldapAuth:     ldapAuth,
+		keyRing:      keyRing,
+		usage:        usage.New(apiKeyRepo),
+	}
+
+	// register background job handlers
+	jobs.Register(NewServer.queue, importer.JobType, jobs.Options{WorkersPerProcess: 2, MaxAttempts: 5}, NewServer.importer.HandleJob)
+	jobs.Register(NewServer.queue, accounts.DeleteJobType, jobs.Options{WorkersPerProcess: 1, MaxAttempts: 5}, NewServer.accounts.HandleDelete)
+	jobs.Register(NewServer.queue, accounts.ExportJobType, jobs.Options{WorkersPerProcess: 1, MaxAttempts: 3}, NewServer.accounts.HandleExport)
+
+	// register periodic tasks
+	if err := registerTasks(NewServer.scheduler, taskDeps{
+		apiKeyRepo:       apiKeyRepo,
+		tokenRepo:        tokenRepo,
+		jobRepo:          jobRepo,
+		sessionRepo:      sessionRepo,
+		resetRepo:        repository.NewPasswordResetRepository(db.DB),
+		verificationRepo: repository.NewEmailVerificationRepository(db.DB),
+		mfaRepo:          repository.NewMFARepository(db.DB),
+		oidcRepo:         repository.NewOIDCRepository(db.DB),
+		loginFailureRepo: repository.NewLoginFailureRepository(db.DB),
+		signingKeyRepo:   signingKeyRepo,
+		dashboardRepo:    repository.NewDashboardRepository(db.DB),
+		keyRing:          keyRing,
+		keyRotation:      cfg.JWTKeyRotation,
+		accounts:         NewServer.accounts,
+	}); err != nil {
+		return nil, nil, err
 	}
 
 	// Declare Server config
@@ -51,5 +170,12 @@ func NewServer() (*http.Server, error) {
 		// WriteTimeout: 30 * time.Second,
 	}
 
-	return server, nil
+	workers := &Workers{
+		queue:     NewServer.queue,
+		scheduler: NewServer.scheduler,
+		listener:  listener.New(fileRepo, projectRepo, store),
+		usage:     NewServer.usage,
+	}
+
+	return server, workers, nil
 }
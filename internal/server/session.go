@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// SessionHandler provides management of the login sessions of a user
+type SessionHandler struct {
+	sessionRepo *repository.SessionRepository
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(sessionRepo *repository.SessionRepository) *SessionHandler {
+	return &SessionHandler{
+		sessionRepo: sessionRepo,
+	}
+}
+
+// GetSessions retrieves the active sessions of the logged-in user, marking the one making the request
+func (s *SessionHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, ok := s.getSession(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.sessionRepo.GetActiveSessionsByUserID(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to retrieve sessions: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to retrieve sessions"})
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == sessionID
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Sessions retrieved successfully", Data: sessions})
+}
+
+// RevokeSession ends a session of the logged-in user. Access tokens of the session are rejected from then on
+func (s *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := s.getSession(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		s.sendResponse(w, http.StatusUnprocessableEntity, models.APIResponse{Message: "Invalid session ID"})
+		return
+	}
+
+	if err := s.sessionRepo.RevokeUserSession(r.Context(), id, userID); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			s.sendResponse(w, http.StatusNotFound, models.APIResponse{Message: err.Error()})
+			return
+		}
+		log.Printf("failed to revoke session: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to revoke session"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Session revoked successfully"})
+}
+
+// RevokeOtherSessions ends every session of the logged-in user except the one making the request
+func (s *SessionHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, ok := s.getSession(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := s.sessionRepo.RevokeOtherSessions(r.Context(), userID, sessionID)
+	if err != nil {
+		log.Printf("failed to revoke sessions: %v\n", err)
+		s.sendResponse(w, http.StatusInternalServerError, models.APIResponse{Message: "Failed to revoke sessions"})
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, models.APIResponse{Message: "Other sessions revoked successfully", Data: map[string]int64{"revoked": revoked}})
+}
+
+// helper methods
+
+// getSession retrieves the user and session of the request. A response is sent and false is returned for requests without a session
+func (s *SessionHandler) getSession(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusUnauthorized, models.APIResponse{Message: "Unauthorized"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	sessionID, ok := GetSessionID(r)
+	if !ok {
+		s.sendResponse(w, http.StatusForbidden, models.APIResponse{Message: "Forbidden request"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, sessionID, true
+}
+
+func (s *SessionHandler) sendResponse(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sgs/internal/accounts"
+	"sgs/internal/repository"
+	"sgs/internal/scheduler"
+	"sgs/internal/signing"
+)
+
+const (
+	// inactive api keys and personal access tokens are kept for this long so that users can see why a key stopped working
+	apiKeyRetention = 30 * 24 * time.Hour
+	// denied api key requests are kept for this long to investigate misconfigured restrictions
+	apiKeyDenialRetention = 30 * 24 * time.Hour
+	// succeeded jobs are kept for this long for inspection. Dead jobs are kept until retried or removed
+	jobRetention = 7 * 24 * time.Hour
+	// failed logins are forgotten by the lockout policy long before this
+	loginFailureRetention = 24 * time.Hour
+)
+
+// taskDeps holds what the periodic maintenance tasks act on
+type taskDeps struct {
+	apiKeyRepo       *repository.APIKeyRepository
+	tokenRepo        *repository.PersonalAccessTokenRepository
+	jobRepo          *repository.JobRepository
+	sessionRepo      *repository.SessionRepository
+	resetRepo        *repository.PasswordResetRepository
+	verificationRepo *repository.EmailVerificationRepository
+	mfaRepo          *repository.MFARepository
+	oidcRepo         *repository.OIDCRepository
+	loginFailureRepo *repository.LoginFailureRepository
+	signingKeyRepo   *repository.SigningKeyRepository
+	dashboardRepo    *repository.DashboardRepository
+	keyRing          *signing.KeyRing
+	keyRotation      time.Duration
+	accounts         *accounts.Manager
+}
+
+// purgeTask is a periodic task deleting rows that are no longer needed
+type purgeTask struct {
+	name     string
+	schedule string
+	// what is purged, for the logs
+	rows  string
+	purge func(ctx context.Context) (int64, error)
+}
+
+// registerTasks adds the periodic maintenance tasks to the scheduler. Every purge is its own task, so that one failing does not skip
+// the others
+func registerTasks(sched *scheduler.Scheduler, deps taskDeps) error {
+	purges := []purgeTask{
+		{"api-keys.purge", "0 3 * * *", "expired or revoked api keys", func(ctx context.Context) (int64, error) {
+			return deps.apiKeyRepo.DeleteInactiveAPIKeys(ctx, time.Now().Add(-apiKeyRetention))
+		}},
+		{"personal-access-tokens.purge", "0 3 * * *", "expired or revoked personal access tokens", func(ctx context.Context) (int64, error) {
+			return deps.tokenRepo.DeleteInactivePersonalAccessTokens(ctx, time.Now().Add(-apiKeyRetention))
+		}},
+		{"api-keys.purge-denials", "0 3 * * *", "api key denials", func(ctx context.Context) (int64, error) {
+			return deps.apiKeyRepo.DeleteAPIKeyDenials(ctx, time.Now().Add(-apiKeyDenialRetention))
+		}},
+		{"api-keys.revoke-rotated", "*/5 * * * *", "rotated api keys at the end of their grace period", func(ctx context.Context) (int64, error) {
+			return deps.apiKeyRepo.RevokeRotatedAPIKeys(ctx, time.Now())
+		}},
+		{"api-keys.purge-nonces", "*/10 * * * *", "expired signed request nonces", func(ctx context.Context) (int64, error) {
+			return deps.apiKeyRepo.DeleteExpiredAPIKeyNonces(ctx, time.Now())
+		}},
+		{"jobs.purge", "30 3 * * *", "succeeded jobs", func(ctx context.Context) (int64, error) {
+			return deps.jobRepo.DeleteSucceededJobs(ctx, time.Now().Add(-jobRetention))
+		}},
+		{"account-exports.purge", "15 * * * *", "expired account exports", func(ctx context.Context) (int64, error) {
+			deleted, err := deps.accounts.PurgeExpiredExports(ctx)
+			return int64(deleted), err
+		}},
+		{"sessions.purge", "0 4 * * *", "expired sessions", func(ctx context.Context) (int64, error) {
+			return deps.sessionRepo.DeleteExpiredSessions(ctx, time.Now())
+		}},
+		{"password-resets.purge", "0 4 * * *", "expired password reset tokens", func(ctx context.Context) (int64, error) {
+			return deps.resetRepo.DeleteExpiredPasswordResets(ctx, time.Now())
+		}},
+		{"email-verifications.purge", "0 4 * * *", "expired email verification tokens", func(ctx context.Context) (int64, error) {
+			return deps.verificationRepo.DeleteExpiredEmailVerifications(ctx, time.Now())
+		}},
+		{"mfa-challenges.purge", "0 4 * * *", "expired two-factor challenges", func(ctx context.Context) (int64, error) {
+			return deps.mfaRepo.DeleteExpiredMFAChallenges(ctx, time.Now())
+		}},
+		{"oidc.purge", "0 4 * * *", "expired single sign-on states and login codes", func(ctx context.Context) (int64, error) {
+			return deps.oidcRepo.DeleteExpired(ctx, time.Now())
+		}},
+		{"login-failures.purge", "0 4 * * *", "stale login failures", func(ctx context.Context) (int64, error) {
+			return deps.loginFailureRepo.DeleteStaleLoginFailures(ctx, time.Now().Add(-loginFailureRetention))
+		}},
+	}
+	for _, task := range purges {
+		if err := sched.Register(task.name, task.schedule, func(ctx context.Context) error {
+			deleted, err := task.purge(ctx)
+			if deleted > 0 {
+				log.Printf("%s: purged %d %s\n", task.name, deleted, task.rows)
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	// snapshots are retaken every hour so that the last one of a day holds its final usage, even when some runs are missed
+	if err := sched.Register("storage-usage.snapshot", "0 * * * *", func(ctx context.Context) error {
+		_, err := deps.dashboardRepo.SnapshotStorageUsage(ctx, time.Now())
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return sched.Register("signing-keys.rotate", "0 2 * * *", func(ctx context.Context) error {
+		if _, err := deps.keyRing.RotateOlderThan(ctx, deps.keyRotation); err != nil {
+			return err
+		}
+		deleted, err := deps.signingKeyRepo.DeleteExpiredSigningKeys(ctx, time.Now())
+		if err == nil && deleted > 0 {
+			log.Printf("purged %d expired signing keys\n", deleted)
+		}
+		return err
+	})
+}
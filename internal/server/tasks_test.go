@@ -0,0 +1,14 @@
+package server
+
+import (
+	"testing"
+
+	"sgs/internal/scheduler"
+)
+
+func TestRegisterTasks(t *testing.T) {
+	// task names must be unique and schedules valid, or the server fails to start
+	if err := registerTasks(scheduler.New(nil, nil), taskDeps{}); err != nil {
+		t.Fatalf("registerTasks() = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"sgs/internal/jobs"
+	"sgs/internal/listener"
+	"sgs/internal/scheduler"
+	"sgs/internal/usage"
+)
+
+// Workers runs the background processing of the server: the job queue, the periodic task scheduler, the store notification listener
+// and the api key usage tracker
+type Workers struct {
+	queue     *jobs.Queue
+	scheduler *scheduler.Scheduler
+	listener  *listener.Listener
+	usage     *usage.Tracker
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start launches the background workers
+func (w *Workers) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.queue.Start()
+
+	// keep files in sync with objects written directly to the store
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.listener.Run(ctx)
+	}()
+
+	// write the usage of api keys in batches
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.usage.Run(ctx)
+	}()
+
+	// run periodic tasks when this replica is elected leader
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.scheduler.Run(ctx)
+	}()
+}
+
+// Stop stops the background workers, waiting for jobs in progress until the context is done
+func (w *Workers) Stop(ctx context.Context) error {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	err := w.queue.Stop(ctx)
+	w.wg.Wait()
+	return err
+}
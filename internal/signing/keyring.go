@@ -0,0 +1,265 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+)
+
+const (
+	// interval at which replicas reload the keys to pick up rotations by the leader
+	keyRefreshInterval = time.Minute
+	// minimum delay between reloads caused by tokens with an unknown key id
+	unknownKeyReloadInterval = 10 * time.Second
+)
+
+// Config holds the settings of a key ring
+type Config struct {
+	// algorithm of new keys, EdDSA or RS256
+	Algorithm string
+	// passphrase sealing the private keys. Legacy HS256 share tokens are verified with it as well
+	Secret string
+	// how long retired keys of each purpose keep verifying tokens
+	Retention map[string]time.Duration
+}
+
+// KeyRing signs and verifies the JWTs of each purpose with keys stored in the database. Every token carries the id of its key in the
+// kid header, so that rotated keys keep verifying the tokens they signed while new tokens are signed with the newest key
+type KeyRing struct {
+	repo *repository.SigningKeyRepository
+	cfg  Config
+	// loads the unexpired keys
+	source func(ctx context.Context) ([]*models.SigningKey, error)
+
+	mu         sync.RWMutex
+	keys       map[string]*key
+	loadedAt   time.Time
+	reloadedAt time.Time
+}
+
+// New creates a new key ring. [KeyRing.Init] should be called before it signs tokens
+func New(repo *repository.SigningKeyRepository, cfg Config) *KeyRing {
+	return &KeyRing{repo: repo, cfg: cfg, source: repo.GetSigningKeys, keys: map[string]*key{}}
+}
+
+// Init creates the first key of every purpose, or replaces the active keys when the configured algorithm changed, and loads the keys
+func (k *KeyRing) Init(ctx context.Context) error {
+	for _, purpose := range Purposes {
+		_, err := k.rotate(ctx, purpose, func(active *models.SigningKey) bool { return active.Algorithm != k.cfg.Algorithm })
+		if err != nil {
+			return err
+		}
+	}
+	return k.load(ctx)
+}
+
+// Rotate replaces the active key of a purpose. The previous key keeps verifying tokens for the retention of the purpose
+func (k *KeyRing) Rotate(ctx context.Context, purpose string) error {
+	_, err := k.rotate(ctx, purpose, func(*models.SigningKey) bool { return true })
+	return err
+}
+
+// RotateOlderThan replaces the active keys created more than maxAge ago and returns the purposes whose key was replaced
+func (k *KeyRing) RotateOlderThan(ctx context.Context, maxAge time.Duration) ([]string, error) {
+	rotated := []string{}
+	for _, purpose := range Purposes {
+		ok, err := k.rotate(ctx, purpose, func(active *models.SigningKey) bool { return time.Since(active.CreatedAt) >= maxAge })
+		if err != nil {
+			return rotated, err
+		}
+		if ok {
+			rotated = append(rotated, purpose)
+		}
+	}
+	return rotated, nil
+}
+
+// rotate replaces the active key of a purpose when there is none or due reports that it should be replaced. The check runs under a
+// lock so that replicas rotating together create a single key
+func (k *KeyRing) rotate(ctx context.Context, purpose string, due func(active *models.SigningKey) bool) (bool, error) {
+	tx, err := k.repo.GetTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := k.repo.LockSigningKeys(ctx, tx); err != nil {
+		return false, err
+	}
+	active, err := k.repo.GetActiveSigningKey(ctx, tx, purpose)
+	if err != nil && !errors.Is(err, repository.ErrSigningKeyNotFound) {
+		return false, err
+	}
+	if active != nil && !due(active) {
+		return false, nil
+	}
+
+	record, err := generateKey(purpose, k.cfg.Algorithm, k.cfg.Secret)
+	if err != nil {
+		return false, err
+	}
+	if err := k.repo.RetireSigningKeys(ctx, tx, purpose, time.Now().Add(k.cfg.Retention[purpose])); err != nil {
+		return false, err
+	}
+	if err := k.repo.CreateSigningKey(ctx, tx, record); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	log.Printf("rotated %s signing key to %s\n", purpose, record.ID)
+	return true, k.load(ctx)
+}
+
+// load replaces the cached keys with the stored ones
+func (k *KeyRing) load(ctx context.Context) error {
+	records, err := k.source(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*key, len(records))
+	for _, record := range records {
+		parsed, err := parseKey(record, k.cfg.Secret)
+		if err != nil {
+			log.Printf("skipping signing key: %v\n", err)
+			continue
+		}
+		keys[parsed.id] = parsed
+	}
+
+	k.mu.Lock()
+	k.keys, k.loadedAt = keys, time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// refresh reloads the keys when the cache is stale. Failed reloads keep the cached keys
+func (k *KeyRing) refresh(ctx context.Context) {
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > keyRefreshInterval
+	k.mu.RUnlock()
+	if !stale {
+		return
+	}
+	if err := k.load(ctx); err != nil {
+		log.Printf("failed to reload signing keys: %v\n", err)
+	}
+}
+
+// lookup returns an unexpired key by id. Unknown ids reload the keys, at most once per interval, since they may belong to a key
+// created by another replica
+func (k *KeyRing) lookup(ctx context.Context, id string) *key {
+	k.mu.RLock()
+	found, reloadedAt := k.keys[id], k.reloadedAt
+	k.mu.RUnlock()
+	if found == nil && time.Since(reloadedAt) > unknownKeyReloadInterval {
+		k.mu.Lock()
+		k.reloadedAt = time.Now()
+		k.mu.Unlock()
+		if err := k.load(ctx); err != nil {
+			log.Printf("failed to reload signing keys: %v\n", err)
+		}
+		k.mu.RLock()
+		found = k.keys[id]
+		k.mu.RUnlock()
+	}
+	if found == nil || found.expired(time.Now()) {
+		return nil
+	}
+	return found
+}
+
+// active returns the newest key of a purpose that can sign
+func (k *KeyRing) active(purpose string) *key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var newest *key
+	for _, candidate := range k.keys {
+		if candidate.purpose != purpose || candidate.retired || candidate.signer == nil {
+			continue
+		}
+		if newest == nil || candidate.createdAt.After(newest.createdAt) {
+			newest = candidate
+		}
+	}
+	return newest
+}
+
+// Sign signs the claims with the active key of a purpose
+func (k *KeyRing) Sign(ctx context.Context, purpose string, claims jwt.Claims) (string, error) {
+	k.refresh(ctx)
+	signingKey := k.active(purpose)
+	if signingKey == nil {
+		return "", fmt.Errorf("%w for %s tokens", ErrNoSigningKey, purpose)
+	}
+
+	token := jwt.NewWithClaims(signingKey.method(), claims)
+	token.Header["kid"] = signingKey.id
+	return token.SignedString(signingKey.signer)
+}
+
+// Parse verifies a token signed for a purpose and returns its claims. Share tokens without a key id are verified as legacy HS256
+// tokens signed with the secret, so that links created before keys were introduced keep working until they expire
+func (k *KeyRing) Parse(ctx context.Context, purpose, tokenString string, options ...jwt.ParserOption) (jwt.MapClaims, error) {
+	k.refresh(ctx)
+
+	methods := []string{AlgorithmEdDSA, AlgorithmRS256}
+	if purpose == PurposeShare {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	options = append(options, jwt.WithValidMethods(methods))
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		if id == "" {
+			if purpose == PurposeShare && token.Method == jwt.SigningMethodHS256 {
+				return []byte(k.cfg.Secret), nil
+			}
+			return nil, ErrUnknownKey
+		}
+
+		verifyingKey := k.lookup(ctx, id)
+		if verifyingKey == nil || verifyingKey.purpose != purpose || verifyingKey.algorithm != token.Method.Alg() {
+			return nil, ErrUnknownKey
+		}
+		return verifyingKey.public, nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// JWKS returns the public parts of the unexpired keys of a purpose, newest first
+func (k *KeyRing) JWKS(ctx context.Context, purpose string) []JWK {
+	k.refresh(ctx)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []*key{}
+	now := time.Now()
+	for _, candidate := range k.keys {
+		if candidate.purpose == purpose && !candidate.expired(now) {
+			keys = append(keys, candidate)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *key) int { return b.createdAt.Compare(a.createdAt) })
+
+	jwks := make([]JWK, 0, len(keys))
+	for _, jwkKey := range keys {
+		jwks = append(jwks, jwkKey.jwk())
+	}
+	return jwks
+}
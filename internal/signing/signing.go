@@ -0,0 +1,180 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"sgs/internal/models"
+	"sgs/internal/utils"
+)
+
+// errors
+var (
+	ErrUnknownAlgorithm = errors.New("unknown signing algorithm")
+	ErrNoSigningKey     = errors.New("no active signing key")
+	ErrUnknownKey       = errors.New("unknown signing key")
+)
+
+// signing algorithms
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+// key purposes. Tokens of one purpose never verify with the keys of another
+const (
+	// access tokens of users, published at the jwks endpoint
+	PurposeAccess = "access"
+	// share links of files
+	PurposeShare = "share"
+)
+
+// Purposes lists every key purpose
+var Purposes = []string{PurposeAccess, PurposeShare}
+
+// size of generated rsa keys
+const rsaKeyBits = 2048
+
+// key is a parsed signing key
+type key struct {
+	id        string
+	purpose   string
+	algorithm string
+	// nil when the key cannot sign, such as when its private key was sealed with another secret
+	signer    crypto.Signer
+	public    crypto.PublicKey
+	retired   bool
+	expiresAt *time.Time
+	createdAt time.Time
+}
+
+// JWK is the public part of a signing key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// rsa keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// generateKey creates a new key for a purpose. The private key is sealed with the secret for storage
+func generateKey(purpose, algorithm, secret string) (*models.SigningKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := utils.EncryptSecret(secret, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})))
+	if err != nil {
+		return nil, err
+	}
+	id, err := utils.GenerateToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		ID:         id,
+		Purpose:    purpose,
+		Algorithm:  algorithm,
+		PrivateKey: sealed,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}, nil
+}
+
+// parseKey parses a stored key. Keys whose private key cannot be opened with the secret are still returned to verify tokens
+func parseKey(record *models.SigningKey, secret string) (*key, error) {
+	block, _ := pem.Decode([]byte(record.PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key of signing key %s", record.ID)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key of signing key %s: %w", record.ID, err)
+	}
+	k := &key{
+		id:        record.ID,
+		purpose:   record.Purpose,
+		algorithm: record.Algorithm,
+		public:    public,
+		retired:   record.RetiredAt != nil,
+		expiresAt: record.ExpiresAt,
+		createdAt: record.CreatedAt,
+	}
+
+	privatePEM, err := utils.DecryptSecret(secret, record.PrivateKey)
+	if err != nil {
+		return k, nil
+	}
+	if block, _ = pem.Decode([]byte(privatePEM)); block == nil {
+		return k, nil
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return k, nil
+	}
+	if signer, ok := private.(crypto.Signer); ok {
+		k.signer = signer
+	}
+	return k, nil
+}
+
+// method returns the jwt signing method of the key
+func (k *key) method() jwt.SigningMethod {
+	if k.algorithm == AlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// expired reports whether the key no longer verifies tokens
+func (k *key) expired(now time.Time) bool {
+	return k.expiresAt != nil && !now.Before(*k.expiresAt)
+}
+
+// jwk returns the public part of the key
+func (k *key) jwk() JWK {
+	jwk := JWK{Kid: k.id, Use: "sig", Alg: k.algorithm}
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
@@ -0,0 +1,191 @@
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"sgs/internal/models"
+)
+
+const testSecret = "test-secret"
+
+// newTestRing returns a key ring serving the given keys instead of the database
+func newTestRing(t *testing.T, records ...*models.SigningKey) *KeyRing {
+	t.Helper()
+	ring := &KeyRing{
+		cfg:    Config{Algorithm: AlgorithmEdDSA, Secret: testSecret},
+		source: func(context.Context) ([]*models.SigningKey, error) { return records, nil },
+	}
+	if err := ring.load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func testKey(t *testing.T, purpose, algorithm string, createdAt time.Time) *models.SigningKey {
+	t.Helper()
+	record, err := generateKey(purpose, algorithm, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.CreatedAt = createdAt
+	return record
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestSignAndParse(t *testing.T) {
+	ctx := context.Background()
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			record := testKey(t, PurposeAccess, algorithm, time.Now())
+			ring := newTestRing(t, record)
+
+			token, err := ring.Sign(ctx, PurposeAccess, claims())
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Header["kid"] != record.ID || parsed.Header["alg"] != algorithm {
+				t.Errorf("unexpected header %v", parsed.Header)
+			}
+
+			got, err := ring.Parse(ctx, PurposeAccess, token)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if got["sub"] != "user-1" {
+				t.Errorf("unexpected claims %v", got)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	old := testKey(t, PurposeAccess, AlgorithmEdDSA, time.Now().Add(-time.Hour))
+	oldRing := newTestRing(t, old)
+	oldToken, err := oldRing.Sign(ctx, PurposeAccess, claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old key is retired but still verifies the tokens it signed
+	retiredAt, expiresAt := time.Now(), time.Now().Add(time.Hour)
+	old.RetiredAt, old.ExpiresAt = &retiredAt, &expiresAt
+	current := testKey(t, PurposeAccess, AlgorithmRS256, time.Now())
+	ring := newTestRing(t, old, current)
+
+	token, err := ring.Sign(ctx, PurposeAccess, claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{}); parsed.Header["kid"] != current.ID {
+		t.Errorf("expected new tokens to be signed with the active key %s, got %v", current.ID, parsed.Header["kid"])
+	}
+	if _, err := ring.Parse(ctx, PurposeAccess, oldToken); err != nil {
+		t.Errorf("expected retired key to verify its tokens, got %v", err)
+	}
+
+	if jwks := ring.JWKS(ctx, PurposeAccess); len(jwks) != 2 || jwks[0].Kid != current.ID || jwks[1].Kid != old.ID {
+		t.Errorf("expected both keys newest first, got %+v", jwks)
+	}
+
+	// expired keys no longer verify
+	expiresAt = time.Now().Add(-time.Second)
+	ring = newTestRing(t, old, current)
+	if _, err := ring.Parse(ctx, PurposeAccess, oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected expired key to be rejected, got %v", err)
+	}
+	if jwks := ring.JWKS(ctx, PurposeAccess); len(jwks) != 1 {
+		t.Errorf("expected expired key to be unpublished, got %+v", jwks)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	ctx := context.Background()
+	access := testKey(t, PurposeAccess, AlgorithmEdDSA, time.Now())
+	share := testKey(t, PurposeShare, AlgorithmEdDSA, time.Now())
+	ring := newTestRing(t, access, share)
+
+	shareToken, err := ring.Sign(ctx, PurposeShare, claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredClaims := claims()
+	expiredClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+	expiredToken, err := ring.Sign(ctx, PurposeAccess, expiredClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		wantErr error
+	}{
+		{"share token as access token", PurposeAccess, shareToken, ErrUnknownKey},
+		{"legacy token as access token", PurposeAccess, legacyToken, jwt.ErrTokenSignatureInvalid},
+		{"expired token", PurposeAccess, expiredToken, jwt.ErrTokenExpired},
+		{"share token", PurposeShare, shareToken, nil},
+		{"legacy share token", PurposeShare, legacyToken, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ring.Parse(ctx, tt.purpose, tt.token)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("expected token to verify, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJWK(t *testing.T) {
+	ctx := context.Background()
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			ring := newTestRing(t, testKey(t, PurposeAccess, algorithm, time.Now()))
+			token, err := ring.Sign(ctx, PurposeAccess, claims())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// verify the token with a key rebuilt from its jwk like another service would
+			jwk := ring.JWKS(ctx, PurposeAccess)[0]
+			var public interface{}
+			switch jwk.Kty {
+			case "OKP":
+				x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+				public = ed25519.PublicKey(x)
+			case "RSA":
+				n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+				e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+				public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			}
+			if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil }); err != nil {
+				t.Errorf("failed to verify token with jwk %+v: %v", jwk, err)
+			}
+		})
+	}
+}
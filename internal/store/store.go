@@ -24,6 +24,15 @@ var (
 	useSSL = false
 )
 
+// IsNotFound reports whether an error of the store is caused by a missing bucket or object
+func IsNotFound(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchBucket", "NoSuchKey":
+		return true
+	}
+	return false
+}
+
 type Store struct {
 	client *minio.Client
 }
@@ -41,6 +50,18 @@ func New(cfg *config.Config) (*Store, error) {
 	return &Store{client: client}, nil
 }
 
+// NewRemote sets up a client for an external S3-compatible endpoint such as another MinIO cluster, Ceph RGW or Garage
+func NewRemote(endpoint, accessKey, secretKey string, secure bool) (*Store, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{client: client}, nil
+}
+
 // CreateBucket creates a new bucket for use. A non-nil error is returned if the bucket already exists
 func (s *Store) CreateBucket(ctx context.Context, name string, enableLocking bool) error {
 	return s.client.MakeBucket(ctx, name, minio.MakeBucketOptions{ObjectLocking: enableLocking})
@@ -76,6 +97,55 @@ func (s *Store) RemoveBucket(ctx context.Context, name string) error {
 	return s.client.RemoveBucket(ctx, name)
 }
 
+// EmptyBucket removes every object, including every version of versioned objects, and incomplete upload of a bucket. Buckets that
+// do not exist are considered empty
+func (s *Store) EmptyBucket(ctx context.Context, name string) error {
+	exists, err := s.client.BucketExists(ctx, name)
+	if err != nil || !exists {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objectsCh := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(objectsCh)
+		for object := range s.client.ListObjects(ctx, name, minio.ListObjectsOptions{Recursive: true, WithVersions: true}) {
+			if object.Err != nil {
+				listErr <- object.Err
+				return
+			}
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for result := range s.client.RemoveObjects(ctx, name, objectsCh, minio.RemoveObjectsOptions{GovernanceBypass: true}) {
+		if result.Err != nil {
+			return fmt.Errorf("failed to remove object %s: %w", result.ObjectName, result.Err)
+		}
+	}
+	select {
+	case err := <-listErr:
+		return err
+	default:
+	}
+
+	for upload := range s.client.ListIncompleteUploads(ctx, name, "", true) {
+		if upload.Err != nil {
+			return upload.Err
+		}
+		if err := s.client.RemoveIncompleteUpload(ctx, name, upload.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // object operations
 
 // GetObject retrieves an object from the specified bucket and streams it into the provided io Writer
@@ -103,6 +173,76 @@ func (s *Store) CreateObject(ctx context.Context, bucketName, objectName, conten
 	return models.Object{Name: info.Key, Bucket: info.Bucket, Size: info.Size, Location: info.Location}, nil
 }
 
+// ListObjects lists up to limit objects under a prefix in a bucket whose names sort after startAfter, recursing into all
+// sub-prefixes. An empty result means that there are no more objects to list
+func (s *Store) ListObjects(ctx context.Context, bucketName, prefix, startAfter string, limit int) ([]models.Object, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	objectsCh := s.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: true, StartAfter: startAfter})
+	// the listing channel must be drained to release the underlying request
+	defer func() {
+		cancel()
+		for range objectsCh {
+		}
+	}()
+
+	objects := []models.Object{}
+	for object := range objectsCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, models.Object{
+			Bucket:       bucketName,
+			Name:         object.Key,
+			Size:         object.Size,
+			ContentType:  object.ContentType,
+			LastModified: object.LastModified,
+			ETag:         object.ETag,
+		})
+		if len(objects) == limit {
+			break
+		}
+	}
+	return objects, nil
+}
+
+// OpenObject opens an object for streaming along with its metadata. The caller is responsible for closing the reader
+func (s *Store) OpenObject(ctx context.Context, bucketName, objectName string) (io.ReadCloser, models.Object, error) {
+	object, err := s.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, models.Object{}, err
+	}
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, models.Object{}, err
+	}
+
+	return object, models.Object{
+		Bucket:       bucketName,
+		Name:         info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		Metadata:     info.UserMetadata,
+	}, nil
+}
+
+// PutObject streams the content of the reader into an object, preserving the content type and user metadata of the given object. An
+// MD5 digest is sent with every part so that the store rejects content corrupted in transit
+func (s *Store) PutObject(ctx context.Context, bucketName string, object models.Object, reader io.Reader) (models.Object, error) {
+	info, err := s.client.PutObject(ctx, bucketName, object.Name, reader, object.Size, minio.PutObjectOptions{
+		ContentType:    object.ContentType,
+		UserMetadata:   object.Metadata,
+		SendContentMd5: true,
+	})
+	if err != nil {
+		return models.Object{}, err
+	}
+
+	return models.Object{Name: info.Key, Bucket: info.Bucket, Size: info.Size, Location: info.Location, ETag: info.ETag}, nil
+}
+
 // RemoveObject deletes a saved object from the cluster
 func (s *Store) RemoveObject(ctx context.Context, bucketName, objectName string) error {
 	return s.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
@@ -0,0 +1,163 @@
+package usage
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"sgs/internal/models"
+	"sgs/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// number of uses buffered between flushes. Uses beyond it are dropped rather than slowing requests down
+	bufferSize = 4096
+	// interval at which the buffered uses are written to the database
+	flushInterval = 10 * time.Second
+	// time given to the final flush when the tracker stops
+	stopFlushTimeout = 5 * time.Second
+)
+
+// Event is a request authenticated with an API key
+type Event struct {
+	APIKeyID  uuid.UUID
+	At        time.Time
+	IP        string
+	UserAgent string
+	// bytes of the request and response bodies
+	BytesIn  int64
+	BytesOut int64
+}
+
+// Tracker records the use of API keys in the background. Uses are aggregated in memory and written in batches so that requests do
+// not wait on the database
+type Tracker struct {
+	apiKeyRepo *repository.APIKeyRepository
+	events     chan Event
+	dropped    atomic.Int64
+}
+
+// New creates a new usage tracker
+func New(apiKeyRepo *repository.APIKeyRepository) *Tracker {
+	return &Tracker{
+		apiKeyRepo: apiKeyRepo,
+		events:     make(chan Event, bufferSize),
+	}
+}
+
+// Record queues the use of an API key without blocking. The use is dropped when the buffer is full
+func (t *Tracker) Record(event Event) {
+	select {
+	case t.events <- event:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Run aggregates the recorded uses and writes them every flush interval until the context is cancelled, when the remaining uses are
+// written one last time
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	pending := newBatch()
+	for {
+		select {
+		case event := <-t.events:
+			pending.add(event)
+		case <-ticker.C:
+			pending = t.flush(ctx, pending)
+		case <-ctx.Done():
+			// drain what was recorded before the stop
+		drain:
+			for {
+				select {
+				case event := <-t.events:
+					pending.add(event)
+				default:
+					break drain
+				}
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), stopFlushTimeout)
+			t.flush(flushCtx, pending)
+			cancel()
+			return
+		}
+	}
+}
+
+// flush writes a batch and returns the batch to aggregate into next. A batch that fails to be written is kept and merged with the
+// next one, unless it grew beyond the buffer size in the meantime
+func (t *Tracker) flush(ctx context.Context, pending *batch) *batch {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		log.Printf("dropped %d api key uses, the usage buffer was full\n", dropped)
+	}
+	if pending.empty() {
+		return pending
+	}
+	uses, usage := pending.records()
+	if err := t.apiKeyRepo.RecordAPIKeyUsage(ctx, uses, usage); err != nil {
+		log.Printf("failed to record api key usage: %v\n", err)
+		if len(usage) < bufferSize {
+			return pending
+		}
+	}
+	return newBatch()
+}
+
+// dayKey identifies the counters of a key on a day
+type dayKey struct {
+	apiKeyID uuid.UUID
+	day      time.Time
+}
+
+// batch aggregates uses of API keys between flushes
+type batch struct {
+	uses  map[uuid.UUID]models.APIKeyUse
+	usage map[dayKey]*models.APIKeyUsage
+}
+
+func newBatch() *batch {
+	return &batch{
+		uses:  map[uuid.UUID]models.APIKeyUse{},
+		usage: map[dayKey]*models.APIKeyUsage{},
+	}
+}
+
+// add counts a use in the batch, keeping the most recent use of each key
+func (b *batch) add(event Event) {
+	if last, ok := b.uses[event.APIKeyID]; !ok || event.At.After(last.At) {
+		b.uses[event.APIKeyID] = models.APIKeyUse{APIKeyID: event.APIKeyID, At: event.At, IP: event.IP, UserAgent: event.UserAgent}
+	}
+
+	at := event.At.UTC()
+	key := dayKey{apiKeyID: event.APIKeyID, day: time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)}
+	day, ok := b.usage[key]
+	if !ok {
+		day = &models.APIKeyUsage{APIKeyID: key.apiKeyID, Day: key.day}
+		b.usage[key] = day
+	}
+	day.Requests++
+	day.BytesIn += event.BytesIn
+	day.BytesOut += event.BytesOut
+}
+
+func (b *batch) empty() bool {
+	return len(b.uses) == 0
+}
+
+// records returns the last uses and the daily counters of the batch
+func (b *batch) records() ([]models.APIKeyUse, []models.APIKeyUsage) {
+	uses := make([]models.APIKeyUse, 0, len(b.uses))
+	for _, use := range b.uses {
+		uses = append(uses, use)
+	}
+	usage := make([]models.APIKeyUsage, 0, len(b.usage))
+	for _, day := range b.usage {
+		usage = append(usage, *day)
+	}
+	return uses, usage
+}
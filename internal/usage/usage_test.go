@@ -0,0 +1,42 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBatchAdd(t *testing.T) {
+	key := uuid.New()
+	day := time.Date(2025, 3, 10, 23, 30, 0, 0, time.UTC)
+
+	b := newBatch()
+	b.add(Event{APIKeyID: key, At: day, IP: "10.0.0.1", BytesIn: 100, BytesOut: 10})
+	// an earlier use is counted but does not replace the last use
+	b.add(Event{APIKeyID: key, At: day.Add(-time.Minute), IP: "10.0.0.2", BytesOut: 5})
+	// the next day in UTC, although the same day in the zone of the event
+	b.add(Event{APIKeyID: key, At: day.Add(time.Hour).In(time.FixedZone("UTC-5", -5*3600)), IP: "10.0.0.3", BytesIn: 1})
+
+	uses, usage := b.records()
+	if len(uses) != 1 || uses[0].IP != "10.0.0.3" {
+		t.Fatalf("uses = %+v; want the use from 10.0.0.3", uses)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("got %d daily counters; want 2", len(usage))
+	}
+	for _, counter := range usage {
+		switch counter.Day {
+		case time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC):
+			if counter.Requests != 2 || counter.BytesIn != 100 || counter.BytesOut != 15 {
+				t.Errorf("counters of %s = %+v; want 2 requests, 100 bytes in and 15 bytes out", counter.Day, counter)
+			}
+		case time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC):
+			if counter.Requests != 1 || counter.BytesIn != 1 || counter.BytesOut != 0 {
+				t.Errorf("counters of %s = %+v; want 1 request and 1 byte in", counter.Day, counter)
+			}
+		default:
+			t.Errorf("unexpected day %s", counter.Day)
+		}
+	}
+}
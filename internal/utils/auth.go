@@ -1,6 +1,17 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is a hash of a random password, created on first use
+var dummyHash = sync.OnceValue(func() []byte {
+	password, _ := GenerateToken(16)
+	hashed, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return hashed
+})
 
 // HashPassword creates a bcrypt hash of a plain-text password
 func HashPassword(password string) (string, error) {
@@ -15,3 +26,9 @@ func HashPassword(password string) (string, error) {
 func VerifyPassword(hashed, plain string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain))
 }
+
+// VerifyDummyPassword compares a password against a hash that never matches. Logins of unknown users call it so that they take as long
+// as logins with a wrong password and do not reveal which usernames exist
+func VerifyDummyPassword(plain string) {
+	bcrypt.CompareHashAndPassword(dummyHash(), []byte(plain))
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix parses a CIDR such as 10.0.0.0/8, or a single address which is taken as a prefix of its full length
+func ParsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// PrefixesContain reports whether an address is in any of the prefixes
+func PrefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		valid bool
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", true},
+		// host bits are cleared
+		{"10.1.2.3/16", "10.1.0.0/16", true},
+		{" 192.168.1.10 ", "192.168.1.10/32", true},
+		{"::ffff:192.168.1.10", "192.168.1.10/32", true},
+		{"2001:db8::/32", "2001:db8::/32", true},
+		{"2001:db8::1", "2001:db8::1/128", true},
+		{"10.0.0.0/33", "", false},
+		{"runner-subnet", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParsePrefix(tt.value)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParsePrefix(%q) error = %v; want valid %t", tt.value, err, tt.valid)
+			continue
+		}
+		if tt.valid && got.String() != tt.want {
+			t.Errorf("ParsePrefix(%q) = %s; want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixesContain(t *testing.T) {
+	prefixes := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.20.30.40", true},
+		{"::ffff:10.20.30.40", true},
+		{"11.0.0.1", false},
+		{"2001:db8::5", true},
+		{"2001:db9::5", false},
+	}
+	for _, tt := range tests {
+		if got := PrefixesContain(prefixes, netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("PrefixesContain(%s) = %t; want %t", tt.addr, got, tt.want)
+		}
+	}
+}
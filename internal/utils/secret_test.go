@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func TestEncryptSecret(t *testing.T) {
+	sealed, err := EncryptSecret("passphrase", "source-secret")
+	if err != nil {
+		t.Fatalf("failed to encrypt secret: %v", err)
+	}
+	if sealed == "source-secret" {
+		t.Fatal("expected sealed secret to differ from the plain secret")
+	}
+
+	plain, err := DecryptSecret("passphrase", sealed)
+	if err != nil {
+		t.Fatalf("failed to decrypt secret: %v", err)
+	}
+	if plain != "source-secret" {
+		t.Errorf("expected decrypted secret to be source-secret; got %s", plain)
+	}
+
+	if _, err := DecryptSecret("other-passphrase", sealed); err == nil {
+		t.Error("expected decryption with a different passphrase to fail")
+	}
+}
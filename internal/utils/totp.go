@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults assumed by authenticator apps
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// number of periods before and after the current one in which codes are still accepted to allow for clock drift
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random base32 encoded secret for a new authenticator
+func GenerateTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(raw), nil
+}
+
+// TOTPProvisioningURI returns the otpauth uri of a secret. Authenticator apps enroll the secret by scanning the uri as a QR code
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + issuer + ":" + account, RawQuery: query.Encode()}
+	return uri.String()
+}
+
+// TOTPCode returns the code of a secret for the period with the given counter
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000), nil
+}
+
+// ValidateTOTP checks a code against the periods around the given time and returns the counter of the matching period. Callers
+// should reject counters that were already used so that a code cannot be replayed
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		expected, err := TOTPCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// base32 encoding of the RFC 6238 sha1 test secret "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC test vectors use 8 digits. 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("failed to generate code: %v", err)
+		}
+		if code != tt.code {
+			t.Errorf("expected code %s at %d; got %s", tt.code, tt.unix, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	if counter, ok := ValidateTOTP(rfcSecret, "081804", now); !ok || counter != 1111111109/totpPeriod {
+		t.Fatalf("expected current code to be valid; got %d %v", counter, ok)
+	}
+	// codes of the previous period are accepted for clock drift
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now.Add(totpPeriod*time.Second)); !ok {
+		t.Error("expected code of the previous period to be valid")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now.Add(3*totpPeriod*time.Second)); ok {
+		t.Error("expected code of an old period to be rejected")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, "000000", now); ok {
+		t.Error("expected wrong code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("sgs", "ama", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/sgs:ama?") || !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("unexpected provisioning uri %s", uri)
+	}
+}
@@ -52,9 +52,7 @@ export function CreateAPIKeyModal({
         projectId: "",
         expiresAt: "1y",
     });
-    const [createdKey, setCreatedKey] = useState<
-        (APIKey & { token: string }) | null
-    >(null);
+    const [createdKey, setCreatedKey] = useState<APIKey | null>(null);
 
     const expirationOptions = [
         { value: "30d", label: "30 days", description: "Short-term access" },
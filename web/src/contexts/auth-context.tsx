@@ -126,7 +126,10 @@ export function AuthProvider({ children }: { children: ReactNode }) {
 
             if (response.data?.token && response.data?.user) {
                 // Set token in API client
-                apiClient.setToken(response.data.token);
+                apiClient.setToken(
+                    response.data.token,
+                    response.data.refreshToken
+                );
 
                 // Set user in context
                 setUser(response.data.user);
@@ -186,8 +189,8 @@ export function AuthProvider({ children }: { children: ReactNode }) {
         // Clear user from context
         setUser(null);
 
-        // Clear from API client
-        apiClient.clearToken();
+        // End the session and clear from API client
+        await apiClient.logout();
 
         // Clear from localStorage
         clearStoredAuth();
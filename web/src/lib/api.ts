@@ -1,4 +1,8 @@
-import axios, { type AxiosInstance, type AxiosResponse } from "axios";
+import axios, {
+    type AxiosInstance,
+    type AxiosResponse,
+    type InternalAxiosRequestConfig,
+} from "axios";
 import type {
     APIResponse,
     User,
@@ -10,14 +14,20 @@ import type {
     CreateProjectRequest,
     CreateAPIKeyRequest,
     DashboardStats,
+    LoginResponse,
+    TokenPair,
 } from "@/types/api";
 
 // Local storage keys
 const AUTH_TOKEN_KEY = "sgs_auth_token";
+const REFRESH_TOKEN_KEY = "sgs_refresh_token";
 
 class APIClient {
     private client: AxiosInstance;
     private token: string | null = null;
+    private refreshToken: string | null = null;
+    // shared by concurrent requests that fail while the session is refreshed
+    private refreshing: Promise<boolean> | null = null;
 
     constructor(baseURL: string = import.meta.env.VITE_API_URL) {
         this.client = axios.create({
@@ -31,6 +41,7 @@ class APIClient {
 
         // Load token from localStorage on initialization
         this.token = localStorage.getItem(AUTH_TOKEN_KEY);
+        this.refreshToken = localStorage.getItem(REFRESH_TOKEN_KEY);
         if (this.token) {
             this.setAuthHeader(this.token);
         }
@@ -51,8 +62,23 @@ class APIClient {
         // Response interceptor for error handling and token management
         this.client.interceptors.response.use(
             (response: AxiosResponse) => response,
-            (error) => {
-                // Handle 401 Unauthorized - clear token and redirect to login
+            async (error) => {
+                // Handle 401 Unauthorized - refresh the session once and retry, otherwise clear the tokens
+                const request = error.config as
+                    | (InternalAxiosRequestConfig & { _retried?: boolean })
+                    | undefined;
+                if (
+                    error.response?.status === 401 &&
+                    request &&
+                    !request._retried &&
+                    !request.url?.startsWith("/auth/")
+                ) {
+                    request._retried = true;
+                    if (await this.refreshSession()) {
+                        request.headers.Authorization = `Bearer ${this.token}`;
+                        return this.client(request);
+                    }
+                }
                 if (error.response?.status === 401) {
                     this.clearToken();
                 }
@@ -78,18 +104,50 @@ class APIClient {
     }
 
     // Token management
-    setToken(token: string) {
+    setToken(token: string, refreshToken?: string) {
         this.token = token;
         localStorage.setItem(AUTH_TOKEN_KEY, token);
+        if (refreshToken) {
+            this.refreshToken = refreshToken;
+            localStorage.setItem(REFRESH_TOKEN_KEY, refreshToken);
+        }
         this.setAuthHeader(token);
     }
 
     clearToken() {
         this.token = null;
+        this.refreshToken = null;
         localStorage.removeItem(AUTH_TOKEN_KEY);
+        localStorage.removeItem(REFRESH_TOKEN_KEY);
         this.removeAuthHeader();
     }
 
+    // refreshSession exchanges the refresh token for a new token pair. Returns false when the session can't be refreshed
+    private refreshSession(): Promise<boolean> {
+        if (!this.refreshToken) {
+            return Promise.resolve(false);
+        }
+        if (!this.refreshing) {
+            this.refreshing = this.client
+                .post<APIResponse<TokenPair>>("/auth/refresh", {
+                    refreshToken: this.refreshToken,
+                })
+                .then((response) => {
+                    const tokens = response.data.data;
+                    if (!tokens) {
+                        return false;
+                    }
+                    this.setToken(tokens.token, tokens.refreshToken);
+                    return true;
+                })
+                .catch(() => false)
+                .finally(() => {
+                    this.refreshing = null;
+                });
+        }
+        return this.refreshing;
+    }
+
     getToken(): string | null {
         return this.token;
     }
@@ -110,9 +168,7 @@ class APIClient {
         }
     }
 
-    async login(
-        data: LoginRequest
-    ): Promise<APIResponse<{ user: User; token: string }>> {
+    async login(data: LoginRequest): Promise<APIResponse<LoginResponse>> {
         try {
             const response = await this.client.post("/auth/login", data);
             return response.data;
@@ -122,6 +178,16 @@ class APIClient {
     }
 
     async logout() {
+        // end the session on the server. The tokens are cleared even if the request fails
+        if (this.refreshToken) {
+            try {
+                await this.client.post("/auth/logout", {
+                    refreshToken: this.refreshToken,
+                });
+            } catch (error) {
+                console.error("Failed to end session:", error);
+            }
+        }
         this.clearToken();
     }
 
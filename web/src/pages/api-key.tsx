@@ -23,7 +23,6 @@ import {
 } from "@/components/ui/dropdown-menu";
 import {
     ArrowLeft,
-    Copy,
     Key,
     MoreHorizontal,
     Plus,
@@ -75,13 +74,6 @@ export default function APIKeysPage() {
         }
     };
 
-    const copyToClipboard = (text: string) => {
-        navigator.clipboard.writeText(text);
-        toast.success("Copied to clipboard", {
-            description: "API key has been copied to your clipboard.",
-        });
-    };
-
     const revokeKey = async (keyId: string) => {
         try {
             await apiClient.revokeAPIKey(keyId);
@@ -409,24 +401,11 @@ export default function APIKeysPage() {
                                                     <TableCell>
                                                         <div className="flex items-center space-x-2">
                                                             <code className="text-sm bg-slate-100 dark:bg-slate-800 px-3 py-1 rounded-md font-mono border border-slate-200 dark:border-slate-700">
-                                                                {key.token.substring(
-                                                                    0,
-                                                                    16
-                                                                )}
+                                                                {
+                                                                    key.tokenPrefix
+                                                                }
                                                                 ...
                                                             </code>
-                                                            <Button
-                                                                variant="ghost"
-                                                                size="sm"
-                                                                onClick={() =>
-                                                                    copyToClipboard(
-                                                                        key.token
-                                                                    )
-                                                                }
-                                                                className="hover:bg-primary/10 transition-all hover:scale-110"
-                                                            >
-                                                                <Copy className="h-3 w-3" />
-                                                            </Button>
                                                         </div>
                                                     </TableCell>
                                                     <TableCell>
@@ -471,17 +450,6 @@ export default function APIKeysPage() {
                                                                 align="end"
                                                                 className="w-48"
                                                             >
-                                                                <DropdownMenuItem
-                                                                    onClick={() =>
-                                                                        copyToClipboard(
-                                                                            key.token
-                                                                        )
-                                                                    }
-                                                                    className="cursor-pointer"
-                                                                >
-                                                                    <Copy className="h-4 w-4 mr-2" />
-                                                                    Copy Token
-                                                                </DropdownMenuItem>
                                                                 {!isRevoked(
                                                                     key.revokedAt
                                                                 ) && (
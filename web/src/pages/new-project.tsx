@@ -16,7 +16,6 @@ import { useNavigate } from "react-router-dom";
 import { toast } from "sonner";
 import { apiClient } from "@/lib/api";
 import type { CreateProjectRequest } from "@/types/api";
-import { useAuth } from "@/contexts/auth-context";
 
 export default function NewProjectPage() {
     const navigate = useNavigate();
@@ -24,8 +23,6 @@ export default function NewProjectPage() {
     const [formData, setFormData] = useState({
         bucket: "",
     });
-    // get current user id
-    const { user } = useAuth();
 
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
@@ -35,7 +32,6 @@ export default function NewProjectPage() {
             // save details
             const data: CreateProjectRequest = {
                 bucket: formData.bucket,
-                ownerId: user?.id ?? "",
             };
 
             const response = await apiClient.createProject(data);
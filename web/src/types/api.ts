@@ -30,16 +30,58 @@ export interface AppFile {
 
 export interface APIKey {
     id: string;
-    token: string;
+    // only returned when the key is created
+    token?: string;
+    tokenPrefix: string;
     name: string;
     projectId: string;
     userId: string;
     expiresAt: string;
     revokedAt?: string;
     createdAt: string;
+    scopes: string[];
+    rotatedFrom?: string;
+    replacedBy?: string;
+    revokesAt?: string;
+    lastUsedAt?: string;
+    lastUsedIp?: string;
+    lastUsedUserAgent?: string;
+    restrictions: APIKeyRestrictions;
+    // keys in signing mode only accept signed requests
+    signed: boolean;
+    // only returned when a key in signing mode is created or rotated
+    signingSecret?: string;
+    usage?: APIKeyUsage[];
     projectBucket?: string;
 }
 
+export interface APIKeyRestrictions {
+    allowedCidrs?: string[];
+    // 0 for Sunday to 6 for Saturday
+    allowedDays?: number[];
+    // end is excluded. windows ending before they start span midnight
+    allowedHours?: { start: number; end: number };
+    timezone?: string;
+}
+
+export interface APIKeyDenial {
+    id: string;
+    apiKeyId: string;
+    reason: 'expired' | 'revoked' | 'route' | 'scope' | 'project' | 'ip' | 'day' | 'hour' | 'unsigned' | 'signature' | 'replay';
+    ip: string;
+    userAgent: string;
+    method: string;
+    path: string;
+    createdAt: string;
+}
+
+export interface APIKeyUsage {
+    day: string;
+    requests: number;
+    bytesIn: number;
+    bytesOut: number;
+}
+
 export interface DashboardStats {
     totalProjects: number;
     totalFiles: number;
@@ -59,18 +101,30 @@ export interface LoginRequest {
     password: string;
 }
 
+export interface TokenPair {
+    token: string;
+    refreshToken: string;
+    expiresAt: string;
+}
+
+export interface LoginResponse extends TokenPair {
+    user: User;
+}
+
 export interface RegisterRequest {
     username: string;
     password: string;
     fullName?: string;
 }
 
+// projects are owned by the user creating them
 export interface CreateProjectRequest {
     bucket: string;
-    ownerId: string;
 }
 
 export interface CreateAPIKeyRequest {
     name: string;
     expiresAt: string;
+    // defaults to every scope except keys:manage
+    scopes?: string[];
 }